@@ -0,0 +1,158 @@
+package gora
+
+import (
+	"github.com/hillu/go-yara/v4"
+)
+
+// SetTagFilter restricts the reported matches to the rules having at least one of the given tags. Calling it without
+// tags removes the filter.
+//
+// The filter is applied in the scan callback, so all the rules are still evaluated by YARA and the scan performance
+// does not change. Rules are not disabled since other rules may depend on the filtered ones.
+func (c *Compiled) SetTagFilter(tags ...string) *Compiled {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tags = nil
+	if len(tags) > 0 {
+		c.tags = make(map[string]struct{}, len(tags))
+		for _, tag := range tags {
+			c.tags[tag] = struct{}{}
+		}
+	}
+	c.updateCallback()
+	return c
+}
+
+// SetModuleData sets the data passed to the given YARA module when a rule imports it, calling it with nil data removes
+// it. The data takes precedence over the data provided by the ScanCallbackModuleImport implementation of the callback.
+//
+// Only the modules consuming module data make use of it, such as the cuckoo module which expects the JSON report of the
+// Cuckoo sandbox. The cuckoo module is available only if the linked YARA library is built with --enable-cuckoo, the
+// modules enabled by default (pe, elf, math, time, hash, dotnet, etc.) ignore the data.
+func (c *Compiled) SetModuleData(module string, data []byte) *Compiled {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// The map is replaced instead of being modified since the callbacks of the sweeps in progress share it.
+	modules := make(map[string][]byte, len(c.modules)+1)
+	for k, v := range c.modules {
+		modules[k] = v
+	}
+	if data == nil {
+		delete(modules, module)
+	} else {
+		modules[module] = data
+	}
+	c.modules = modules
+	c.updateCallback()
+	return c
+}
+
+// updateCallback sets the scanner callback again to apply the changed filters and module data. The caller must hold
+// c.mu.
+func (c *Compiled) updateCallback() {
+	if c.scanner != nil {
+		c.scanner.SetCallback(c.wrapCallback(c.callback))
+	}
+}
+
+// wrapCallback wraps the given callback to apply the match filters and the module data. The caller must hold c.mu.
+func (c *Compiled) wrapCallback(cb yara.ScanCallback) yara.ScanCallback {
+	return wrapFilters(cb, c.tags, c.modules)
+}
+
+// wrapFilters wraps the given callback to apply the given tag filter and module data. It returns the callback itself
+// if there is neither a filter nor module data.
+func wrapFilters(cb yara.ScanCallback, tags map[string]struct{}, modules map[string][]byte) yara.ScanCallback {
+	if len(tags) == 0 && len(modules) == 0 {
+		return cb
+	}
+	fcb := &filterCallback{
+		cb:      cb,
+		tags:    tags,
+		modules: modules,
+	}
+	// Not matching rules are reported by YARA only if the callback implements ScanCallbackNoMatch.
+	if _, ok := cb.(yara.ScanCallbackNoMatch); ok {
+		return &filterCallbackNoMatch{fcb}
+	}
+	return fcb
+}
+
+// filterCallback implements the yara.ScanCallback interface by filtering the matching rules before calling the
+// underlying callback and by providing the module data. It forwards the other events to the underlying callback if it
+// implements the corresponding interface.
+type filterCallback struct {
+	cb      yara.ScanCallback
+	tags    map[string]struct{}
+	modules map[string][]byte
+}
+
+var (
+	_ yara.ScanCallback                     = (*filterCallback)(nil)
+	_ yara.ScanCallbackFinished             = (*filterCallback)(nil)
+	_ yara.ScanCallbackModuleImport         = (*filterCallback)(nil)
+	_ yara.ScanCallbackModuleImportFinished = (*filterCallback)(nil)
+	_ yara.ScanCallbackConsoleLog           = (*filterCallback)(nil)
+	_ yara.ScanCallbackNoMatch              = (*filterCallbackNoMatch)(nil)
+)
+
+func (f *filterCallback) RuleMatching(sc *yara.ScanContext, r *yara.Rule) (bool, error) {
+	if f.cb == nil || !f.accept(r) {
+		return false, nil
+	}
+	return f.cb.RuleMatching(sc, r)
+}
+
+func (f *filterCallback) ScanFinished(sc *yara.ScanContext) (bool, error) {
+	if cb, ok := f.cb.(yara.ScanCallbackFinished); ok {
+		return cb.ScanFinished(sc)
+	}
+	return false, nil
+}
+
+func (f *filterCallback) ImportModule(sc *yara.ScanContext, module string) ([]byte, bool, error) {
+	if data, ok := f.modules[module]; ok {
+		return data, false, nil
+	}
+	if cb, ok := f.cb.(yara.ScanCallbackModuleImport); ok {
+		return cb.ImportModule(sc, module)
+	}
+	return nil, false, nil
+}
+
+func (f *filterCallback) ModuleImported(sc *yara.ScanContext, obj *yara.Object) (bool, error) {
+	if cb, ok := f.cb.(yara.ScanCallbackModuleImportFinished); ok {
+		return cb.ModuleImported(sc, obj)
+	}
+	return false, nil
+}
+
+func (f *filterCallback) ConsoleLog(sc *yara.ScanContext, msg string) {
+	if cb, ok := f.cb.(yara.ScanCallbackConsoleLog); ok {
+		cb.ConsoleLog(sc, msg)
+	}
+}
+
+func (f *filterCallback) accept(r *yara.Rule) bool {
+	if len(f.tags) == 0 {
+		return true
+	}
+	for _, tag := range r.Tags() {
+		if _, ok := f.tags[tag]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filterCallbackNoMatch is a filterCallback which also forwards the not matching rules.
+type filterCallbackNoMatch struct {
+	*filterCallback
+}
+
+func (f *filterCallbackNoMatch) RuleNotMatching(sc *yara.ScanContext, r *yara.Rule) (bool, error) {
+	if !f.accept(r) {
+		return false, nil
+	}
+	return f.cb.(yara.ScanCallbackNoMatch).RuleNotMatching(sc, r)
+}
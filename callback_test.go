@@ -0,0 +1,119 @@
+package gora_test
+
+import (
+	"testing"
+
+	"github.com/hillu/go-yara/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/binalyze/gora"
+)
+
+const rulestrTagged = `
+rule r_ransomware : ransomware { strings: $a = "test" condition: $a }
+rule r_trojan : trojan { strings: $a = "test" condition: $a }
+rule r_both : ransomware trojan { strings: $a = "test" condition: $a }
+rule r_untagged { strings: $a = "test" condition: $a }
+`
+
+func TestSetTagFilter(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+
+	require.NoError(t, comp.CompileString(gora.ScanFile, rulestrTagged, ""))
+	require.NoError(t, comp.CreateScanner())
+
+	scan := func() []string {
+		var matches yara.MatchRules
+		require.NoError(t, comp.SetCallback(&matches).ScanMem([]byte("test")))
+		return matchedRuleNames(matches)
+	}
+
+	require.Equal(t, []string{"r_ransomware", "r_trojan", "r_both", "r_untagged"}, scan())
+
+	comp.SetTagFilter("ransomware")
+	require.Equal(t, []string{"r_ransomware", "r_both"}, scan())
+
+	comp.SetTagFilter("trojan", "unknown")
+	require.Equal(t, []string{"r_trojan", "r_both"}, scan())
+
+	comp.SetTagFilter()
+	require.Equal(t, []string{"r_ransomware", "r_trojan", "r_both", "r_untagged"}, scan())
+}
+
+func TestSetTagFilter_noMatch(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+
+	require.NoError(t, comp.CompileString(gora.ScanFile, rulestrTagged, ""))
+	require.NoError(t, comp.CreateScanner())
+
+	cb := new(noMatchCallback)
+	comp.SetTagFilter("trojan").SetCallback(cb)
+	require.NoError(t, comp.ScanMem([]byte("none")))
+	require.Empty(t, cb.matching)
+	require.Equal(t, []string{"r_trojan", "r_both"}, cb.notMatching)
+}
+
+type noMatchCallback struct {
+	matching    []string
+	notMatching []string
+}
+
+func (cb *noMatchCallback) RuleMatching(_ *yara.ScanContext, r *yara.Rule) (bool, error) {
+	cb.matching = append(cb.matching, r.Identifier())
+	return false, nil
+}
+
+func (cb *noMatchCallback) RuleNotMatching(_ *yara.ScanContext, r *yara.Rule) (bool, error) {
+	cb.notMatching = append(cb.notMatching, r.Identifier())
+	return false, nil
+}
+
+func matchedRuleNames(matches yara.MatchRules) []string {
+	names := make([]string, 0, len(matches))
+	for _, m := range matches {
+		names = append(names, m.Rule)
+	}
+	return names
+}
+
+func TestSetModuleData(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+
+	rule := `
+import "pe"
+import "math"
+rule r_module { condition: not defined pe.number_of_sections and math.entropy(0, filesize) >= 0 }
+`
+	require.NoError(t, comp.CompileString(gora.ScanFile, rule, ""))
+	require.NoError(t, comp.CreateScanner())
+
+	cb := new(moduleImportCallback)
+	comp.SetModuleData("pe", []byte("{}")).SetCallback(cb)
+	require.NoError(t, comp.ScanMem([]byte("test")))
+	require.Equal(t, []string{"r_module"}, matchedRuleNames(cb.matches))
+	// The module having data is not asked to the callback.
+	require.Equal(t, []string{"math"}, cb.imports)
+
+	cb.matches, cb.imports = nil, nil
+	comp.SetModuleData("pe", nil)
+	require.NoError(t, comp.ScanMem([]byte("test")))
+	require.Equal(t, []string{"r_module"}, matchedRuleNames(cb.matches))
+	require.ElementsMatch(t, []string{"pe", "math"}, cb.imports)
+}
+
+type moduleImportCallback struct {
+	matches yara.MatchRules
+	imports []string
+}
+
+func (cb *moduleImportCallback) RuleMatching(sc *yara.ScanContext, r *yara.Rule) (bool, error) {
+	return cb.matches.RuleMatching(sc, r)
+}
+
+func (cb *moduleImportCallback) ImportModule(_ *yara.ScanContext, module string) ([]byte, bool, error) {
+	cb.imports = append(cb.imports, module)
+	return nil, false, nil
+}
@@ -0,0 +1,168 @@
+package gora
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hillu/go-yara/v4"
+
+	"github.com/binalyze/gora/variables"
+)
+
+// ErrRuleNotFound is returned by Explain when the compiled rules do not have the given rule.
+var ErrRuleNotFound = errors.New("rule not found")
+
+// MatchExplanation describes how a rule is evaluated against a file, see Explain.
+type MatchExplanation struct {
+	Namespace string
+	Rule      string
+	Matched   bool
+	// Strings holds the strings of the rule in the order they are declared. Anonymous strings are identified by "$".
+	Strings []StringExplanation
+	// Modules holds the names of the modules imported by the rules during the scan.
+	Modules []string
+	// Variables holds the external variable values defined for the scan.
+	Variables map[string]interface{}
+}
+
+// StringExplanation describes the matches of a rule string.
+type StringExplanation struct {
+	Identifier string
+	Matched    bool
+	// Offsets holds the offsets of the matches in the file.
+	Offsets []int64
+}
+
+// MatchedStrings returns the identifiers of the strings having at least one match.
+func (e *MatchExplanation) MatchedStrings() []string {
+	return e.stringIdentifiers(true)
+}
+
+// MissingStrings returns the identifiers of the strings which do not match.
+func (e *MatchExplanation) MissingStrings() []string {
+	return e.stringIdentifiers(false)
+}
+
+func (e *MatchExplanation) stringIdentifiers(matched bool) []string {
+	var ids []string
+	for _, s := range e.Strings {
+		if s.Matched == matched {
+			ids = append(ids, s.Identifier)
+		}
+	}
+	return ids
+}
+
+// Explain scans the file to diagnose why the given rule matches or not. The rule is identified by its identifier,
+// optionally qualified by its namespace as "namespace:identifier" if multiple namespaces have the same identifier. The
+// scanner variables are defined for the file as ScanTree does.
+//
+// YARA does not report how the conditions are evaluated, so the explanation is limited to the matches of the rule's
+// strings, the imported modules and the variable values which the conditions depend on. The tag filter and the callback
+// set by SetCallback are not applied.
+func (c *Compiled) Explain(filename string, ruleID string) (*MatchExplanation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scanner == nil {
+		return nil, ErrScannerNotCreated
+	}
+	rule, ok := c.findRule(ruleID)
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", ErrRuleNotFound, ruleID)
+	}
+	info, err := c.checkScanFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var sctx variables.ScanContextImpl
+	sctx.SetFilePath(filename)
+	sctx.SetFileInfo(info)
+	if err = c.recordScannerVariables(&sctx, c.vars.DefineScannerVariables); err != nil {
+		return nil, err
+	}
+
+	cb := &explainCallback{
+		explanation: &MatchExplanation{
+			Namespace: rule.Namespace(),
+			Rule:      rule.Identifier(),
+			Variables: c.variableValues(),
+		},
+		modules: c.modules,
+	}
+	prev := c.scanner.Callback
+	defer c.scanner.SetCallback(prev)
+
+	if err = c.scanner.SetCallback(cb).ScanFile(extendedPath(filename)); err != nil {
+		return nil, err
+	}
+	if !cb.evaluated {
+		return nil, fmt.Errorf("%w: '%s' is not evaluated", ErrRuleNotFound, ruleID)
+	}
+	return cb.explanation, nil
+}
+
+// findRule returns the compiled rule having the given identifier, which may be qualified by its namespace. Since the
+// namespaces may contain colons, e.g. the paths on Windows, but the identifiers can not, it is split at the last colon.
+func (c *Compiled) findRule(ruleID string) (*yara.Rule, bool) {
+	if i := strings.LastIndex(ruleID, ":"); i >= 0 {
+		return c.getRule(ruleID[:i], ruleID[i+1:])
+	}
+	if c.rules == nil {
+		return nil, false
+	}
+	rules := c.rules.GetRules()
+	for i := range rules {
+		if rules[i].Identifier() == ruleID {
+			return &rules[i], true
+		}
+	}
+	return nil, false
+}
+
+// explainCallback implements the yara.ScanCallback interface to collect the explanation of a single rule.
+type explainCallback struct {
+	explanation *MatchExplanation
+	modules     map[string][]byte
+	evaluated   bool
+}
+
+var (
+	_ yara.ScanCallback             = (*explainCallback)(nil)
+	_ yara.ScanCallbackNoMatch      = (*explainCallback)(nil)
+	_ yara.ScanCallbackModuleImport = (*explainCallback)(nil)
+)
+
+func (e *explainCallback) RuleMatching(sc *yara.ScanContext, r *yara.Rule) (bool, error) {
+	e.explain(sc, r, true)
+	return false, nil
+}
+
+func (e *explainCallback) RuleNotMatching(sc *yara.ScanContext, r *yara.Rule) (bool, error) {
+	e.explain(sc, r, false)
+	return false, nil
+}
+
+func (e *explainCallback) ImportModule(_ *yara.ScanContext, module string) ([]byte, bool, error) {
+	e.explanation.Modules = append(e.explanation.Modules, module)
+	return e.modules[module], false, nil
+}
+
+func (e *explainCallback) explain(sc *yara.ScanContext, r *yara.Rule, matched bool) {
+	if r.Identifier() != e.explanation.Rule || r.Namespace() != e.explanation.Namespace {
+		return
+	}
+	e.evaluated = true
+	e.explanation.Matched = matched
+	for _, s := range r.Strings() {
+		s := s
+		se := StringExplanation{Identifier: s.Identifier()}
+		for _, m := range s.Matches(sc) {
+			m := m
+			se.Offsets = append(se.Offsets, m.Base()+m.Offset())
+		}
+		se.Matched = len(se.Offsets) > 0
+		e.explanation.Strings = append(e.explanation.Strings, se)
+	}
+}
@@ -0,0 +1,87 @@
+package gora_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/binalyze/gora"
+	"github.com/binalyze/gora/variables"
+)
+
+const rulestrExplain = `
+import "math"
+
+rule explained {
+	strings:
+		$a = "alpha"
+		$b = "bravo"
+		$c = "charlie"
+	condition:
+		all of them and file_extension == "txt"
+}
+
+rule other {
+	condition:
+		math.entropy(0, filesize) >= 0
+}
+`
+
+func TestExplain(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileString(gora.ScanFile, rulestrExplain, "ns"))
+
+	path := filepath.Join(t.TempDir(), "fixture.txt")
+	require.NoError(t, os.WriteFile(path, []byte("alpha and charlie, alpha again"), 0o666))
+
+	_, err := comp.Explain(path, "explained")
+	require.ErrorIs(t, err, gora.ErrScannerNotCreated)
+	require.NoError(t, comp.CreateScanner())
+
+	exp, err := comp.Explain(path, "explained")
+	require.NoError(t, err)
+	require.Equal(t, "ns", exp.Namespace)
+	require.Equal(t, "explained", exp.Rule)
+	require.False(t, exp.Matched)
+	require.Equal(t, []gora.StringExplanation{
+		{Identifier: "$a", Matched: true, Offsets: []int64{0, 19}},
+		{Identifier: "$b"},
+		{Identifier: "$c", Matched: true, Offsets: []int64{10}},
+	}, exp.Strings)
+	require.Equal(t, []string{"$a", "$c"}, exp.MatchedStrings())
+	require.Equal(t, []string{"$b"}, exp.MissingStrings())
+	require.Equal(t, []string{"math"}, exp.Modules)
+	require.Equal(t, "txt", exp.Variables[variables.VarFileExtension.String()])
+
+	// The rule matches once all of its strings appear.
+	require.NoError(t, os.WriteFile(path, []byte("alpha bravo charlie"), 0o666))
+	exp, err = comp.Explain(path, "ns:explained")
+	require.NoError(t, err)
+	require.True(t, exp.Matched)
+	require.Empty(t, exp.MissingStrings())
+
+	_, err = comp.Explain(path, "missing")
+	require.ErrorIs(t, err, gora.ErrRuleNotFound)
+	_, err = comp.Explain(path, "other_ns:explained")
+	require.ErrorIs(t, err, gora.ErrRuleNotFound)
+	_, err = comp.Explain(filepath.Dir(path), "explained")
+	require.ErrorIs(t, err, gora.ErrNotRegularFile)
+}
+
+func TestExplain_namespaceWithColon(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileString(gora.ScanFile, rulestrExplain, `C:\rules\explain.yar`))
+	require.NoError(t, comp.CreateScanner())
+
+	path := filepath.Join(t.TempDir(), "fixture.txt")
+	require.NoError(t, os.WriteFile(path, []byte("alpha bravo charlie"), 0o666))
+
+	exp, err := comp.Explain(path, `C:\rules\explain.yar:explained`)
+	require.NoError(t, err)
+	require.Equal(t, `C:\rules\explain.yar`, exp.Namespace)
+	require.True(t, exp.Matched)
+}
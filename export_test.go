@@ -0,0 +1,11 @@
+package gora
+
+import "github.com/hillu/go-yara/v4"
+
+// SetNewScanner replaces the scanner constructor of CreateScanner and NewScannerWithFlags until the returned function
+// is called.
+func SetNewScanner(fn func(*yara.Rules) (*yara.Scanner, error)) (restore func()) {
+	prev := newScanner
+	newScanner = fn
+	return func() { newScanner = prev }
+}
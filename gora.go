@@ -1,35 +1,107 @@
 package gora
 
 import (
+	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/hillu/go-yara/v4"
 
 	"github.com/binalyze/gora/variables"
 )
 
-var ErrAlreadyCompiled = errors.New("already compiled")
+var (
+	ErrAlreadyCompiled = errors.New("already compiled")
+	ErrNotCompiled     = errors.New("not compiled")
+	ErrNotAppendable   = errors.New("rules compiled from files can not be appended")
+	// ErrScannerNotCreated is returned by the scan methods if the scanner is not created by CreateScanner yet or it is
+	// destroyed by Destroy.
+	ErrScannerNotCreated = errors.New("scanner is not created")
+	// ErrNotRegularFile is returned by the file scan methods if the path is not a regular file, e.g. a directory or a
+	// device.
+	ErrNotRegularFile = errors.New("not a regular file")
+	// ErrNamespaceNotFound is returned by ScanFileNamespaces if the compiled rules do not have the given namespace.
+	ErrNamespaceNotFound = errors.New("namespace not found")
+	// ErrMaxScanBytes is returned if a scan or a content derived variable exceeds the limit set by SetMaxScanBytes.
+	ErrMaxScanBytes = variables.ErrMaxScanBytes
+
+	// CreateScannerRetry is the retry policy of CreateScanner and NewScannerWithFlags for the transient errors of
+	// libyara, which are ERROR_INSUFFICIENT_MEMORY and ERROR_TOO_MANY_SCAN_THREADS. The other errors are returned
+	// without retrying. By default, it is not retried.
+	CreateScannerRetry variables.RetryPolicy
+)
+
+// Transient errors of libyara retried by CreateScanner.
+const (
+	errInsufficientMemory yara.Error = 1
+	errTooManyScanThreads yara.Error = 27
+)
+
+// newScanner creates the scanner of CreateScanner. It is replaced by the tests to simulate the failures.
+var newScanner = yara.NewScanner
 
 // ScanTarget represents a target for yara scan.
 type ScanTarget byte
 
-// Scan targets are file system and process memory.
+// Scan targets are file system and process memory. ScanFileProcess enables the variables of both targets, so the same
+// compiled rules can scan either of them. Note that the variables not applicable to the scanned target are defined
+// with their default values, e.g. process_name is "" while scanning a file.
 const (
 	ScanFile ScanTarget = iota
 	ScanProcess
+	ScanFileProcess
 )
 
 // Compiled holds the compiled rules and its associated external variables.
 type Compiled struct {
-	vars    *variables.Variables
-	rules   *yara.Rules
-	scanner *yara.Scanner
+	// mu guards the scanner and the rules against concurrent scans and Destroy.
+	mu sync.Mutex
+	// rulesMu guards the lifetime of the rules. The sweeps scanning with their own scanners, e.g. ScanTreeParallel, hold
+	// it for reading instead of holding mu, so they only block Destroy and the methods replacing the rules. It is
+	// locked before mu.
+	rulesMu  sync.RWMutex
+	vars     *variables.Variables
+	rules    *yara.Rules
+	scanner  *yara.Scanner
+	values   map[string]interface{}
+	nsFunc   NamespaceFunc
+	nsPrefix string
+	nsUnique bool
+	callback yara.ScanCallback
+	tags     map[string]struct{}
+	modules  map[string][]byte
+	lenient  bool
+	procEnum ProcessEnumerator
+	procInfo variables.ProcessInfoFactory
+	target   ScanTarget
+	sources  []RuleNamespace
+	maxBytes int64
+	blockSz  int
+}
+
+// ScanResult holds the matched rules of a scan and the values of the external variables defined for that scan.
+type ScanResult struct {
+	Matches   yara.MatchRules
+	Variables map[string]interface{}
+}
+
+// CompiledStats holds the statistics of a compiled instance.
+type CompiledStats struct {
+	Rules      int
+	Namespaces int
+	Variables  int
+	HasScanner bool
 }
 
 func NewCompiled() *Compiled {
@@ -49,26 +121,134 @@ func (c *Compiled) CompileString(target ScanTarget, rule, namespace string) erro
 	return c.CompileStrings(target, []RuleNamespace{{Rule: rule, Namespace: namespace}})
 }
 
+// CompileReader reads the YARA rules from the given reader, e.g. os.Stdin, and compiles them as CompileString does.
+// Since both the variable parser and the YARA compiler need the rules, the reader is read to the end before compiling.
+// The read rules are kept as in CompileString, so AppendStrings can be used. Use CompileFiles for the rule files.
+func (c *Compiled) CompileReader(target ScanTarget, r io.Reader, namespace string) error {
+	if c.rules != nil {
+		return ErrAlreadyCompiled
+	}
+	var sb strings.Builder
+	if _, err := io.Copy(&sb, r); err != nil {
+		return fmt.Errorf("read rules error: %w", err)
+	}
+	return c.CompileString(target, sb.String(), namespace)
+}
+
 // CompileStrings compiles the YARA rules.
 func (c *Compiled) CompileStrings(target ScanTarget, ruleNs []RuleNamespace) error {
 	if c.rules != nil {
 		return ErrAlreadyCompiled
 	}
 
+	rules, err := c.compileStrings(target, ruleNs)
+	if err != nil {
+		return err
+	}
+	c.rules = rules
+	c.target = target
+	c.sources = append(make([]RuleNamespace, 0, len(ruleNs)), ruleNs...)
+	return nil
+}
+
+// AppendStrings adds the given YARA rules to the rules compiled by CompileString, CompileStrings or CompileMap. Since
+// YARA can not add rules to the compiled rules, all the rules are recompiled together for the same scan target, and
+// the variables are parsed again from the combined rules. The existing scanner is destroyed, so CreateScanner must be
+// called before scanning. If the compilation fails, the existing rules are kept.
+func (c *Compiled) AppendStrings(ruleNs []RuleNamespace) error {
+	c.rulesMu.Lock()
+	defer c.rulesMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rules == nil {
+		return ErrNotCompiled
+	}
+	if c.sources == nil {
+		return ErrNotAppendable
+	}
+
+	prevVars := c.vars.Copy()
+	sources := append(c.sources[:len(c.sources):len(c.sources)], ruleNs...)
+	rules, err := c.compileStrings(c.target, sources)
+	if err != nil {
+		*c.vars = *prevVars
+		return err
+	}
+
+	if c.scanner != nil {
+		c.scanner.Destroy()
+		c.scanner = nil
+	}
+	c.rules.Destroy()
+	c.rules = rules
+	c.sources = sources
+	return nil
+}
+
+// Recompile compiles the YARA rules in the given directory or single file as CompileFileOrDir does, and swaps them with
+// the existing rules, e.g. to reload the rules changed on disk. The rules are compiled without blocking the scans, then
+// the swap waits for the in-flight scan to finish, so no scan uses the destroyed rules. If the scanner is created, it is
+// recreated for the new rules keeping the callback, the tag filter and the module data. The variables are parsed again
+// from the new rules keeping their settings. If the compilation fails, the existing rules are kept.
+//
+// The scanners created by NewScannerWithFlags are not tracked, so the caller must not use them after Recompile.
+func (c *Compiled) Recompile(target ScanTarget, filenameNS bool, path string) error {
+	c.mu.Lock()
+	fresh := &Compiled{
+		vars:     c.vars.Copy(),
+		nsFunc:   c.nsFunc,
+		nsPrefix: c.nsPrefix,
+		nsUnique: c.nsUnique,
+		lenient:  c.lenient,
+	}
+	c.mu.Unlock()
+	if err := fresh.CompileFileOrDir(target, filenameNS, path); err != nil {
+		return err
+	}
+
+	c.rulesMu.Lock()
+	defer c.rulesMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scanner != nil {
+		s, err := newScannerRetry(fresh.rules, CreateScannerRetry)
+		if err != nil {
+			fresh.rules.Destroy()
+			return err
+		}
+		c.scanner.Destroy()
+		c.scanner = s
+	}
+	if c.rules != nil {
+		c.rules.Destroy()
+	}
+	c.rules = fresh.rules
+	*c.vars = *fresh.vars
+	c.target = fresh.target
+	c.sources = fresh.sources
+	c.updateCallback()
+	return nil
+}
+
+func (c *Compiled) compileStrings(target ScanTarget, ruleNs []RuleNamespace) (*yara.Rules, error) {
 	compiler, err := yara.NewCompiler()
 	if err != nil {
-		return fmt.Errorf("yara compiler error: %w", err)
+		return nil, fmt.Errorf("yara compiler error: %w", err)
 	}
 	defer compiler.Destroy()
 
 	parser := new(variables.Parser)
+	parser.SetIncludeResolver(variables.FileIncludeResolver)
 	var fallbackAllVars bool
 	for _, rule := range ruleNs {
 		if err = parser.ParseFromReader(strings.NewReader(rule.Rule)); err != nil {
-			return fmt.Errorf("variable parser error: %w", err)
+			if !c.lenient {
+				return nil, fmt.Errorf("variable parser error: %w", err)
+			}
+			fallbackAllVars = true
 		}
 
-		if len(parser.Includes()) > 0 {
+		if len(parser.Unresolved()) > 0 {
 			fallbackAllVars = true
 		}
 	}
@@ -79,38 +259,54 @@ func (c *Compiled) CompileStrings(target ScanTarget, ruleNs []RuleNamespace) err
 	}
 
 	if err := c.initVariables(target, vars); err != nil {
-		return err
+		return nil, err
 	}
 
 	if err = c.vars.DefineCompilerVariables(compiler); err != nil {
 		err = fmt.Errorf("compiler define variable error: %w", err)
-		return compilerError(compiler, err)
+		return nil, compilerError(compiler, err)
 	}
 
 	for _, rule := range ruleNs {
 		err = compiler.AddString(rule.Rule, rule.Namespace)
 		if err != nil {
 			err = fmt.Errorf("compiler add rule error: %w", err)
-			return compilerError(compiler, err)
+			return nil, compilerError(compiler, err)
 		}
 	}
 
-	c.rules, err = compiler.GetRules()
+	rules, err := compiler.GetRules()
 	if err != nil {
 		err = fmt.Errorf("compiler get rules error: %w", err)
-		return compilerError(compiler, err)
+		return nil, compilerError(compiler, err)
 	}
-	return nil
+	return rules, nil
+}
+
+// CompileMap compiles the YARA rules keyed by their namespaces. Namespaces are compiled in sorted order to produce
+// reproducible results.
+func (c *Compiled) CompileMap(target ScanTarget, rulesByNS map[string]string) error {
+	namespaces := make([]string, 0, len(rulesByNS))
+	for ns := range rulesByNS {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	ruleNs := make([]RuleNamespace, 0, len(namespaces))
+	for _, ns := range namespaces {
+		ruleNs = append(ruleNs, RuleNamespace{Rule: rulesByNS[ns], Namespace: ns})
+	}
+	return c.CompileStrings(target, ruleNs)
 }
 
 // CompileRulesFileOrDir compiles the YARA rules in the given directory or single file, and
-// sets namespace of each file by cleaning file name(s).
+// sets namespace of each file by cleaning file name(s). See SetNamespaceFunc.
 func (c *Compiled) CompileFileOrDir(target ScanTarget, filenameNS bool, path string) error {
 	if c.rules != nil {
 		return ErrAlreadyCompiled
 	}
 
-	info, err := os.Stat(path)
+	info, err := os.Stat(extendedPath(path))
 	if err != nil {
 		return err
 	}
@@ -124,22 +320,68 @@ func (c *Compiled) CompileFileOrDir(target ScanTarget, filenameNS bool, path str
 }
 
 // CompileDir compiles the YARA rules in the given directory and
-// sets namespace of each file by cleaning file name(s).
+// sets namespace of each file by cleaning file name(s). See SetNamespaceFunc.
 func (c *Compiled) CompileDir(target ScanTarget, filenameNS bool, dir string) error {
 	if c.rules != nil {
 		return ErrAlreadyCompiled
 	}
 
-	f, err := os.Open(dir)
+	paths, err := ruleFilePaths(dir)
 	if err != nil {
 		return err
 	}
+	return c.CompileFiles(target, filenameNS, paths...)
+}
+
+// LoadRuleNamespaces reads the YARA rule files in the given directory without compiling them. The files and their
+// namespaces are selected as CompileDir does by default using the base names of the files, and they are returned in
+// the order of the file names. The result can be compiled by CompileStrings.
+func LoadRuleNamespaces(dir string, filenameNS bool) ([]RuleNamespace, error) {
+	paths, err := ruleFilePaths(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	regular := paths[:0]
+	for _, path := range paths {
+		info, err := os.Stat(extendedPath(path))
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().IsRegular() {
+			regular = append(regular, path)
+		}
+	}
+
+	namespaces, err := pathNamespaces(nil, "", regular, filenameNS, false)
+	if err != nil {
+		return nil, err
+	}
+
+	ruleNs := make([]RuleNamespace, 0, len(regular))
+	for i, path := range regular {
+		data, err := os.ReadFile(extendedPath(path))
+		if err != nil {
+			return nil, err
+		}
+		ruleNs = append(ruleNs, RuleNamespace{Rule: string(data), Namespace: namespaces[i]})
+	}
+	return ruleNs, nil
+}
+
+// ruleFilePaths returns the sorted paths of the files having .yar or .yara extension in the given directory.
+func ruleFilePaths(dir string) ([]string, error) {
+	f, err := os.Open(extendedPath(dir))
+	if err != nil {
+		return nil, err
+	}
 	defer f.Close() // nolint errcheck
 
 	names, err := f.Readdirnames(0)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	sort.Strings(names)
 
 	paths := make([]string, 0, len(names))
 	for _, name := range names {
@@ -151,14 +393,39 @@ func (c *Compiled) CompileDir(target ScanTarget, filenameNS bool, dir string) er
 		paths = append(paths, path)
 	}
 	if len(paths) == 0 {
-		return errors.New("no yara files")
+		return nil, errors.New("no yara files")
 	}
-	return c.CompileFiles(target, filenameNS, paths...)
+	return paths, nil
 }
 
 // CompileFiles compiles the YARA rules in the given file paths,
-// sets namespace of each file by cleaning file name(s).
+// sets namespace of each file by cleaning file name(s). See SetNamespaceFunc.
 func (c *Compiled) CompileFiles(target ScanTarget, filenameNS bool, paths ...string) error {
+	return c.CompileFilesContext(context.Background(), target, filenameNS, paths...)
+}
+
+// CompileFilesScanner compiles the YARA rules in the given file paths as CompileFiles does and then creates the scanner.
+// The scanner is not created if the compilation fails.
+func (c *Compiled) CompileFilesScanner(target ScanTarget, filenameNS bool, paths ...string) error {
+	if err := c.CompileFiles(target, filenameNS, paths...); err != nil {
+		return err
+	}
+	return c.CreateScanner()
+}
+
+// CompileFilesContext is like CompileFiles but stops compiling and returns ctx.Err() when the context is done. The
+// context is checked before each file is parsed and added to the compiler.
+func (c *Compiled) CompileFilesContext(ctx context.Context, target ScanTarget, filenameNS bool, paths ...string) error {
+	return c.compileFilesWith(ctx, target, paths, func(regular []string) ([]string, error) {
+		return pathNamespaces(c.nsFunc, c.nsPrefix, regular, filenameNS, c.nsUnique)
+	})
+}
+
+// compileFilesWith compiles the regular files in the given paths using the namespaces returned by the given function
+// for the paths of the regular files. The files are opened by their extended-length paths if they are too long for
+// the OS, but the namespaces are given the paths as they are.
+func (c *Compiled) compileFilesWith(ctx context.Context, target ScanTarget, paths []string,
+	fileNamespaces func(regular []string) ([]string, error)) error {
 	if c.rules != nil {
 		return ErrAlreadyCompiled
 	}
@@ -170,7 +437,9 @@ func (c *Compiled) CompileFiles(target ScanTarget, filenameNS bool, paths ...str
 	defer compiler.Destroy()
 
 	parser := new(variables.Parser)
+	parser.SetIncludeResolver(variables.FileIncludeResolver)
 	files := make([]*os.File, 0, len(paths))
+	regular := make([]string, 0, len(paths))
 
 	defer func() {
 		for _, file := range files {
@@ -180,8 +449,12 @@ func (c *Compiled) CompileFiles(target ScanTarget, filenameNS bool, paths ...str
 
 	var fallbackAllVars bool
 	for _, path := range paths {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
 		var f *os.File
-		f, err = os.Open(path)
+		f, err = os.Open(extendedPath(path))
 		if err != nil {
 			return err
 		}
@@ -197,12 +470,16 @@ func (c *Compiled) CompileFiles(target ScanTarget, filenameNS bool, paths ...str
 		}
 
 		files = append(files, f)
+		regular = append(regular, path)
 
-		if err = parser.ParseFromReader(f); err != nil {
-			return fmt.Errorf("variable parser error: %w", err)
+		if err = parser.ParseFromNamedReader(f, path); err != nil {
+			if !c.lenient {
+				return fmt.Errorf("variable parser error: %w", err)
+			}
+			fallbackAllVars = true
 		}
 		_, _ = f.Seek(0, io.SeekStart)
-		if len(parser.Includes()) > 0 {
+		if len(parser.Unresolved()) > 0 {
 			fallbackAllVars = true
 		}
 	}
@@ -221,8 +498,17 @@ func (c *Compiled) CompileFiles(target ScanTarget, filenameNS bool, paths ...str
 		return compilerError(compiler, err)
 	}
 
-	c.rules, err = compileFiles(compiler, files, filenameNS)
-	return err
+	namespaces, err := fileNamespaces(regular)
+	if err != nil {
+		return err
+	}
+
+	c.rules, err = compileFiles(ctx, compiler, files, namespaces)
+	if err != nil {
+		return err
+	}
+	c.target = target
+	return nil
 }
 
 func (c *Compiled) Variables() *variables.Variables {
@@ -233,41 +519,591 @@ func (c *Compiled) Rules() *yara.Rules {
 	return c.rules
 }
 
+// lockRules locks the rules against Destroy and the methods replacing them, and c.mu against the scans, for the readers
+// of the rules. The returned function unlocks them.
+func (c *Compiled) lockRules() (unlock func()) {
+	c.rulesMu.RLock()
+	c.mu.Lock()
+	return func() {
+		c.mu.Unlock()
+		c.rulesMu.RUnlock()
+	}
+}
+
+// Stats returns the statistics of the compiled rules and variables. It can be called at any time, the rule counts are
+// zero before compiling.
+func (c *Compiled) Stats() CompiledStats {
+	defer c.lockRules()()
+	stats := CompiledStats{
+		Variables:  len(c.vars.Variables()),
+		HasScanner: c.scanner != nil,
+	}
+	if c.rules == nil {
+		return stats
+	}
+	rules := c.rules.GetRules()
+	namespaces := make(map[string]struct{})
+	for i := range rules {
+		namespaces[rules[i].Namespace()] = struct{}{}
+	}
+	stats.Rules = len(rules)
+	stats.Namespaces = len(namespaces)
+	return stats
+}
+
+// GetRule returns the compiled rule having the given namespace and identifier. Note that the rules compiled without a
+// namespace are in the "default" namespace.
+//
+// The returned rule refers to the memory of the compiled rules, so it is valid only until the rules are freed by
+// Destroy, AppendStrings or Recompile. Use RuleMetas to keep the information of the rules.
+func (c *Compiled) GetRule(namespace, identifier string) (*yara.Rule, bool) {
+	defer c.lockRules()()
+	return c.getRule(namespace, identifier)
+}
+
+// getRule is GetRule without locking, the caller must hold c.mu.
+func (c *Compiled) getRule(namespace, identifier string) (*yara.Rule, bool) {
+	if c.rules == nil {
+		return nil, false
+	}
+	rules := c.rules.GetRules()
+	for i := range rules {
+		if rules[i].Identifier() == identifier && rules[i].Namespace() == namespace {
+			return &rules[i], true
+		}
+	}
+	return nil, false
+}
+
+// RuleInfo holds the information of a compiled rule.
+type RuleInfo struct {
+	Namespace  string
+	Identifier string
+	Tags       []string
+	Metas      []yara.Meta
+	// Private rules are not reported in the matches, they are used only by the other rules.
+	Private bool
+	// Global rules impose their conditions on all the rules in their namespace.
+	Global bool
+}
+
+// RuleMetas returns the information of the compiled rules in the order they are compiled. It returns nil if the rules
+// are not compiled.
+func (c *Compiled) RuleMetas() []RuleInfo {
+	defer c.lockRules()()
+	if c.rules == nil {
+		return nil
+	}
+	rules := c.rules.GetRules()
+	infos := make([]RuleInfo, 0, len(rules))
+	for i := range rules {
+		infos = append(infos, RuleInfo{
+			Namespace:  rules[i].Namespace(),
+			Identifier: rules[i].Identifier(),
+			Tags:       rules[i].Tags(),
+			Metas:      rules[i].Metas(),
+			Private:    rules[i].IsPrivate(),
+			Global:     rules[i].IsGlobal(),
+		})
+	}
+	return infos
+}
+
+// Namespaces returns the sorted distinct namespaces of the compiled rules. It returns nil if the rules are not compiled.
+func (c *Compiled) Namespaces() []string {
+	defer c.lockRules()()
+	return c.namespaces()
+}
+
+// namespaces is Namespaces without locking, the caller must hold c.mu.
+func (c *Compiled) namespaces() []string {
+	if c.rules == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var namespaces []string
+	for _, r := range c.rules.GetRules() {
+		if ns := r.Namespace(); !seen[ns] {
+			seen[ns] = true
+			namespaces = append(namespaces, ns)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// RuleCountByNamespace returns the number of the compiled rules in each namespace. It returns nil if the rules are not
+// compiled.
+func (c *Compiled) RuleCountByNamespace() map[string]int {
+	defer c.lockRules()()
+	if c.rules == nil {
+		return nil
+	}
+	counts := make(map[string]int)
+	for _, r := range c.rules.GetRules() {
+		counts[r.Namespace()]++
+	}
+	return counts
+}
+
+// CreateScanner creates the scanner used by the scan methods for the compiled rules. It returns ErrNotCompiled if the
+// rules are not compiled yet or they are destroyed by Destroy.
 func (c *Compiled) CreateScanner() error {
-	s, err := yara.NewScanner(c.rules)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rules == nil {
+		return ErrNotCompiled
+	}
+	s, err := newScannerRetry(c.rules, CreateScannerRetry)
 	if err != nil {
 		return err
 	}
-	c.scanner = s
+	// Keep the callback set before the scanner is created.
+	c.scanner = s.SetCallback(c.wrapCallback(c.callback))
 	return nil
 }
 
+// newScannerRetry creates a scanner retrying the transient errors according to the given policy.
+func newScannerRetry(rules *yara.Rules, policy variables.RetryPolicy) (*yara.Scanner, error) {
+	for i := 1; ; i++ {
+		s, err := newScanner(rules)
+		if err == nil || i >= policy.Attempts || !(errors.Is(err, errInsufficientMemory) ||
+			errors.Is(err, errTooManyScanThreads)) {
+			return s, err
+		}
+		time.Sleep(policy.Backoff)
+	}
+}
+
+// NewScannerWithFlags creates a new scanner for the compiled rules using the given scan flags and timeout. Unlike
+// CreateScanner, the scanner is not kept by the instance, so the caller is responsible for defining its variables, e.g.
+// using Variables().DefineScannerVariables, setting its callback and destroying it. The transient errors are retried
+// according to CreateScannerRetry.
+func (c *Compiled) NewScannerWithFlags(flags yara.ScanFlags, timeout time.Duration) (*yara.Scanner, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.newScannerWithFlags(flags, timeout)
+}
+
+// newScannerWithFlags is NewScannerWithFlags without locking, the caller must hold c.mu.
+func (c *Compiled) newScannerWithFlags(flags yara.ScanFlags, timeout time.Duration) (*yara.Scanner, error) {
+	if c.rules == nil {
+		return nil, ErrNotCompiled
+	}
+	s, err := newScannerRetry(c.rules, CreateScannerRetry)
+	if err != nil {
+		return nil, err
+	}
+	return s.SetFlags(flags).SetTimeout(timeout), nil
+}
+
+// sweep holds the scanners of a sweep, e.g. ScanTreeParallel, along with the variables and the match filters copied
+// from the instance, so the sweep does not hold c.mu while scanning. See beginSweep.
+type sweep struct {
+	scanners []*yara.Scanner
+	vars     *variables.Variables
+	tags     map[string]struct{}
+	modules  map[string][]byte
+	procEnum ProcessEnumerator
+	newInfo  variables.ProcessInfoFactory
+}
+
+// beginSweep creates the given number of scanners using the given timeout and copies the state used by the sweep. The
+// caller must hold c.rulesMu for reading until the sweep ends, and call end to destroy the scanners.
+func (c *Compiled) beginSweep(workers int, timeout time.Duration) (*sweep, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sw := &sweep{
+		vars:     c.vars.Copy(),
+		tags:     c.tags,
+		modules:  c.modules,
+		procEnum: c.processEnumerator(),
+		newInfo:  c.processInfoFactory(),
+	}
+	for i := 0; i < workers; i++ {
+		s, err := c.newScannerWithFlags(0, timeout)
+		if err != nil {
+			sw.end()
+			return nil, err
+		}
+		sw.scanners = append(sw.scanners, s)
+	}
+	return sw, nil
+}
+
+// wrapCallback wraps the given callback to apply the match filters copied from the instance.
+func (sw *sweep) wrapCallback(cb yara.ScanCallback) yara.ScanCallback {
+	return wrapFilters(cb, sw.tags, sw.modules)
+}
+
+func (sw *sweep) end() {
+	for _, s := range sw.scanners {
+		s.Destroy()
+	}
+}
+
+// Preflight validates that the compiled rules can be scanned, e.g. to detect a mismatch of the linked YARA library at
+// startup rather than at the first scan. It creates a temporary scanner, defines the variables using an empty scan
+// context and scans a small buffer. The scanner created by CreateScanner is not used.
+func (c *Compiled) Preflight() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, err := c.newScannerWithFlags(0, 0)
+	if err != nil {
+		return err
+	}
+	defer s.Destroy()
+
+	if err = c.vars.DefineScannerVariables(new(variables.ScanContextImpl), s); err != nil {
+		return err
+	}
+	var matches yara.MatchRules
+	return s.SetCallback(&matches).ScanMem([]byte("gora"))
+}
+
 func (c *Compiled) Scanner() *yara.Scanner {
 	return c.scanner
 }
 
 func (c *Compiled) DefineScannerVariables(sctx variables.ScanContext) error {
-	return c.vars.DefineScannerVariables(sctx, c.scanner)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.defineScannerVariables(sctx)
 }
 
+// DefineScannerVariablesWith defines the scanner variables as DefineScannerVariables does, but the variables present in
+// the given overrides by their names are defined using the given values instead of the calculated ones, e.g. to pin
+// file_path to a synthetic value while reproducing a match. The overridden variables are still calculated, so their
+// value errors are handled as usual. The overrides must be the variables used by the rules and their values must be
+// of the variable types, except that []byte values are hex encoded as DefineScannerVariables does.
+func (c *Compiled) DefineScannerVariablesWith(sctx variables.ScanContext, overrides map[string]interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vars := c.vars.Variables()
+	for name := range overrides {
+		vid, err := variables.ParseVariableType(name)
+		if err != nil {
+			return err
+		}
+		if !containsVariable(vars, vid) {
+			return fmt.Errorf("variable '%s' is not used by the rules", name)
+		}
+	}
+	return c.recordScannerVariables(sctx, func(sctx variables.ScanContext, definer variables.VariableDefiner) error {
+		return c.vars.DefineScannerVariables(sctx, &overrideDefiner{definer: definer, overrides: overrides})
+	})
+}
+
+func containsVariable(vars []variables.VariableType, vid variables.VariableType) bool {
+	for _, v := range vars {
+		if v == vid {
+			return true
+		}
+	}
+	return false
+}
+
+// defineScannerVariables is DefineScannerVariables without locking, the caller must hold c.mu.
+func (c *Compiled) defineScannerVariables(sctx variables.ScanContext) error {
+	return c.recordScannerVariables(sctx, c.vars.DefineScannerVariables)
+}
+
+// recordScannerVariables defines the scanner variables using the given function and records their values.
+func (c *Compiled) recordScannerVariables(sctx variables.ScanContext,
+	define func(variables.ScanContext, variables.VariableDefiner) error) error {
+	if c.scanner == nil {
+		return ErrScannerNotCreated
+	}
+	rec := &valueRecorder{
+		definer: c.scanner,
+		values:  make(map[string]interface{}, len(c.vars.Variables())),
+	}
+	c.values = rec.values
+	return define(sctx, rec)
+}
+
+// VariableValues returns the values of the external variables defined by the last DefineScannerVariables call. Like
+// the other locking methods, it must not be called from the scan callback, ScanFileMatches returns the values instead.
+func (c *Compiled) VariableValues() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.variableValues()
+}
+
+// variableValues is VariableValues without locking, the caller must hold c.mu.
+func (c *Compiled) variableValues() map[string]interface{} {
+	values := make(map[string]interface{}, len(c.values))
+	for k, v := range c.values {
+		values[k] = v
+	}
+	return values
+}
+
+// SetLenientParsing sets whether the variable parser errors are ignored while compiling. By default, the compilation
+// fails if the variable parser can not parse a rule. If it is enabled, all the variables are defined as in the case of
+// the includes which can not be resolved, and YARA compiler reports the error if the rule is really invalid.
+func (c *Compiled) SetLenientParsing(enabled bool) *Compiled {
+	c.lenient = enabled
+	return c
+}
+
+// SetMaxScanBytes limits the number of bytes scanned by ScanMem, ScanFile, ScanFileMatches, ScanOpenFile and ScanTree,
+// and read by each content derived variable, e.g. file_hash, for each scan. The scans of the larger content fail with
+// ErrMaxScanBytes without scanning, see Variables.SetMaxScanBytes for the variables. The file descriptor and process
+// scans are not limited. Zero means no limit, which is the default.
+func (c *Compiled) SetMaxScanBytes(n int64) *Compiled {
+	c.maxBytes = n
+	c.vars.SetMaxScanBytes(n)
+	return c
+}
+
+// SetScanId sets the scan session id defined as scan_id variable for the scans of the instance. Otherwise, a unique id
+// is generated while compiling and kept by Recompile. See variables.Variables.SetScanId.
+func (c *Compiled) SetScanId(id string) *Compiled {
+	c.vars.SetScanId(id)
+	return c
+}
+
+// ScanId returns the scan session id defined as scan_id variable, which is empty until it is set or the rules are
+// compiled.
+func (c *Compiled) ScanId() string {
+	return c.vars.ScanId()
+}
+
+// checkScanBytes returns ErrMaxScanBytes if the given size exceeds the limit set by SetMaxScanBytes.
+func (c *Compiled) checkScanBytes(size int64) error {
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return fmt.Errorf("%w: %d bytes exceeds %d", ErrMaxScanBytes, size, c.maxBytes)
+	}
+	return nil
+}
+
+// checkScanFile returns ErrNotRegularFile if the file at the given path is not a regular file, e.g. a directory or a
+// FIFO which may block the scan forever, and checks its size using checkScanBytes. It returns the file info if the file
+// can be scanned.
+func (c *Compiled) checkScanFile(filename string) (fs.FileInfo, error) {
+	info, err := os.Stat(extendedPath(filename))
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("'%s': %w", filename, ErrNotRegularFile)
+	}
+	if err = c.checkScanBytes(info.Size()); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// SetCallback sets the callback called for the scan results. If the scanner is not created yet, the callback is set
+// when it is created.
 func (c *Compiled) SetCallback(cb yara.ScanCallback) *Compiled {
-	c.scanner.SetCallback(cb)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.callback = cb
+	c.updateCallback()
 	return c
 }
 
+// SetMaxMatchData sets the maximum number of bytes of the matched data YARA keeps for each string match, longer
+// matches are truncated. YARA holds this setting globally, so it affects all the compiled instances and scanners of
+// the process.
+func SetMaxMatchData(n int) error {
+	return yara.SetConfiguration(yara.ConfigMaxMatchData, n)
+}
+
+// SetStackSize sets the size of the stack used by YARA's virtual machine while evaluating rule conditions. YARA holds
+// this setting globally, so it affects all the compiled instances and scanners of the process.
+func SetStackSize(n int) error {
+	return yara.SetConfiguration(yara.ConfigStackSize, n)
+}
+
+// SetMaxStringsPerRule sets the maximum number of strings allowed in a single rule. It must be called before compiling
+// the rules. YARA holds this setting globally, so it affects all the compilations of the process.
+func SetMaxStringsPerRule(n int) error {
+	return yara.SetConfiguration(yara.ConfigMaxStringsPerRule, n)
+}
+
 func (c *Compiled) ScanFileDescriptor(fd uintptr) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scanner == nil {
+		return ErrScannerNotCreated
+	}
 	return c.scanner.ScanFileDescriptor(fd)
 }
 
+// ScanFileDescriptorWithContext defines the scanner variables using the given scan context and then scans the file
+// descriptor. It should be used when the file is already opened, since the descriptor does not carry the file path and
+// info required by the file variables.
+func (c *Compiled) ScanFileDescriptorWithContext(fd uintptr, sctx variables.ScanContext) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.defineScannerVariables(sctx); err != nil {
+		return err
+	}
+	return c.scanner.ScanFileDescriptor(fd)
+}
+
+// ScanOpenFile defines the scanner variables using the given scan context and then scans the already opened file using
+// its descriptor. It avoids opening the file again by its path, e.g. when the file and its info are obtained during
+// enumeration. The file is not closed.
+func (c *Compiled) ScanOpenFile(f *os.File, sctx variables.ScanContext) error {
+	if c.maxBytes > 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if err = c.checkScanBytes(info.Size()); err != nil {
+			return err
+		}
+	}
+	err := c.ScanFileDescriptorWithContext(f.Fd(), sctx)
+	// Keep the file from being closed by its finalizer while the descriptor is scanned.
+	runtime.KeepAlive(f)
+	return err
+}
+
+// ScanFile scans the file at the given path. It returns ErrNotRegularFile if the path is not a regular file.
 func (c *Compiled) ScanFile(filename string) error {
-	return c.scanner.ScanFile(filename)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scanner == nil {
+		return ErrScannerNotCreated
+	}
+	if _, err := c.checkScanFile(filename); err != nil {
+		return err
+	}
+	return c.scanner.ScanFile(extendedPath(filename))
+}
+
+// ScanFileMatches defines the scanner variables using the given scan context, scans the file and returns the matched
+// rules along with the variable values defined for the scan. The callback set by SetCallback is not called.
+func (c *Compiled) ScanFileMatches(filename string, sctx variables.ScanContext) (*ScanResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.checkScanFile(filename); err != nil {
+		return nil, err
+	}
+	return c.scanFileWith(filename, sctx, c.vars.DefineScannerVariables)
+}
+
+// scanFileWith defines the scanner variables using the given function and scans the file collecting the matches.
+func (c *Compiled) scanFileWith(filename string, sctx variables.ScanContext,
+	define func(variables.ScanContext, variables.VariableDefiner) error) (*ScanResult, error) {
+	if err := c.recordScannerVariables(sctx, define); err != nil {
+		return nil, err
+	}
+
+	var matches yara.MatchRules
+	prev := c.scanner.Callback
+	defer c.scanner.SetCallback(prev)
+
+	if err := c.scanner.SetCallback(c.wrapCallback(&matches)).ScanFile(extendedPath(filename)); err != nil {
+		return nil, err
+	}
+	return &ScanResult{
+		Matches:   matches,
+		Variables: c.variableValues(),
+	}, nil
+}
+
+// ScanFileStaged scans the file as ScanFileMatches does, but in two phases to avoid calculating the expensive variables
+// for the files which do not match any rule. The first scan defines variables.HeavyVars using their default values, and
+// the file is scanned again with all the variables only if any rule matches. The result is of the last scan, so its
+// Variables hold the default values of the heavy variables if nothing matches.
+//
+// Since the rules are first evaluated without the heavy variables, a rule which can only match with the calculated
+// value of a heavy variable, e.g. `file_hash == "..."`, never matches unless another rule matches in the first scan.
+// It is suitable for the rule sets where the heavy variables only narrow down the rules which match otherwise.
+func (c *Compiled) ScanFileStaged(filename string, sctx variables.ScanContext) (*ScanResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.checkScanFile(filename); err != nil {
+		return nil, err
+	}
+	if !c.vars.HasHeavyVariables() {
+		return c.scanFileWith(filename, sctx, c.vars.DefineScannerVariables)
+	}
+	result, err := c.scanFileWith(filename, sctx, c.vars.DefineLightScannerVariables)
+	if err != nil || len(result.Matches) == 0 {
+		return result, err
+	}
+	return c.scanFileWith(filename, sctx, c.vars.DefineScannerVariables)
+}
+
+// ScanFileNamespaces scans the file as ScanFile does and returns the matched rules in the given namespaces, applying
+// the tag filter as well. All the matches are returned if no namespace is given. The callback set by SetCallback is not
+// called. It returns ErrNamespaceNotFound if a namespace is not compiled, e.g. because of a typo.
+//
+// The matches are filtered after the scan, so all the rules are still evaluated by YARA and the scan performance is
+// the same as scanning with all the namespaces. Rules are not disabled since the scanner shares them with the other
+// scans. Compile the namespaces separately to restrict the scan for performance.
+func (c *Compiled) ScanFileNamespaces(filename string, namespaces ...string) (yara.MatchRules, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scanner == nil {
+		return nil, ErrScannerNotCreated
+	}
+	wanted := make(map[string]struct{}, len(namespaces))
+	compiled := c.namespaces()
+	for _, ns := range namespaces {
+		if i := sort.SearchStrings(compiled, ns); i == len(compiled) || compiled[i] != ns {
+			return nil, fmt.Errorf("%w: '%s'", ErrNamespaceNotFound, ns)
+		}
+		wanted[ns] = struct{}{}
+	}
+	if _, err := c.checkScanFile(filename); err != nil {
+		return nil, err
+	}
+
+	var matches yara.MatchRules
+	prev := c.scanner.Callback
+	defer c.scanner.SetCallback(prev)
+	if err := c.scanner.SetCallback(c.wrapCallback(&matches)).ScanFile(extendedPath(filename)); err != nil {
+		return nil, err
+	}
+	if len(wanted) == 0 {
+		return matches, nil
+	}
+	filtered := matches[:0]
+	for _, m := range matches {
+		if _, ok := wanted[m.Namespace]; ok {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered, nil
+}
+
+// ScanMem scans the given buffer. Use variables.BufferScanContext to define the file variables for the buffer.
+func (c *Compiled) ScanMem(buf []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scanner == nil {
+		return ErrScannerNotCreated
+	}
+	if err := c.checkScanBytes(int64(len(buf))); err != nil {
+		return err
+	}
+	return c.scanner.ScanMem(buf)
 }
 
 func (c *Compiled) ScanProc(pid int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scanner == nil {
+		return ErrScannerNotCreated
+	}
 	return c.scanner.ScanProc(pid)
 }
 
+// Destroy destroys the scanner and the rules. It is safe to call it more than once and concurrently with the scans, it
+// waits for the running scans and sweeps to finish and the scans called afterwards return ErrScannerNotCreated.
 func (c *Compiled) Destroy() {
+	c.rulesMu.Lock()
+	defer c.rulesMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.scanner != nil {
 		c.scanner.Destroy()
 		c.scanner = nil
@@ -278,16 +1114,45 @@ func (c *Compiled) Destroy() {
 	}
 }
 
-func compileFiles(compiler *yara.Compiler, files []*os.File, filenameNS bool) (*yara.Rules, error) {
-	for _, file := range files {
+// valueRecorder records the variable values defined to the underlying definer.
+type valueRecorder struct {
+	definer variables.VariableDefiner
+	values  map[string]interface{}
+}
+
+func (r *valueRecorder) DefineVariable(name string, value interface{}) error {
+	if err := r.definer.DefineVariable(name, value); err != nil {
+		return err
+	}
+	r.values[name] = value
+	return nil
+}
+
+// overrideDefiner replaces the values of the overridden variables before defining them.
+type overrideDefiner struct {
+	definer   variables.VariableDefiner
+	overrides map[string]interface{}
+}
+
+func (o *overrideDefiner) DefineVariable(name string, value interface{}) error {
+	if v, ok := o.overrides[name]; ok {
+		value = v
+		if b, ok := v.([]byte); ok {
+			value = hex.EncodeToString(b)
+		}
+	}
+	return o.definer.DefineVariable(name, value)
+}
+
+func compileFiles(ctx context.Context, compiler *yara.Compiler, files []*os.File, namespaces []string) (*yara.Rules, error) {
+	for i, file := range files {
 		file := file
 
-		var namespace string
-		if filenameNS {
-			namespace = filepath.Base(file.Name())
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
 
-		err := compiler.AddFile(file, namespace)
+		err := compiler.AddFile(file, namespaces[i])
 		if err != nil {
 			err = fmt.Errorf("compiler add rule error: %w", err)
 			return nil, compilerError(compiler, err)
@@ -323,6 +1188,8 @@ func (c *Compiled) initVariables(target ScanTarget, vars []variables.VariableTyp
 		c.vars.InitProcessVariables(vars)
 	case ScanFile:
 		c.vars.InitFileVariables(vars)
+	case ScanFileProcess:
+		c.vars.InitFileProcessVariables(vars)
 	default:
 		return errors.New("invalid scan target:" + strconv.Itoa(int(target)))
 	}
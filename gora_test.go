@@ -1,16 +1,29 @@
 package gora_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"testing/iotest"
+	"time"
 
+	"github.com/hillu/go-yara/v4"
 	"github.com/stretchr/testify/require"
 
 	"github.com/binalyze/gora"
+	"github.com/binalyze/gora/variables"
 )
 
 func TestCompileString(t *testing.T) {
@@ -25,6 +38,684 @@ func TestCompileString(t *testing.T) {
 	require.NotNil(t, comp.Rules())
 }
 
+// rulestrParserUnsupported is a valid rule that the variable parser fails to parse since it does not unescape the
+// custom base64 alphabet.
+const rulestrParserUnsupported = `
+rule test_base64_alphabet {
+  strings:
+    $a = "This program cannot" base64("!@#$%^&*(){}[].,|ABCDEFGHIJ\x09LMNOPQRSTUVWXYZabcdefghijklmnopqrstu")
+  condition:
+    $a or file_name == "fixture.txt"
+}`
+
+func TestSetLenientParsing(t *testing.T) {
+	comp := gora.NewCompiled()
+	err := comp.CompileString(gora.ScanFile, rulestrParserUnsupported, "")
+	require.ErrorContains(t, err, "variable parser error")
+	require.Nil(t, comp.Rules())
+
+	comp = gora.NewCompiled().SetLenientParsing(true)
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileString(gora.ScanFile, rulestrParserUnsupported, ""))
+	require.Contains(t, comp.Variables().Variables(), variables.VarFileName)
+
+	path := genFile(t, t.TempDir(), rulestrParserUnsupported)
+	require.Error(t, gora.NewCompiled().CompileFiles(gora.ScanFile, true, path))
+	fcomp := gora.NewCompiled().SetLenientParsing(true)
+	t.Cleanup(fcomp.Destroy)
+	require.NoError(t, fcomp.CompileFiles(gora.ScanFile, true, path))
+	require.Contains(t, fcomp.Variables().Variables(), variables.VarFileName)
+
+	// YARA compiler still reports the invalid rules.
+	err = gora.NewCompiled().SetLenientParsing(true).CompileString(gora.ScanFile, `rule x{`, "")
+	require.ErrorContains(t, err, "compiler add rule error")
+}
+
+func TestCompileMap(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+
+	err := comp.CompileMap(gora.ScanFile, map[string]string{
+		"ns1": `rule r1 { strings: $a = "first" condition: $a }`,
+		"ns2": `rule r2 { strings: $a = "second" condition: $a and file_name == "fixture.txt" }`,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []variables.VariableType{variables.VarFileName}, comp.Variables().Variables())
+
+	rules := comp.Rules().GetRules()
+	require.Len(t, rules, 2)
+	require.Equal(t, "ns1", rules[0].Namespace())
+	require.Equal(t, "r1", rules[0].Identifier())
+	require.Equal(t, "ns2", rules[1].Namespace())
+	require.Equal(t, "r2", rules[1].Identifier())
+
+	require.NoError(t, comp.CreateScanner())
+	path := filepath.Join(t.TempDir(), "fixture.txt")
+	require.NoError(t, os.WriteFile(path, []byte("first second"), 0o666))
+
+	var sctx variables.ScanContextImpl
+	sctx.SetFilePath(path)
+	res, err := comp.ScanFileMatches(path, &sctx)
+	require.NoError(t, err)
+	require.Len(t, res.Matches, 2)
+
+	err = comp.CompileMap(gora.ScanFile, map[string]string{"ns": rulestrFs})
+	require.ErrorIs(t, err, gora.ErrAlreadyCompiled)
+}
+
+func TestAppendStrings(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+
+	ruleNs := []gora.RuleNamespace{{Rule: `rule r2 { strings: $a = "second" condition: $a and file_name == "fixture.txt" }`}}
+	require.ErrorIs(t, comp.AppendStrings(ruleNs), gora.ErrNotCompiled)
+
+	require.NoError(t, comp.CompileString(gora.ScanFile, `rule r1 { strings: $a = "first" condition: $a }`, ""))
+	require.Empty(t, comp.Variables().Variables())
+	require.NoError(t, comp.CreateScanner())
+
+	require.NoError(t, comp.AppendStrings(ruleNs))
+	require.Nil(t, comp.Scanner())
+	require.Equal(t, []variables.VariableType{variables.VarFileName}, comp.Variables().Variables())
+	require.Len(t, comp.Rules().GetRules(), 2)
+
+	require.NoError(t, comp.CreateScanner())
+	path := filepath.Join(t.TempDir(), "fixture.txt")
+	require.NoError(t, os.WriteFile(path, []byte("second"), 0o666))
+
+	var sctx variables.ScanContextImpl
+	sctx.SetFilePath(path)
+	res, err := comp.ScanFileMatches(path, &sctx)
+	require.NoError(t, err)
+	require.Len(t, res.Matches, 1)
+	require.Equal(t, "r2", res.Matches[0].Rule)
+
+	// Failing compilation keeps the existing rules and variables.
+	rules := comp.Rules()
+	require.Error(t, comp.AppendStrings([]gora.RuleNamespace{{Rule: `rule r3 { condition: process_id == 1 and `}}))
+	require.Same(t, rules, comp.Rules())
+	require.Equal(t, []variables.VariableType{variables.VarFileName}, comp.Variables().Variables())
+}
+
+func TestAppendStrings_files(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+
+	path := genFile(t, t.TempDir(), rulestrFs)
+	require.NoError(t, comp.CompileFiles(gora.ScanFile, true, path))
+	err := comp.AppendStrings([]gora.RuleNamespace{{Rule: `rule r2 { condition: true }`}})
+	require.ErrorIs(t, err, gora.ErrNotAppendable)
+}
+
+func TestGetRule(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+
+	_, ok := comp.GetRule("default", "r1")
+	require.False(t, ok)
+
+	err := comp.CompileStrings(gora.ScanFile, []gora.RuleNamespace{
+		{Rule: `rule r1 : t1 { condition: true }`},
+		{Rule: `rule r2 { condition: true }`, Namespace: "ns"},
+	})
+	require.NoError(t, err)
+
+	rule, ok := comp.GetRule("default", "r1")
+	require.True(t, ok)
+	require.Equal(t, "r1", rule.Identifier())
+	require.Equal(t, []string{"t1"}, rule.Tags())
+
+	rule, ok = comp.GetRule("ns", "r2")
+	require.True(t, ok)
+	require.Equal(t, "r2", rule.Identifier())
+
+	_, ok = comp.GetRule("ns", "r1")
+	require.False(t, ok)
+	_, ok = comp.GetRule("default", "unknown")
+	require.False(t, ok)
+}
+
+func TestRuleMetas(t *testing.T) {
+	comp := gora.NewCompiled()
+	defer comp.Destroy()
+	require.Nil(t, comp.RuleMetas())
+
+	path := genFile(t, t.TempDir(), `
+private rule helper : internal { meta: author = "gora" condition: filesize > 0 }
+global rule size { condition: filesize < 1MB }
+rule public { condition: helper }`)
+	require.NoError(t, comp.CompileFiles(gora.ScanFile, false, path))
+
+	require.Equal(t, []gora.RuleInfo{
+		{
+			Namespace:  "default",
+			Identifier: "helper",
+			Tags:       []string{"internal"},
+			Metas:      []yara.Meta{{Identifier: "author", Value: "gora"}},
+			Private:    true,
+		},
+		{Namespace: "default", Identifier: "size", Global: true},
+		{Namespace: "default", Identifier: "public"},
+	}, comp.RuleMetas())
+}
+
+func TestNamespaces(t *testing.T) {
+	comp := gora.NewCompiled()
+	defer comp.Destroy()
+	require.Nil(t, comp.Namespaces())
+
+	require.NoError(t, comp.CompileStrings(gora.ScanFile, []gora.RuleNamespace{
+		{Rule: `rule r1 { condition: true } rule r2 { condition: true }`, Namespace: "webshells"},
+		{Rule: `rule r3 { condition: true }`, Namespace: "apt"},
+		{Rule: `rule r4 { condition: true }`},
+		{Rule: `rule r5 { condition: true }`, Namespace: "webshells"},
+	}))
+	require.Equal(t, []string{"apt", "default", "webshells"}, comp.Namespaces())
+}
+
+func TestRuleCountByNamespace(t *testing.T) {
+	comp := gora.NewCompiled()
+	defer comp.Destroy()
+	require.Nil(t, comp.RuleCountByNamespace())
+
+	require.NoError(t, comp.CompileStrings(gora.ScanFile, []gora.RuleNamespace{
+		{Rule: `rule r1 { condition: true } private rule r2 { condition: true }`, Namespace: "webshells"},
+		{Rule: `rule r3 { condition: true }`, Namespace: "apt"},
+		{Rule: `rule r4 { condition: true }`},
+		{Rule: `rule r5 { condition: true }`, Namespace: "webshells"},
+	}))
+	require.Equal(t, map[string]int{"apt": 1, "default": 1, "webshells": 3}, comp.RuleCountByNamespace())
+}
+
+func TestCreateScanner_notCompiled(t *testing.T) {
+	comp := gora.NewCompiled()
+	require.ErrorIs(t, comp.CreateScanner(), gora.ErrNotCompiled)
+	require.ErrorIs(t, comp.ScanFile("fixture.txt"), gora.ErrScannerNotCreated)
+
+	// The destroyed rules can not be used to create a scanner either.
+	require.NoError(t, comp.CompileString(gora.ScanFile, `rule r { condition: true }`, ""))
+	comp.Destroy()
+	require.ErrorIs(t, comp.CreateScanner(), gora.ErrNotCompiled)
+}
+
+func TestCreateScanner_retry(t *testing.T) {
+	comp := gora.NewCompiled()
+	defer comp.Destroy()
+	require.ErrorIs(t, comp.CreateScanner(), gora.ErrNotCompiled)
+	require.NoError(t, comp.CompileString(gora.ScanFile, `rule r { condition: true }`, ""))
+
+	var calls int
+	failOnce := func(failure error) func(*yara.Rules) (*yara.Scanner, error) {
+		calls = 0
+		return func(rules *yara.Rules) (*yara.Scanner, error) {
+			calls++
+			if calls == 1 {
+				return nil, failure
+			}
+			return yara.NewScanner(rules)
+		}
+	}
+
+	// Not retried by default.
+	restore := gora.SetNewScanner(failOnce(yara.Error(1)))
+	require.ErrorIs(t, comp.CreateScanner(), yara.Error(1))
+	require.Equal(t, 1, calls)
+	restore()
+
+	prev := gora.CreateScannerRetry
+	t.Cleanup(func() {
+		gora.CreateScannerRetry = prev
+	})
+	gora.CreateScannerRetry = variables.RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+
+	for _, transient := range []yara.Error{1, 27} {
+		restore = gora.SetNewScanner(failOnce(transient))
+		require.NoError(t, comp.CreateScanner())
+		require.Equal(t, 2, calls)
+		restore()
+	}
+
+	// NewScannerWithFlags retries the same way.
+	restore = gora.SetNewScanner(failOnce(yara.Error(1)))
+	s, err := comp.NewScannerWithFlags(0, 0)
+	require.NoError(t, err)
+	s.Destroy()
+	require.Equal(t, 2, calls)
+	restore()
+
+	errTest := errors.New("test error")
+	restore = gora.SetNewScanner(failOnce(errTest))
+	require.ErrorIs(t, comp.CreateScanner(), errTest)
+	require.Equal(t, 1, calls)
+	restore()
+}
+
+func TestNewScannerWithFlags(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+
+	_, err := comp.NewScannerWithFlags(0, 0)
+	require.ErrorIs(t, err, gora.ErrNotCompiled)
+
+	require.NoError(t, comp.CompileString(gora.ScanFile, `rule r { condition: file_name == "a" }`, ""))
+
+	s1, err := comp.NewScannerWithFlags(yara.ScanFlagsFastMode, time.Minute)
+	require.NoError(t, err)
+	defer s1.Destroy()
+	s2, err := comp.NewScannerWithFlags(0, 0)
+	require.NoError(t, err)
+	defer s2.Destroy()
+	require.Nil(t, comp.Scanner())
+
+	require.NoError(t, s1.DefineVariable("file_name", "a"))
+	require.NoError(t, s2.DefineVariable("file_name", "b"))
+
+	var m1, m2 yara.MatchRules
+	require.NoError(t, s1.SetCallback(&m1).ScanMem([]byte("test")))
+	require.NoError(t, s2.SetCallback(&m2).ScanMem([]byte("test")))
+	require.Equal(t, []string{"r"}, matchedRuleNames(m1))
+	require.Empty(t, m2)
+}
+
+func TestSetMaxScanBytes(t *testing.T) {
+	comp := gora.NewCompiled().SetMaxScanBytes(8)
+	defer comp.Destroy()
+	require.NoError(t, comp.CompileString(gora.ScanFile, `rule r { condition: file_hash != "" }`, ""))
+	require.NoError(t, comp.CreateScanner())
+
+	dir := t.TempDir()
+	small := filepath.Join(dir, "small.txt")
+	require.NoError(t, os.WriteFile(small, []byte("test"), 0o666))
+	large := filepath.Join(dir, "large.txt")
+	require.NoError(t, os.WriteFile(large, []byte("test test test"), 0o666))
+
+	require.NoError(t, comp.ScanMem([]byte("test")))
+	require.ErrorIs(t, comp.ScanMem([]byte("test test test")), gora.ErrMaxScanBytes)
+	require.NoError(t, comp.ScanFile(small))
+	require.ErrorIs(t, comp.ScanFile(large), gora.ErrMaxScanBytes)
+
+	var sctx variables.ScanContextImpl
+	sctx.SetFilePath(small)
+	result, err := comp.ScanFileMatches(small, &sctx)
+	require.NoError(t, err)
+	require.Len(t, result.Matches, 1)
+	_, err = comp.ScanFileMatches(large, &sctx)
+	require.ErrorIs(t, err, gora.ErrMaxScanBytes)
+
+	// The file variables are limited as well.
+	sctx.SetFilePath(large)
+	require.ErrorIs(t, comp.DefineScannerVariables(&sctx), gora.ErrMaxScanBytes)
+
+	f, err := os.Open(large)
+	require.NoError(t, err)
+	defer f.Close()
+	require.ErrorIs(t, comp.ScanOpenFile(f, &sctx), gora.ErrMaxScanBytes)
+
+	tree, err := comp.ScanTree(context.Background(), dir, gora.ScanTreeOptions{})
+	require.NoError(t, err)
+	require.Len(t, tree.Matches, 1)
+	require.ErrorIs(t, tree.Errors[large], gora.ErrMaxScanBytes)
+
+	comp.SetMaxScanBytes(0)
+	require.NoError(t, comp.ScanFile(large))
+}
+
+func TestPreflight(t *testing.T) {
+	comp := gora.NewCompiled()
+	require.ErrorIs(t, comp.Preflight(), gora.ErrNotCompiled)
+
+	require.NoError(t, comp.CompileString(gora.ScanFile, `rule r { condition: file_name == "" and filesize > 0 }`, ""))
+	require.NoError(t, comp.Preflight())
+	require.Nil(t, comp.Scanner())
+
+	comp.Destroy()
+	require.ErrorIs(t, comp.Preflight(), gora.ErrNotCompiled)
+}
+
+func TestStats(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.Equal(t, gora.CompiledStats{}, comp.Stats())
+
+	err := comp.CompileMap(gora.ScanFile, map[string]string{
+		"ns1": `rule r1 { condition: file_name == "a" } rule r2 { condition: file_extension == "b" }`,
+		"ns2": `rule r3 { condition: file_name == "c" }`,
+	})
+	require.NoError(t, err)
+	require.Equal(t, gora.CompiledStats{Rules: 3, Namespaces: 2, Variables: 2}, comp.Stats())
+
+	require.NoError(t, comp.CreateScanner())
+	require.True(t, comp.Stats().HasScanner)
+}
+
+func TestScannerNotCreated(t *testing.T) {
+	comp := gora.NewCompiled()
+	require.ErrorIs(t, comp.CreateScanner(), gora.ErrNotCompiled)
+	require.NoError(t, comp.CompileString(gora.ScanFile, `rule r { condition: file_name == "fixture.txt" }`, ""))
+
+	path := filepath.Join(t.TempDir(), "fixture.txt")
+	require.NoError(t, os.WriteFile(path, []byte("test"), 0o666))
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var sctx variables.ScanContextImpl
+	sctx.SetFilePath(path)
+
+	var matches yara.MatchRules
+	check := func() {
+		t.Helper()
+		require.ErrorIs(t, comp.DefineScannerVariables(&sctx), gora.ErrScannerNotCreated)
+		require.ErrorIs(t, comp.SetCallback(&matches).ScanFile(path), gora.ErrScannerNotCreated)
+		require.ErrorIs(t, comp.ScanFileDescriptor(f.Fd()), gora.ErrScannerNotCreated)
+		require.ErrorIs(t, comp.ScanFileDescriptorWithContext(f.Fd(), &sctx), gora.ErrScannerNotCreated)
+		require.ErrorIs(t, comp.ScanOpenFile(f, &sctx), gora.ErrScannerNotCreated)
+		_, err := comp.ScanFileMatches(path, &sctx)
+		require.ErrorIs(t, err, gora.ErrScannerNotCreated)
+		require.ErrorIs(t, comp.ScanMem([]byte("test")), gora.ErrScannerNotCreated)
+		require.ErrorIs(t, comp.ScanProc(os.Getpid()), gora.ErrScannerNotCreated)
+		_, err = comp.ScanProcByName(context.Background(), "test")
+		require.ErrorIs(t, err, gora.ErrScannerNotCreated)
+	}
+	check()
+
+	// The callback set before the scanner is created is used.
+	require.NoError(t, comp.CreateScanner())
+	require.NoError(t, comp.DefineScannerVariables(&sctx))
+	require.NoError(t, comp.ScanFile(path))
+	require.Len(t, matches, 1)
+
+	comp.Destroy()
+	check()
+}
+
+func TestDefineScannerVariablesWith(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileString(gora.ScanFile, `
+rule pinned {
+	condition:
+		file_hash == "0123456789abcdef" and file_path == "/synthetic/path" and file_extension == "txt"
+}`, ""))
+	require.NoError(t, comp.CreateScanner())
+
+	path := filepath.Join(t.TempDir(), "fixture.txt")
+	require.NoError(t, os.WriteFile(path, []byte("test"), 0o666))
+	var sctx variables.ScanContextImpl
+	sctx.SetFilePath(path)
+
+	var matches yara.MatchRules
+	comp.SetCallback(&matches)
+	require.NoError(t, comp.DefineScannerVariables(&sctx))
+	require.NoError(t, comp.ScanFile(path))
+	require.Empty(t, matches)
+
+	// The overrides reach the scanner while the other variables are calculated.
+	require.NoError(t, comp.DefineScannerVariablesWith(&sctx, map[string]interface{}{
+		variables.VarFileHash.String(): []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef},
+		variables.VarFilePath.String(): "/synthetic/path",
+	}))
+	require.NoError(t, comp.ScanFile(path))
+	require.Len(t, matches, 1)
+	values := comp.VariableValues()
+	require.Equal(t, "0123456789abcdef", values[variables.VarFileHash.String()])
+	require.Equal(t, "/synthetic/path", values[variables.VarFilePath.String()])
+	require.Equal(t, "txt", values[variables.VarFileExtension.String()])
+
+	require.Error(t, comp.DefineScannerVariablesWith(&sctx, map[string]interface{}{"unknown": 1}))
+	require.Error(t, comp.DefineScannerVariablesWith(&sctx, map[string]interface{}{
+		variables.VarFileName.String(): "name",
+	}))
+}
+
+func TestScanId(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixture.txt")
+	require.NoError(t, os.WriteFile(path, []byte("test"), 0o666))
+	var sctx variables.ScanContextImpl
+	sctx.SetFilePath(path)
+
+	session := func(comp *gora.Compiled) string {
+		t.Helper()
+		t.Cleanup(comp.Destroy)
+		require.NoError(t, comp.CompileString(gora.ScanFile, `rule r { condition: scan_id != "" }`, ""))
+		require.NoError(t, comp.CreateScanner())
+		id := comp.ScanId()
+		for i := 0; i < 2; i++ {
+			res, err := comp.ScanFileMatches(path, &sctx)
+			require.NoError(t, err)
+			require.Len(t, res.Matches, 1)
+			require.Equal(t, id, res.Variables[variables.VarScanId.String()])
+		}
+		return id
+	}
+	id1 := session(gora.NewCompiled())
+	id2 := session(gora.NewCompiled())
+	require.NotEmpty(t, id1)
+	require.NotEqual(t, id1, id2)
+	require.Equal(t, "session-1", session(gora.NewCompiled().SetScanId("session-1")))
+}
+
+func TestScanFileNamespaces(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileStrings(gora.ScanFile, []gora.RuleNamespace{
+		{Rule: `rule r1 { condition: true } rule r2 { condition: true }`, Namespace: "ns1"},
+		{Rule: `rule r3 { condition: true }`, Namespace: "ns2"},
+	}))
+
+	path := filepath.Join(t.TempDir(), "fixture.txt")
+	require.NoError(t, os.WriteFile(path, []byte("test"), 0o666))
+	_, err := comp.ScanFileNamespaces(path, "ns1")
+	require.ErrorIs(t, err, gora.ErrScannerNotCreated)
+	require.NoError(t, comp.CreateScanner())
+
+	matches, err := comp.ScanFileNamespaces(path, "ns2")
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	require.Equal(t, "r3", matches[0].Rule)
+
+	matches, err = comp.ScanFileNamespaces(path, "ns1")
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+	for _, m := range matches {
+		require.Equal(t, "ns1", m.Namespace)
+	}
+
+	matches, err = comp.ScanFileNamespaces(path)
+	require.NoError(t, err)
+	require.Len(t, matches, 3)
+
+	_, err = comp.ScanFileNamespaces(path, "ns1", "missing")
+	require.ErrorIs(t, err, gora.ErrNamespaceNotFound)
+}
+
+func TestDestroy_concurrent(t *testing.T) {
+	comp := gora.NewCompiled()
+	require.NoError(t, comp.CompileString(gora.ScanFile, `rule r { strings: $a = "test" condition: $a }`, ""))
+	require.NoError(t, comp.CreateScanner())
+
+	path := filepath.Join(t.TempDir(), "fixture.txt")
+	require.NoError(t, os.WriteFile(path, []byte("test"), 0o666))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 8; j++ {
+				errs <- comp.ScanFile(path)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			comp.Destroy()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			require.ErrorIs(t, err, gora.ErrScannerNotCreated)
+		}
+	}
+	require.ErrorIs(t, comp.ScanFile(path), gora.ErrScannerNotCreated)
+	comp.Destroy()
+}
+
+func TestRecompile(t *testing.T) {
+	comp := gora.NewCompiled()
+	defer comp.Destroy()
+	dir := t.TempDir()
+	first := genFile(t, dir, `rule first { strings: $a = "test" condition: $a }`)
+	second := genFile(t, t.TempDir(), `rule second { strings: $a = "test" condition: $a and file_name == "fixture.txt" }`)
+	require.NoError(t, comp.CompileFileOrDir(gora.ScanFile, false, first))
+	require.NoError(t, comp.CreateScanner())
+
+	path := filepath.Join(t.TempDir(), "fixture.txt")
+	require.NoError(t, os.WriteFile(path, []byte("test"), 0o666))
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				var sctx variables.ScanContextImpl
+				sctx.SetFilePath(path)
+				result, err := comp.ScanFileMatches(path, &sctx)
+				if err == nil && len(result.Matches) != 1 {
+					err = errors.New("unexpected matches: " + strconv.Itoa(len(result.Matches)))
+				}
+				if err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		rules := first
+		if i%2 == 0 {
+			rules = second
+		}
+		require.NoError(t, comp.Recompile(gora.ScanFile, false, rules))
+	}
+	close(done)
+	wg.Wait()
+	close(errs)
+	require.NoError(t, <-errs)
+
+	_, ok := comp.GetRule("default", "first")
+	require.True(t, ok)
+	require.Equal(t, []variables.VariableType{}, comp.Variables().Variables())
+
+	// The failed compilation keeps the rules.
+	require.Error(t, comp.Recompile(gora.ScanFile, false, genFile(t, dir, `rule x{`)))
+	_, ok = comp.GetRule("default", "first")
+	require.True(t, ok)
+
+	require.NoError(t, comp.Recompile(gora.ScanFile, false, second))
+	var sctx variables.ScanContextImpl
+	sctx.SetFilePath(path)
+	result, err := comp.ScanFileMatches(path, &sctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"second"}, matchedRuleNames(result.Matches))
+	require.Equal(t, "fixture.txt", result.Variables["file_name"])
+}
+
+func TestCompileReader(t *testing.T) {
+	comp := gora.NewCompiled()
+	require.Error(t, comp.CompileReader(gora.ScanFile, bytes.NewReader([]byte(`rule x{`)), ""))
+	require.Nil(t, comp.Rules())
+
+	errTest := errors.New("test error")
+	require.ErrorIs(t, comp.CompileReader(gora.ScanFile, iotest.ErrReader(errTest), ""), errTest)
+	require.Nil(t, comp.Rules())
+
+	comp = gora.NewCompiled()
+	defer comp.Destroy()
+	rule := `rule r : reader { strings: $a = "test" condition: $a and file_name == "fixture.txt" }`
+	require.NoError(t, comp.CompileReader(gora.ScanFile, bytes.NewReader([]byte(rule)), "stdin"))
+	require.Equal(t, []variables.VariableType{variables.VarFileName}, comp.Variables().Variables())
+	require.NoError(t, comp.CreateScanner())
+
+	path := filepath.Join(t.TempDir(), "fixture.txt")
+	require.NoError(t, os.WriteFile(path, []byte("test"), 0o666))
+	var sctx variables.ScanContextImpl
+	sctx.SetFilePath(path)
+	result, err := comp.ScanFileMatches(path, &sctx)
+	require.NoError(t, err)
+	require.Len(t, result.Matches, 1)
+	require.Equal(t, "stdin", result.Matches[0].Namespace)
+
+	require.ErrorIs(t, comp.CompileReader(gora.ScanFile, strings.NewReader(rule), ""), gora.ErrAlreadyCompiled)
+}
+
+func TestBytesVariable(t *testing.T) {
+	comp := gora.NewCompiled()
+	defer comp.Destroy()
+	require.NoError(t, comp.CompileString(gora.ScanFile, `rule r { condition: file_header_hex startswith "4d5a00" }`, ""))
+	require.NoError(t, comp.CreateScanner())
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{"pe.exe": "MZ\x00\x90", "elf": "\x7fELF"} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o666))
+		var sctx variables.ScanContextImpl
+		sctx.SetFilePath(path)
+		result, err := comp.ScanFileMatches(path, &sctx)
+		require.NoError(t, err)
+		require.Equal(t, name == "pe.exe", len(result.Matches) == 1, name)
+	}
+}
+
+func TestScanFileStaged(t *testing.T) {
+	comp := gora.NewCompiled()
+	defer comp.Destroy()
+	rule := `rule r { strings: $a = "trigger" condition: $a and file_hash != "00" and file_name != "" }`
+	require.NoError(t, comp.CompileString(gora.ScanFile, rule, ""))
+	require.NoError(t, comp.CreateScanner())
+
+	dir := t.TempDir()
+	scan := func(name, content string) *gora.ScanResult {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o666))
+		var sctx variables.ScanContextImpl
+		sctx.SetFilePath(path)
+		result, err := comp.ScanFileStaged(path, &sctx)
+		require.NoError(t, err)
+		return result
+	}
+
+	// The hash is not calculated since the rule does not match in the first scan.
+	result := scan("clean.txt", "nothing")
+	require.Empty(t, result.Matches)
+	require.Equal(t, "", result.Variables["file_hash"])
+	require.Equal(t, "clean.txt", result.Variables["file_name"])
+
+	// The file is scanned again with the hash since the rule matches with its default value in the first scan.
+	sum := sha256.Sum256([]byte("trigger"))
+	result = scan("dirty.txt", "trigger")
+	require.Len(t, result.Matches, 1)
+	require.Equal(t, hex.EncodeToString(sum[:]), result.Variables["file_hash"])
+
+	_, err := gora.NewCompiled().ScanFileStaged(filepath.Join(dir, "clean.txt"), &variables.ScanContextImpl{})
+	require.ErrorIs(t, err, gora.ErrScannerNotCreated)
+}
+
 func TestCompileFile(t *testing.T) {
 	tempDir := t.TempDir()
 
@@ -41,6 +732,342 @@ func TestCompileFile(t *testing.T) {
 	require.NotNil(t, comp.Rules())
 }
 
+func TestCompileFiles_includes(t *testing.T) {
+	dir := t.TempDir()
+	inc := []byte(`rule inc { condition: file_name == "a" }`)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "inc.yar"), inc, 0o666))
+	path := filepath.Join(dir, "main.yar")
+	require.NoError(t, os.WriteFile(path, []byte(`include "inc.yar" rule main { condition: inc }`), 0o666))
+
+	comp := gora.NewCompiled()
+	defer comp.Destroy()
+	require.NoError(t, comp.CompileFiles(gora.ScanFile, false, path))
+	require.Equal(t, []variables.VariableType{variables.VarFileName}, comp.Variables().Variables())
+
+	// The included file which the parser can not parse falls back to all the variables.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "inc.yar"), []byte(rulestrParserUnsupported), 0o666))
+	require.NoError(t, os.WriteFile(path, []byte(`include "inc.yar" rule main { condition: true }`), 0o666))
+	comp = gora.NewCompiled()
+	defer comp.Destroy()
+	require.NoError(t, comp.CompileFiles(gora.ScanFile, false, path))
+	var all variables.Variables
+	all.InitFileVariables(variables.List())
+	require.Equal(t, all.Variables(), comp.Variables().Variables())
+}
+
+func TestCompileFilesScanner(t *testing.T) {
+	tempDir := t.TempDir()
+
+	comp := gora.NewCompiled()
+	path := genFile(t, tempDir, `rule x{`)
+	require.Error(t, comp.CompileFilesScanner(gora.ScanFile, true, path))
+	require.Nil(t, comp.Rules())
+	require.Nil(t, comp.Scanner())
+
+	comp = gora.NewCompiled()
+	defer comp.Destroy()
+	path = genFile(t, tempDir, `rule r { strings: $a = "test" condition: $a }`)
+	require.NoError(t, comp.CompileFilesScanner(gora.ScanFile, true, path))
+	require.NotNil(t, comp.Scanner())
+
+	var matches yara.MatchRules
+	require.NoError(t, comp.SetCallback(&matches).ScanMem([]byte("test")))
+	require.Len(t, matches, 1)
+
+	require.ErrorIs(t, comp.CompileFilesScanner(gora.ScanFile, true, path), gora.ErrAlreadyCompiled)
+}
+
+// countdownContext reports cancellation after its Err method is called n times.
+type countdownContext struct {
+	context.Context
+	n int32
+}
+
+func (c *countdownContext) Err() error {
+	if atomic.AddInt32(&c.n, -1) < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestCompileFilesContext(t *testing.T) {
+	tempDir := t.TempDir()
+	paths := make([]string, 10)
+	for i := range paths {
+		paths[i] = genFile(t, tempDir, `rule r { condition: true }`)
+	}
+
+	openFDs := func() int {
+		entries, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Skip("open file descriptors are not available:", err)
+		}
+		return len(entries)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	comp := gora.NewCompiled()
+	require.ErrorIs(t, comp.CompileFilesContext(ctx, gora.ScanFile, true, paths...), context.Canceled)
+	require.Nil(t, comp.Rules())
+
+	// Cancel while parsing and while adding the files to the compiler.
+	for _, n := range []int32{3, int32(len(paths)) + 3} {
+		before := openFDs()
+		comp = gora.NewCompiled()
+		ctx := &countdownContext{Context: context.Background(), n: n}
+		require.ErrorIs(t, comp.CompileFilesContext(ctx, gora.ScanFile, true, paths...), context.Canceled)
+		require.Nil(t, comp.Rules())
+		require.Equal(t, before, openFDs())
+	}
+
+	comp = gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileFilesContext(context.Background(), gora.ScanFile, true, paths...))
+	require.Len(t, comp.Rules().GetRules(), len(paths))
+}
+
+func TestSetMaxMatchData(t *testing.T) {
+	orig, err := yara.GetConfiguration(yara.ConfigMaxMatchData)
+	require.NoError(t, err)
+
+	comp := gora.NewCompiled()
+	t.Cleanup(func() {
+		comp.Destroy()
+		require.NoError(t, gora.SetMaxMatchData(orig.(int)))
+	})
+
+	err = comp.CompileString(gora.ScanFile, `rule long_match { strings: $a = /BEGIN[A]+END/ condition: $a }`, "")
+	require.NoError(t, err)
+	require.NoError(t, comp.CreateScanner())
+
+	data := "BEGIN" + strings.Repeat("A", 1000) + "END"
+	path := genFile(t, t.TempDir(), data)
+
+	scan := func() []byte {
+		var matches yara.MatchRules
+		require.NoError(t, comp.SetCallback(&matches).ScanFile(path))
+		require.Len(t, matches, 1)
+		require.Len(t, matches[0].Strings, 1)
+		return matches[0].Strings[0].Data
+	}
+
+	require.NoError(t, gora.SetMaxMatchData(16))
+	require.Len(t, scan(), 16)
+
+	require.NoError(t, gora.SetMaxMatchData(4096))
+	require.Equal(t, []byte(data), scan())
+}
+
+func TestScanFileDescriptorWithContext(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+
+	err := comp.CompileString(gora.ScanFile, rulestrFileVars, "")
+	require.NoError(t, err)
+	require.NoError(t, comp.CreateScanner())
+
+	path := filepath.Join(t.TempDir(), "fixture.txt")
+	require.NoError(t, os.WriteFile(path, []byte("test"), 0o666))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	info, err := f.Stat()
+	require.NoError(t, err)
+
+	var sctx variables.ScanContextImpl
+	sctx.SetFilePath(f.Name())
+	sctx.SetFileInfo(info)
+
+	var matches yara.MatchRules
+	comp.SetCallback(&matches)
+	require.NoError(t, comp.ScanFileDescriptorWithContext(f.Fd(), &sctx))
+	require.Len(t, matches, 1)
+	require.Equal(t, "test_file_vars", matches[0].Rule)
+
+	// Without the context, file variables are not defined and the rule does not match.
+	sctx.Reset()
+	matches = nil
+	require.NoError(t, comp.ScanFileDescriptorWithContext(f.Fd(), &sctx))
+	require.Empty(t, matches)
+}
+
+func TestScanOpenFile(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+
+	rules := rulestrFileVars + `rule test_content { strings: $a = "test" condition: $a }`
+	require.NoError(t, comp.CompileString(gora.ScanFile, rules, ""))
+	require.NoError(t, comp.CreateScanner())
+
+	path := filepath.Join(t.TempDir(), "fixture.txt")
+	require.NoError(t, os.WriteFile(path, []byte("test"), 0o666))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	info, err := f.Stat()
+	require.NoError(t, err)
+
+	var sctx variables.ScanContextImpl
+	sctx.SetFilePath(f.Name())
+	sctx.SetFileInfo(info)
+
+	var byPath, byFile yara.MatchRules
+	require.NoError(t, comp.DefineScannerVariables(&sctx))
+	require.NoError(t, comp.SetCallback(&byPath).ScanFile(path))
+	require.NoError(t, comp.SetCallback(&byFile).ScanOpenFile(f, &sctx))
+
+	require.Equal(t, []string{"test_file_vars", "test_content"}, matchedRuleNames(byFile))
+	require.Equal(t, matchedRuleNames(byPath), matchedRuleNames(byFile))
+
+	// The file is left open.
+	_, err = f.Stat()
+	require.NoError(t, err)
+}
+
+func TestScanFile_notRegular(t *testing.T) {
+	comp := gora.NewCompiled()
+	defer comp.Destroy()
+	require.NoError(t, comp.CompileString(gora.ScanFile, `rule r { condition: true }`, ""))
+	require.NoError(t, comp.CreateScanner())
+
+	dir := t.TempDir()
+	paths := []string{dir}
+	if runtime.GOOS != "windows" {
+		fifo := filepath.Join(dir, "fifo")
+		require.NoError(t, exec.Command("mkfifo", fifo).Run())
+		paths = append(paths, fifo)
+	}
+	for _, path := range paths {
+		var sctx variables.ScanContextImpl
+		sctx.SetFilePath(path)
+		require.ErrorIs(t, comp.ScanFile(path), gora.ErrNotRegularFile, path)
+		_, err := comp.ScanFileMatches(path, &sctx)
+		require.ErrorIs(t, err, gora.ErrNotRegularFile, path)
+		_, err = comp.ScanFileStaged(path, &sctx)
+		require.ErrorIs(t, err, gora.ErrNotRegularFile, path)
+	}
+
+	require.True(t, os.IsNotExist(comp.ScanFile(filepath.Join(dir, "missing"))))
+}
+
+func TestScanFileMatches(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+
+	err := comp.CompileString(gora.ScanFile, rulestrFileVars, "")
+	require.NoError(t, err)
+	require.NoError(t, comp.CreateScanner())
+
+	path := filepath.Join(t.TempDir(), "fixture.txt")
+	require.NoError(t, os.WriteFile(path, []byte("test"), 0o666))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	var sctx variables.ScanContextImpl
+	sctx.SetFilePath(path)
+	sctx.SetFileInfo(info)
+
+	res, err := comp.ScanFileMatches(path, &sctx)
+	require.NoError(t, err)
+	require.Len(t, res.Matches, 1)
+	require.Equal(t, "test_file_vars", res.Matches[0].Rule)
+
+	require.Len(t, res.Variables, 3)
+	require.Equal(t, "fixture.txt", res.Variables[variables.VarFileName.String()])
+	require.Equal(t, "txt", res.Variables[variables.VarFileExtension.String()])
+	require.Greater(t, res.Variables[variables.VarFileModifiedTime.String()], int64(0))
+	require.Equal(t, res.Variables, comp.VariableValues())
+}
+
+func TestScanFileProcess(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+
+	rule := `rule test_both { condition: file_name == "fixture.txt" or (process_id == 42 and process_name == "fake") }`
+	err := comp.CompileString(gora.ScanFileProcess, rule, "")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []variables.VariableType{
+		variables.VarFileName, variables.VarProcessId, variables.VarProcessName,
+	}, comp.Variables().Variables())
+	require.NoError(t, comp.CreateScanner())
+
+	path := filepath.Join(t.TempDir(), "fixture.txt")
+	require.NoError(t, os.WriteFile(path, []byte("test"), 0o666))
+
+	var fileCtx variables.ScanContextImpl
+	fileCtx.SetFilePath(path)
+	res, err := comp.ScanFileMatches(path, &fileCtx)
+	require.NoError(t, err)
+	require.Len(t, res.Matches, 1)
+	require.Equal(t, int64(0), res.Variables[variables.VarProcessId.String()])
+
+	var procCtx variables.ScanContextImpl
+	procCtx.SetPid(42)
+	procCtx.SetProcessInfo(&fakeProcessInfo{name: "fake"})
+	require.NoError(t, comp.DefineScannerVariables(&procCtx))
+
+	var matches yara.MatchRules
+	require.NoError(t, comp.SetCallback(&matches).Scanner().ScanMem([]byte("process memory")))
+	require.Len(t, matches, 1)
+	require.Equal(t, "", comp.VariableValues()[variables.VarFileName.String()])
+}
+
+func TestScanMemBufferScanContext(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+
+	rule := `rule test_buffer { strings: $a = "test" condition: $a and file_name == "invoice.pdf" }`
+	require.NoError(t, comp.CompileString(gora.ScanFile, rule, ""))
+	require.NoError(t, comp.CreateScanner())
+
+	scan := func(name string) yara.MatchRules {
+		buf := []byte("test buffer")
+		require.NoError(t, comp.DefineScannerVariables(variables.NewBufferScanContext(name, buf)))
+		var matches yara.MatchRules
+		require.NoError(t, comp.SetCallback(&matches).ScanMem(buf))
+		return matches
+	}
+
+	require.Len(t, scan("invoice.pdf"), 1)
+	require.Empty(t, scan("invoice.exe"))
+}
+
+type fakeProcessInfo struct {
+	ppid     int32
+	username string
+	name     string
+	cmdline  string
+	exe      string
+}
+
+var (
+	_ variables.ProcessInfo       = (*fakeProcessInfo)(nil)
+	_ variables.ProcessExecutable = (*fakeProcessInfo)(nil)
+)
+
+func (p *fakeProcessInfo) Ppid() (int32, error) { return p.ppid, nil }
+
+func (p *fakeProcessInfo) Username() (string, error) { return p.username, nil }
+
+func (p *fakeProcessInfo) NameWithContext(context.Context) (string, error) { return p.name, nil }
+
+func (p *fakeProcessInfo) CmdlineWithContext(context.Context) (string, error) { return p.cmdline, nil }
+
+func (p *fakeProcessInfo) ExeWithContext(context.Context) (string, error) { return p.exe, nil }
+
+const rulestrFileVars = `
+rule test_file_vars
+{
+    condition:
+        file_name == "fixture.txt" and file_extension == "txt" and file_modified_time > 0
+}
+`
+
 const rulestrFs = `
 	rule test_fs
 {
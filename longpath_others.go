@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package gora
+
+// extendedPath returns the given path as is since only Windows limits the path length to MAX_PATH.
+func extendedPath(path string) string {
+	return path
+}
@@ -0,0 +1,28 @@
+package gora
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPath is the MAX_PATH limit of the Windows APIs which do not support the long paths, including the ones libyara
+// uses to open the files.
+const maxPath = 260
+
+// extendedPath returns the extended-length form of the given path, prefixed by `\\?\`, if it exceeds MAX_PATH so that
+// it can be opened by the Windows APIs. The paths within the limit and the already prefixed ones are returned as is.
+// The extended-length form is only used to open the files, the user-facing path is kept for the namespaces and the
+// scanner variables.
+func extendedPath(path string) string {
+	if len(path) < maxPath || strings.HasPrefix(path, `\\?\`) || strings.HasPrefix(path, `\\.\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
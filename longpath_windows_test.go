@@ -0,0 +1,74 @@
+package gora_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/binalyze/gora"
+	"github.com/binalyze/gora/variables"
+)
+
+// longDir returns a directory in a temporary directory whose path exceeds MAX_PATH.
+func longDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for len(dir) < 300 {
+		dir = filepath.Join(dir, strings.Repeat("d", 60))
+	}
+	require.NoError(t, os.MkdirAll(dir, 0o777))
+	return dir
+}
+
+func TestLongPath(t *testing.T) {
+	dir := longDir(t)
+	rule := writeRuleFile(t, filepath.Join(dir, "long.yar"),
+		`rule r { strings: $a = "long" condition: $a and file_name == "fixture.txt" and file_path != "" }`)
+
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileFilesScanner(gora.ScanFile, true, rule))
+	require.Equal(t, []string{"long.yar"}, ruleNamespaces(comp))
+
+	path := filepath.Join(dir, "fixture.txt")
+	require.NoError(t, os.WriteFile(path, []byte("long path"), 0o666))
+	require.NoError(t, comp.ScanFile(path))
+
+	var sctx variables.ScanContextImpl
+	sctx.SetFilePath(path)
+	res, err := comp.ScanFileMatches(path, &sctx)
+	require.NoError(t, err)
+	require.Len(t, res.Matches, 1)
+	// The variables present the path as it is given, not its extended-length form.
+	require.Equal(t, path, res.Variables[variables.VarFilePath.String()])
+
+	tree, err := comp.ScanTree(context.Background(), dir, gora.ScanTreeOptions{})
+	require.NoError(t, err)
+	require.Empty(t, tree.Errors)
+	require.Contains(t, tree.Matches, path)
+}
+
+func TestLongPath_relative(t *testing.T) {
+	dir := longDir(t)
+	rule := writeRuleFile(t, filepath.Join(dir, "long.yar"), `rule r { condition: true }`)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	rel, err := filepath.Rel(wd, rule)
+	if err != nil || len(rel) < 260 {
+		t.Skip("temporary directory is not relative to the working directory")
+	}
+
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	comp.SetNamespaceFunc(func(path string) string {
+		require.Equal(t, rel, path)
+		return "relative"
+	})
+	require.NoError(t, comp.CompileFiles(gora.ScanFile, true, rel))
+	require.Equal(t, []string{"relative"}, ruleNamespaces(comp))
+}
@@ -0,0 +1,96 @@
+package gora
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Manifest lists the rule files of a rule repository with their namespaces. It is read from a JSON file by
+// CompileManifest, e.g.
+//
+//	{"rules": [
+//		{"file": "malware/emotet.yar", "namespace": "malware"},
+//		{"file": "experimental.yar", "namespace": "test", "enabled": false}
+//	]}
+type Manifest struct {
+	Rules []ManifestEntry `json:"rules"`
+}
+
+// ManifestEntry is a rule file of a Manifest. The relative file paths are resolved relative to the directory of the
+// manifest file. The rules are compiled into the default namespace if the namespace is empty. The entries are enabled
+// unless their enabled flag is false.
+type ManifestEntry struct {
+	File      string `json:"file"`
+	Namespace string `json:"namespace,omitempty"`
+	Enabled   *bool  `json:"enabled,omitempty"`
+}
+
+// IsEnabled reports whether the entry should be compiled.
+func (e ManifestEntry) IsEnabled() bool {
+	return e.Enabled == nil || *e.Enabled
+}
+
+// ReadManifest reads the manifest in the given JSON file.
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(extendedPath(path))
+	if err != nil {
+		return nil, err
+	}
+	m := new(Manifest)
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("manifest '%s' error: %w", path, err)
+	}
+	return m, nil
+}
+
+// CompileManifest compiles the enabled rule files listed in the given manifest file using the namespaces specified in
+// the manifest instead of the file names. The files are compiled in the order of the manifest as CompileFiles does, so
+// the relative includes are resolved relative to the rule files. See Manifest.
+func (c *Compiled) CompileManifest(target ScanTarget, manifestPath string) error {
+	if c.rules != nil {
+		return ErrAlreadyCompiled
+	}
+
+	m, err := ReadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(manifestPath)
+	paths := make([]string, 0, len(m.Rules))
+	namespaces := make(map[string]string, len(m.Rules))
+	for i, entry := range m.Rules {
+		if entry.File == "" {
+			return fmt.Errorf("manifest '%s' error: entry #%d has no file", manifestPath, i+1)
+		}
+		if !entry.IsEnabled() {
+			continue
+		}
+		path := entry.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if prev, ok := namespaces[path]; ok && prev != entry.Namespace {
+			return fmt.Errorf("manifest '%s' error: '%s' has multiple namespaces", manifestPath, entry.File)
+		} else if ok {
+			continue
+		}
+		paths = append(paths, path)
+		namespaces[path] = entry.Namespace
+	}
+	if len(paths) == 0 {
+		return errors.New("no enabled rule files in manifest")
+	}
+
+	return c.compileFilesWith(context.Background(), target, paths, func(regular []string) ([]string, error) {
+		fileNS := make([]string, len(regular))
+		for i, path := range regular {
+			fileNS[i] = namespaces[path]
+		}
+		return fileNS, nil
+	})
+}
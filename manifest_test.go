@@ -0,0 +1,68 @@
+package gora_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/binalyze/gora"
+	"github.com/binalyze/gora/variables"
+)
+
+func writeManifest(t *testing.T, path, manifest string) string {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(manifest), 0o666))
+	return path
+}
+
+func TestCompileManifest(t *testing.T) {
+	dir := t.TempDir()
+	writeRuleFile(t, filepath.Join(dir, "malware", "a.yar"), `rule r1 { condition: file_name == "a" }`)
+	writeRuleFile(t, filepath.Join(dir, "b.yar"), `rule r2 { condition: true }`)
+	writeRuleFile(t, filepath.Join(dir, "c.yar"), `rule r3 { condition: file_size > 0 }`)
+	writeRuleFile(t, filepath.Join(dir, "d.yar"), `rule r4 { condition: true }`)
+	manifest := writeManifest(t, filepath.Join(dir, "manifest.json"), `{"rules": [
+		{"file": "malware/a.yar", "namespace": "malware"},
+		{"file": "c.yar", "namespace": "experimental", "enabled": false},
+		{"file": "b.yar", "namespace": "generic", "enabled": true},
+		{"file": "d.yar"}
+	]}`)
+
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileManifest(gora.ScanFile, manifest))
+	require.Equal(t, []string{"malware", "generic", "default"}, ruleNamespaces(comp))
+	_, ok := comp.GetRule("experimental", "r3")
+	require.False(t, ok)
+	require.Equal(t, []variables.VariableType{variables.VarFileName}, comp.Variables().Variables())
+
+	require.ErrorIs(t, comp.CompileManifest(gora.ScanFile, manifest), gora.ErrAlreadyCompiled)
+}
+
+func TestCompileManifest_error(t *testing.T) {
+	dir := t.TempDir()
+	writeRuleFile(t, filepath.Join(dir, "a.yar"), `rule r1 { condition: true }`)
+
+	tests := []struct {
+		name     string
+		manifest string
+	}{
+		{name: "invalid", manifest: `{"rules": [`},
+		{name: "no file", manifest: `{"rules": [{"namespace": "ns"}]}`},
+		{name: "missing file", manifest: `{"rules": [{"file": "missing.yar"}]}`},
+		{name: "none enabled", manifest: `{"rules": [{"file": "a.yar", "enabled": false}]}`},
+		{name: "multiple namespaces", manifest: `{"rules": [{"file": "a.yar", "namespace": "x"}, {"file": "a.yar"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest := writeManifest(t, filepath.Join(dir, "manifest.json"), tt.manifest)
+			comp := gora.NewCompiled()
+			require.Error(t, comp.CompileManifest(gora.ScanFile, manifest))
+			require.Nil(t, comp.Rules())
+		})
+	}
+
+	require.Error(t, gora.NewCompiled().CompileManifest(gora.ScanFile, filepath.Join(dir, "missing.json")))
+}
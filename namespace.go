@@ -0,0 +1,100 @@
+package gora
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNamespaceCollision is returned when the file name namespaces of two different rule files are the same and the
+// collision check is enabled by SetNamespaceCollisionCheck.
+var ErrNamespaceCollision = errors.New("namespace collision")
+
+// NamespaceFunc returns the namespace of the rule file at the given path.
+type NamespaceFunc func(path string) string
+
+// FilenameNamespace is a NamespaceFunc returning the base name of the file sanitized by SanitizeNamespace. Unlike the
+// default namespaces, which are the base names as they are, it is set explicitly by SetNamespaceFunc.
+func FilenameNamespace(path string) string {
+	return SanitizeNamespace(filepath.Base(path))
+}
+
+// SanitizeNamespace replaces the characters of the given namespace which are not ASCII letters, digits, '_', '-' or
+// '.' with '_'.
+func SanitizeNamespace(ns string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, ns)
+}
+
+// SetNamespaceFunc sets the function to map the rule file paths to namespaces when the file name namespaces are
+// enabled, e.g. FilenameNamespace to sanitize them. By default, the base names of the files are used as they are.
+func (c *Compiled) SetNamespaceFunc(fn NamespaceFunc) *Compiled {
+	c.nsFunc = fn
+	return c
+}
+
+// SetNamespaceCollisionCheck enables or disables the collision check of the file name namespaces. If it is enabled,
+// the compilation fails with ErrNamespaceCollision when two different files are mapped to the same namespace, e.g. the
+// files having the same base name in different directories. It is disabled by default, so the rules of such files are
+// compiled into the same namespace, and a custom NamespaceFunc can put multiple files into a namespace intentionally.
+func (c *Compiled) SetNamespaceCollisionCheck(enabled bool) *Compiled {
+	c.nsUnique = enabled
+	return c
+}
+
+// SetNamespacePrefix sets the prefix prepended to the namespaces of the rule files when the file name namespaces are
+// enabled, e.g. "vendor." to tell the namespaces of a rule repository from the others. It is applied to the namespaces
+// returned by the function set by SetNamespaceFunc as well. The namespaces given explicitly, e.g. by CompileStrings or
+// CompileManifest, are not prefixed.
+func (c *Compiled) SetNamespacePrefix(prefix string) *Compiled {
+	c.nsPrefix = prefix
+	return c
+}
+
+// RelativeNamespace returns a NamespaceFunc which derives the namespace from the path of the rule file relative to the
+// given root directory, joining its sanitized elements with the given separator, e.g. "malware.windows.emotet.yar"
+// for root/malware/windows/emotet.yar with "." separator. Unlike the base names, the namespaces of the files in
+// different directories can not collide. The paths which are not under the root get FilenameNamespace.
+func RelativeNamespace(root, sep string) NamespaceFunc {
+	return func(path string) string {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return FilenameNamespace(path)
+		}
+		elems := strings.Split(rel, string(filepath.Separator))
+		for i := range elems {
+			elems[i] = SanitizeNamespace(elems[i])
+		}
+		return strings.Join(elems, sep)
+	}
+}
+
+// pathNamespaces returns the namespaces of the given rule file paths using the given function, prefixed by the given
+// prefix. The base names are used if the function is nil, and the collisions are checked if unique is true.
+func pathNamespaces(nsFunc NamespaceFunc, prefix string, paths []string, filenameNS, unique bool) ([]string, error) {
+	namespaces := make([]string, len(paths))
+	if !filenameNS {
+		return namespaces, nil
+	}
+	if nsFunc == nil {
+		nsFunc = filepath.Base
+	}
+
+	seen := make(map[string]string, len(paths))
+	for i, path := range paths {
+		ns := prefix + nsFunc(path)
+		if prev, ok := seen[ns]; ok && unique && prev != path {
+			return nil, fmt.Errorf("%w: '%s' and '%s' have the same namespace '%s'",
+				ErrNamespaceCollision, prev, path, ns)
+		}
+		seen[ns] = path
+		namespaces[i] = ns
+	}
+	return namespaces, nil
+}
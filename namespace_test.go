@@ -0,0 +1,169 @@
+package gora_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/binalyze/gora"
+)
+
+func TestSanitizeNamespace(t *testing.T) {
+	require.Equal(t, "rules.yar", gora.SanitizeNamespace("rules.yar"))
+	require.Equal(t, "my_rules_v-1_.yar", gora.SanitizeNamespace("my rules v-1!.yar"))
+	require.Equal(t, "r_les", gora.SanitizeNamespace("rüles"))
+	require.Equal(t, "my_rules.yar", gora.FilenameNamespace(filepath.Join("a", "my rules.yar")))
+}
+
+func TestCompileFiles_namespaces(t *testing.T) {
+	dir := t.TempDir()
+	path1 := writeRuleFile(t, filepath.Join(dir, "a", "my rules.yar"), `rule r1 { condition: true }`)
+	path2 := writeRuleFile(t, filepath.Join(dir, "b", "other.yar"), `rule r2 { condition: true }`)
+
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileFiles(gora.ScanFile, true, path1, path2))
+	require.Equal(t, []string{"my rules.yar", "other.yar"}, ruleNamespaces(comp))
+
+	// The namespaces are sanitized by FilenameNamespace.
+	comp = gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	comp.SetNamespaceFunc(gora.FilenameNamespace)
+	require.NoError(t, comp.CompileFiles(gora.ScanFile, true, path1, path2))
+	require.Equal(t, []string{"my_rules.yar", "other.yar"}, ruleNamespaces(comp))
+}
+
+func TestCompileFiles_namespaceCollision(t *testing.T) {
+	dir := t.TempDir()
+	path1 := writeRuleFile(t, filepath.Join(dir, "a", "rules.yar"), `rule r1 { condition: true }`)
+	path2 := writeRuleFile(t, filepath.Join(dir, "b", "rules.yar"), `rule r2 { condition: true }`)
+
+	// The collisions are not checked by default.
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileFiles(gora.ScanFile, true, path1, path2))
+	require.Equal(t, []string{"rules.yar", "rules.yar"}, ruleNamespaces(comp))
+
+	comp = gora.NewCompiled().SetNamespaceCollisionCheck(true)
+	err := comp.CompileFiles(gora.ScanFile, true, path1, path2)
+	require.ErrorIs(t, err, gora.ErrNamespaceCollision)
+	require.Nil(t, comp.Rules())
+
+	// The same file does not collide with itself.
+	comp = gora.NewCompiled().SetNamespaceCollisionCheck(true)
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileFiles(gora.ScanFile, true, path1, path1))
+
+	// Namespaces are not used, so there is no collision.
+	comp = gora.NewCompiled().SetNamespaceCollisionCheck(true)
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileFiles(gora.ScanFile, false, path1, path2))
+	require.Equal(t, []string{"default", "default"}, ruleNamespaces(comp))
+}
+
+func TestCompileFiles_namespaceFunc(t *testing.T) {
+	dir := t.TempDir()
+	path1 := writeRuleFile(t, filepath.Join(dir, "a", "rules.yar"), `rule r1 { condition: true }`)
+	path2 := writeRuleFile(t, filepath.Join(dir, "b", "rules.yar"), `rule r2 { condition: true }`)
+
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	comp.SetNamespaceFunc(func(path string) string {
+		return filepath.Base(filepath.Dir(path)) + "_" + strings.TrimSuffix(filepath.Base(path), ".yar")
+	})
+	require.NoError(t, comp.CompileFiles(gora.ScanFile, true, path1, path2))
+	require.Equal(t, []string{"a_rules", "b_rules"}, ruleNamespaces(comp))
+
+	// Custom functions may put multiple files into the same namespace unless the collisions are checked.
+	comp = gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	comp.SetNamespaceFunc(func(string) string { return "all" })
+	require.NoError(t, comp.CompileFiles(gora.ScanFile, true, path1, path2))
+	require.Equal(t, []string{"all", "all"}, ruleNamespaces(comp))
+	comp = gora.NewCompiled().SetNamespaceCollisionCheck(true)
+	comp.SetNamespaceFunc(func(string) string { return "all" })
+	require.ErrorIs(t, comp.CompileFiles(gora.ScanFile, true, path1, path2), gora.ErrNamespaceCollision)
+}
+
+func TestSetNamespacePrefix(t *testing.T) {
+	dir := t.TempDir()
+	path1 := writeRuleFile(t, filepath.Join(dir, "malware", "windows", "rules.yar"), `rule r1 { condition: true }`)
+	path2 := writeRuleFile(t, filepath.Join(dir, "malware", "linux", "rules.yar"), `rule r2 { condition: true }`)
+	path3 := writeRuleFile(t, filepath.Join(dir, "my tools.yar"), `rule r3 { condition: true }`)
+
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	comp.SetNamespacePrefix("vendor.").SetNamespaceFunc(gora.RelativeNamespace(dir, "."))
+	require.NoError(t, comp.CompileFiles(gora.ScanFile, true, path1, path2, path3))
+	require.Equal(t, []string{
+		"vendor.malware.windows.rules.yar", "vendor.malware.linux.rules.yar", "vendor.my_tools.yar",
+	}, ruleNamespaces(comp))
+
+	// The prefix applies to the default namespaces too.
+	comp = gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	comp.SetNamespacePrefix("vendor_")
+	require.NoError(t, comp.CompileFiles(gora.ScanFile, true, path1, path3))
+	require.Equal(t, []string{"vendor_rules.yar", "vendor_my tools.yar"}, ruleNamespaces(comp))
+	comp = gora.NewCompiled().SetNamespacePrefix("vendor_").SetNamespaceCollisionCheck(true)
+	require.ErrorIs(t, comp.CompileFiles(gora.ScanFile, true, path1, path2), gora.ErrNamespaceCollision)
+
+	// The prefix is not used if the file name namespaces are disabled.
+	comp = gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	comp.SetNamespacePrefix("vendor_")
+	require.NoError(t, comp.CompileFiles(gora.ScanFile, false, path1))
+	require.Equal(t, []string{"default"}, ruleNamespaces(comp))
+}
+
+func TestRelativeNamespace(t *testing.T) {
+	root := filepath.Join("rules", "repo")
+	fn := gora.RelativeNamespace(root, "/")
+	require.Equal(t, "malware/win_dows.yar", fn(filepath.Join(root, "malware", "win dows.yar")))
+	require.Equal(t, "a.yar", fn(filepath.Join(root, "a.yar")))
+	require.Equal(t, "other.yar", fn(filepath.Join("rules", "other.yar")))
+}
+
+func writeRuleFile(t *testing.T, path, rule string) string {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o777))
+	require.NoError(t, os.WriteFile(path, []byte(rule), 0o666))
+	return path
+}
+
+func ruleNamespaces(comp *gora.Compiled) []string {
+	var namespaces []string
+	for _, r := range comp.Rules().GetRules() {
+		r := r
+		namespaces = append(namespaces, r.Namespace())
+	}
+	return namespaces
+}
+
+func TestLoadRuleNamespaces(t *testing.T) {
+	dir := t.TempDir()
+	writeRuleFile(t, filepath.Join(dir, "b rules.yara"), `rule r2 { condition: true }`)
+	writeRuleFile(t, filepath.Join(dir, "a.YAR"), `rule r1 { condition: true }`)
+	writeRuleFile(t, filepath.Join(dir, "readme.txt"), `not a rule`)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "dir.yar"), 0o755))
+
+	ruleNs, err := gora.LoadRuleNamespaces(dir, true)
+	require.NoError(t, err)
+	require.Equal(t, []gora.RuleNamespace{
+		{Rule: `rule r1 { condition: true }`, Namespace: "a.YAR"},
+		{Rule: `rule r2 { condition: true }`, Namespace: "b rules.yara"},
+	}, ruleNs)
+
+	ruleNs, err = gora.LoadRuleNamespaces(dir, false)
+	require.NoError(t, err)
+	require.Equal(t, []gora.RuleNamespace{
+		{Rule: `rule r1 { condition: true }`},
+		{Rule: `rule r2 { condition: true }`},
+	}, ruleNs)
+
+	_, err = gora.LoadRuleNamespaces(t.TempDir(), true)
+	require.Error(t, err)
+}
@@ -0,0 +1,329 @@
+package gora
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hillu/go-yara/v4"
+	"github.com/shirou/gopsutil/v3/process"
+
+	"github.com/binalyze/gora/variables"
+	"github.com/binalyze/gora/variables/procinfo"
+)
+
+// Process represents a running process listed by a ProcessEnumerator.
+type Process struct {
+	Pid  int
+	Name string
+	// Info is used to define the process variables. It is optional.
+	Info variables.ProcessInfo
+}
+
+// ProcessEnumerator is an interface that wraps the Processes method which lists the running processes.
+type ProcessEnumerator interface {
+	Processes(ctx context.Context) ([]Process, error)
+}
+
+// ProcessEnumeratorFunc is an helper type to implement ProcessEnumerator interface using a function.
+type ProcessEnumeratorFunc func(ctx context.Context) ([]Process, error)
+
+// Processes implements ProcessEnumerator interface.
+func (fn ProcessEnumeratorFunc) Processes(ctx context.Context) ([]Process, error) {
+	return fn(ctx)
+}
+
+// SetProcessEnumerator sets the enumerator used by ScanProcByName and ScanAllProcesses. By default, the processes are listed using gopsutil.
+func (c *Compiled) SetProcessEnumerator(e ProcessEnumerator) *Compiled {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.procEnum = e
+	return c
+}
+
+// SetProcessInfoFactory sets the factory used to create the ProcessInfo of each scanned process by ScanProcsParallel,
+// and by ScanProcByName and ScanAllProcesses for the processes listed without Info. By default,
+// procinfo.NewProcessInfo is used.
+func (c *Compiled) SetProcessInfoFactory(fn variables.ProcessInfoFactory) *Compiled {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.procInfo = fn
+	return c
+}
+
+// processEnumerator returns the enumerator set by SetProcessEnumerator or the one listing the processes using gopsutil.
+// The caller must hold c.mu.
+func (c *Compiled) processEnumerator() ProcessEnumerator {
+	if c.procEnum != nil {
+		return c.procEnum
+	}
+	return ProcessEnumeratorFunc(gopsutilProcesses)
+}
+
+// processInfoFactory returns the factory set by SetProcessInfoFactory or the default one. The caller must hold c.mu.
+func (c *Compiled) processInfoFactory() variables.ProcessInfoFactory {
+	if c.procInfo != nil {
+		return c.procInfo
+	}
+	return procinfo.NewProcessInfo
+}
+
+// ScanProcByName scans all the processes having the given name in the ascending order of their ids, and returns the ids
+// of the scanned processes. Names are compared case-insensitively on Windows. Since multiple processes may share the
+// same name, the callback is called for the matches of each process, and the process variables are defined before each
+// scan. The variable value errors are ignored and the variables get their default values. It returns an empty list if
+// there is no process having the name.
+//
+// If a scan fails, e.g. the process exits after it is listed, it returns the ids scanned until then along with the
+// error.
+func (c *Compiled) ScanProcByName(ctx context.Context, name string) ([]int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scanner == nil {
+		return nil, ErrScannerNotCreated
+	}
+	procs, err := c.processEnumerator().Processes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := procs[:0:0]
+	for _, proc := range procs {
+		if proc.Name == name || (runtime.GOOS == "windows" && strings.EqualFold(proc.Name, name)) {
+			matched = append(matched, proc)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Pid < matched[j].Pid })
+
+	pids := make([]int, 0, len(matched))
+	for _, proc := range matched {
+		if err = ctx.Err(); err != nil {
+			return pids, err
+		}
+
+		if err = c.defineProcessVariables(ctx, proc); err != nil {
+			return pids, err
+		}
+		if err = c.scanner.ScanProc(proc.Pid); err != nil {
+			return pids, err
+		}
+		pids = append(pids, proc.Pid)
+	}
+	return pids, nil
+}
+
+// ProcScanErrors holds the errors of the processes which could not be scanned by ScanAllProcesses, e.g. due to
+// permission denied or the process exiting after it is listed.
+type ProcScanErrors map[int]error
+
+func (e ProcScanErrors) Error() string {
+	pids := make([]int, 0, len(e))
+	for pid := range e {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	msgs := make([]string, 0, len(pids))
+	for _, pid := range pids {
+		msgs = append(msgs, fmt.Sprintf("%d: %s", pid, e[pid]))
+	}
+	return fmt.Sprintf("%d processes could not be scanned: %s", len(e), strings.Join(msgs, ", "))
+}
+
+// ScanAllProcesses scans all the processes listed by the process enumerator in the ascending order of their ids. The
+// processes are skipped if the filter returns false for them, a nil filter scans all. The process variables are
+// defined for each process as ScanProcByName does, and onMatch is called with the matches of each process having at
+// least one match instead of the callback set by SetCallback.
+//
+// It scans with its own scanner and a copy of the variables as ScanProcsParallel does, so the scanner created by
+// CreateScanner is not required, the other scans are not blocked and VariableValues is not updated. Destroy and the
+// methods replacing the rules wait for it.
+//
+// The processes which could not be scanned do not stop the scan, their errors are returned as ProcScanErrors after
+// all the processes are scanned. If the enumerator fails or the context is done, it returns that error immediately.
+func (c *Compiled) ScanAllProcesses(ctx context.Context, filter func(pid int) bool,
+	onMatch func(pid int, m yara.MatchRules)) error {
+	c.rulesMu.RLock()
+	defer c.rulesMu.RUnlock()
+	sw, err := c.beginSweep(1, 0)
+	if err != nil {
+		return err
+	}
+	defer sw.end()
+
+	procs, err := sw.procEnum.Processes(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].Pid < procs[j].Pid })
+	s := sw.scanners[0]
+
+	errs := make(ProcScanErrors)
+	for _, proc := range procs {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if filter != nil && !filter(proc.Pid) {
+			continue
+		}
+
+		var matches yara.MatchRules
+		var sctx *variables.ScanContextImpl
+		if sctx, err = processScanContext(ctx, proc, sw.newInfo); err == nil {
+			err = sw.vars.DefineScannerVariables(sctx, s)
+		}
+		if err == nil {
+			err = s.SetCallback(sw.wrapCallback(&matches)).ScanProc(proc.Pid)
+		}
+		if err != nil {
+			errs[proc.Pid] = err
+			continue
+		}
+		if len(matches) > 0 && onMatch != nil {
+			onMatch(proc.Pid, matches)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// defineProcessVariables defines the scanner variables for the given process ignoring the value errors.
+func (c *Compiled) defineProcessVariables(ctx context.Context, proc Process) error {
+	sctx, err := processScanContext(ctx, proc, c.processInfoFactory())
+	if err != nil {
+		return err
+	}
+	return c.defineScannerVariables(sctx)
+}
+
+// processScanContext returns the scan context of the given process created by variables.NewProcessScanContext. The
+// process info is created using the given factory if the process is listed without it.
+func processScanContext(ctx context.Context, proc Process,
+	newInfo variables.ProcessInfoFactory) (_ *variables.ScanContextImpl, err error) {
+	if proc.Info == nil {
+		if proc.Info, err = newInfo(proc.Pid); err != nil {
+			return nil, err
+		}
+	}
+
+	return variables.NewProcessScanContext(ctx, proc.Pid, proc.Info), nil
+}
+
+// ProcResult holds the results of ScanProcsParallel.
+type ProcResult struct {
+	// Matches holds the matched rules of the processes having at least one match.
+	Matches map[int]yara.MatchRules
+	// Errors holds the errors of the processes which could not be scanned, e.g. the exited ones.
+	Errors map[int]error
+}
+
+// ScanProcsParallel scans the processes having the given ids using the given number of workers. Each worker uses its
+// own scanner and variables, and creates the ProcessInfo of each process using the factory set by
+// SetProcessInfoFactory. The scanner created by CreateScanner is not used and the rules must be compiled for process
+// scan. The matches are collected in the result instead of calling the callback set by SetCallback, the tag filter and
+// the module data are applied as in the other scans. The variable value errors are ignored and the variables get their
+// default values. The scan does not block the other scans and the setters of the instance, but Destroy and the methods
+// replacing the rules wait for it.
+//
+// The errors of the individual processes do not stop the scan, they are recorded in the result. If the context is
+// done, the scan stops and the result so far is returned along with the context's error.
+func (c *Compiled) ScanProcsParallel(ctx context.Context, pids []int, workers int) (*ProcResult, error) {
+	// Destroy waits for the scan since the worker scanners use the rules.
+	c.rulesMu.RLock()
+	defer c.rulesMu.RUnlock()
+	if workers < 1 {
+		workers = 1
+	}
+
+	sw, err := c.beginSweep(workers, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer sw.end()
+
+	result := &ProcResult{
+		Matches: make(map[int]yara.MatchRules),
+		Errors:  make(map[int]error),
+	}
+	var mu sync.Mutex
+	record := func(pid int, matches yara.MatchRules, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil:
+			result.Errors[pid] = err
+		case len(matches) > 0:
+			result.Matches[pid] = matches
+		}
+	}
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for _, s := range sw.scanners {
+		wg.Add(1)
+		go func(s *yara.Scanner) {
+			defer wg.Done()
+			vars := sw.vars.Copy()
+			for pid := range queue {
+				matches, err := scanProcWith(ctx, sw, s, vars, pid)
+				record(pid, matches, err)
+			}
+		}(s)
+	}
+
+loop:
+	for _, pid := range pids {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		select {
+		case queue <- pid:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return result, err
+}
+
+func scanProcWith(ctx context.Context, sw *sweep, s *yara.Scanner, vars *variables.Variables,
+	pid int) (yara.MatchRules, error) {
+	info, err := sw.newInfo(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	sctx := variables.NewProcessScanContext(ctx, pid, info)
+	if err = vars.DefineScannerVariables(sctx, s); err != nil {
+		return nil, err
+	}
+
+	var matches yara.MatchRules
+	if err = s.SetCallback(sw.wrapCallback(&matches)).ScanProc(pid); err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// gopsutilProcesses lists the processes using gopsutil. The processes exited while they are listed are skipped.
+func gopsutilProcesses(ctx context.Context) ([]Process, error) {
+	procs, err := process.ProcessesWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Process, 0, len(procs))
+	for _, p := range procs {
+		name, err := p.NameWithContext(ctx)
+		if err != nil {
+			continue
+		}
+		list = append(list, Process{Pid: int(p.Pid), Name: name, Info: p})
+	}
+	return list, nil
+}
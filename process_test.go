@@ -0,0 +1,234 @@
+package gora_test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"github.com/hillu/go-yara/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/binalyze/gora"
+	"github.com/binalyze/gora/variables"
+)
+
+func TestScanProcByName(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sleep command is not available")
+	}
+
+	var pids []int
+	for i := 0; i < 2; i++ {
+		cmd := exec.Command("sleep", "60")
+		require.NoError(t, cmd.Start())
+		t.Cleanup(func() {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		})
+		pids = append(pids, cmd.Process.Pid)
+	}
+
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileString(gora.ScanProcess, `rule r { condition: process_id > 0 }`, ""))
+	require.NoError(t, comp.CreateScanner())
+
+	comp.SetProcessEnumerator(gora.ProcessEnumeratorFunc(func(context.Context) ([]gora.Process, error) {
+		return []gora.Process{
+			{Pid: pids[1], Name: "gora-test"},
+			{Pid: pids[0], Name: "gora-test"},
+			{Pid: 1, Name: "init"},
+		}, nil
+	}))
+
+	var matches yara.MatchRules
+	comp.SetCallback(&matches)
+	scanned, err := comp.ScanProcByName(context.Background(), "gora-test")
+	require.NoError(t, err)
+	if pids[0] > pids[1] {
+		pids[0], pids[1] = pids[1], pids[0]
+	}
+	require.Equal(t, pids, scanned)
+	require.Len(t, matches, 2)
+	require.Equal(t, int64(pids[1]), comp.VariableValues()["process_id"])
+
+	scanned, err = comp.ScanProcByName(context.Background(), "unknown")
+	require.NoError(t, err)
+	require.Empty(t, scanned)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	scanned, err = comp.ScanProcByName(ctx, "gora-test")
+	require.ErrorIs(t, err, context.Canceled)
+	require.Empty(t, scanned)
+}
+
+func TestScanProcByName_processInfoFactory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sleep command is not available")
+	}
+
+	cmd := exec.Command("sleep", "60")
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	rule := `rule r { condition: process_name == "fake" and process_path == "/opt/fake/bin/fake" }`
+	require.NoError(t, comp.CompileString(gora.ScanProcess, rule, ""))
+	require.NoError(t, comp.CreateScanner())
+
+	pid := cmd.Process.Pid
+	comp.SetProcessEnumerator(gora.ProcessEnumeratorFunc(func(context.Context) ([]gora.Process, error) {
+		return []gora.Process{{Pid: pid, Name: "gora-test"}}, nil
+	}))
+	var created []int
+	comp.SetProcessInfoFactory(func(pid int) (variables.ProcessInfo, error) {
+		created = append(created, pid)
+		return &fakeProcessInfo{name: "fake", exe: "/opt/fake/bin/fake"}, nil
+	})
+
+	var matches yara.MatchRules
+	comp.SetCallback(&matches)
+	scanned, err := comp.ScanProcByName(context.Background(), "gora-test")
+	require.NoError(t, err)
+	require.Equal(t, []int{pid}, scanned)
+	require.Equal(t, []int{pid}, created)
+	require.Len(t, matches, 1)
+	require.Equal(t, "fake", comp.VariableValues()["process_name"])
+	require.Equal(t, "/opt/fake/bin/fake", comp.VariableValues()["process_path"])
+
+	// The process path is defined by the other process scans as well.
+	var all []int
+	require.NoError(t, comp.ScanAllProcesses(context.Background(), nil, func(pid int, _ yara.MatchRules) {
+		all = append(all, pid)
+	}))
+	require.Equal(t, []int{pid}, all)
+	result, err := comp.ScanProcsParallel(context.Background(), []int{pid}, 1)
+	require.NoError(t, err)
+	require.Len(t, result.Matches[pid], 1)
+}
+
+func TestScanProcByName_enumeratorError(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileString(gora.ScanProcess, `rule r { condition: true }`, ""))
+	require.NoError(t, comp.CreateScanner())
+
+	errTest := errors.New("test error")
+	comp.SetProcessEnumerator(gora.ProcessEnumeratorFunc(func(context.Context) ([]gora.Process, error) {
+		return nil, errTest
+	}))
+	_, err := comp.ScanProcByName(context.Background(), "gora-test")
+	require.ErrorIs(t, err, errTest)
+}
+
+func TestScanAllProcesses(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sleep command is not available")
+	}
+
+	var pids []int
+	for i := 0; i < 3; i++ {
+		cmd := exec.Command("sleep", "60")
+		require.NoError(t, cmd.Start())
+		t.Cleanup(func() {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		})
+		pids = append(pids, cmd.Process.Pid)
+	}
+	exited := exec.Command("sleep", "0")
+	require.NoError(t, exited.Run())
+
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.ErrorIs(t, comp.ScanAllProcesses(context.Background(), nil, nil), gora.ErrNotCompiled)
+	// The scanner created by CreateScanner is not required.
+	require.NoError(t, comp.CompileString(gora.ScanProcess, `rule r { condition: process_id > 0 }`, ""))
+
+	comp.SetProcessEnumerator(gora.ProcessEnumeratorFunc(func(context.Context) ([]gora.Process, error) {
+		return []gora.Process{
+			{Pid: pids[2], Name: "sleep"},
+			{Pid: exited.Process.Pid, Name: "sleep"},
+			{Pid: pids[0], Name: "sleep"},
+			{Pid: pids[1], Name: "sleep"},
+		}, nil
+	}))
+	filter := func(pid int) bool { return pid != pids[1] }
+
+	var callbackMatches yara.MatchRules
+	comp.SetCallback(&callbackMatches)
+	matched := make(map[int]int)
+	err := comp.ScanAllProcesses(context.Background(), filter, func(pid int, m yara.MatchRules) {
+		matched[pid] = len(m)
+	})
+	var errs gora.ProcScanErrors
+	require.ErrorAs(t, err, &errs)
+	require.Len(t, errs, 1)
+	require.Error(t, errs[exited.Process.Pid])
+	require.Equal(t, map[int]int{pids[0]: 1, pids[2]: 1}, matched)
+	require.Empty(t, callbackMatches)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, comp.ScanAllProcesses(ctx, nil, nil), context.Canceled)
+
+	errTest := errors.New("test error")
+	comp.SetProcessEnumerator(gora.ProcessEnumeratorFunc(func(context.Context) ([]gora.Process, error) {
+		return nil, errTest
+	}))
+	require.ErrorIs(t, comp.ScanAllProcesses(context.Background(), nil, nil), errTest)
+}
+
+func TestScanProcsParallel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sleep command is not available")
+	}
+
+	var pids []int
+	for i := 0; i < 3; i++ {
+		cmd := exec.Command("sleep", "60")
+		require.NoError(t, cmd.Start())
+		t.Cleanup(func() {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		})
+		pids = append(pids, cmd.Process.Pid)
+	}
+
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileString(gora.ScanProcess, `rule r { condition: process_name == "match" }`, ""))
+
+	errTest := errors.New("test error")
+	comp.SetProcessInfoFactory(func(pid int) (variables.ProcessInfo, error) {
+		switch pid {
+		case pids[0]:
+			return &fakeProcessInfo{name: "match"}, nil
+		case pids[1]:
+			return &fakeProcessInfo{name: "other"}, nil
+		}
+		return nil, errTest
+	})
+
+	result, err := comp.ScanProcsParallel(context.Background(), pids, 2)
+	require.NoError(t, err)
+	require.Len(t, result.Matches, 1)
+	require.Len(t, result.Matches[pids[0]], 1)
+	require.Len(t, result.Errors, 1)
+	require.ErrorIs(t, result.Errors[pids[2]], errTest)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = comp.ScanProcsParallel(ctx, pids, 1)
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, err = gora.NewCompiled().ScanProcsParallel(context.Background(), pids, 1)
+	require.ErrorIs(t, err, gora.ErrNotCompiled)
+}
@@ -0,0 +1,120 @@
+package gora
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hillu/go-yara/v4"
+)
+
+const (
+	// DefaultReaderBlockSize is the block size of ScanReader unless it is changed by SetReaderBlockSize.
+	DefaultReaderBlockSize = 1 << 20
+	// MinReaderBlockSize is the minimum block size accepted by SetReaderBlockSize.
+	MinReaderBlockSize = 4 << 10
+)
+
+// SetReaderBlockSize sets the size of the blocks ScanReader passes to YARA. Each block overlaps the previous one by a
+// quarter of the block size, so a string match is never missed if it is not longer than n/4 bytes. The longer matches
+// crossing the block boundaries may be missed, so the block size must be at least four times the longest string the
+// rules can match. Larger blocks reduce the rescanned overlap at the cost of memory. It returns an error if n is less
+// than MinReaderBlockSize.
+func (c *Compiled) SetReaderBlockSize(n int) error {
+	if n < MinReaderBlockSize {
+		return fmt.Errorf("reader block size %d is less than %d", n, MinReaderBlockSize)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blockSz = n
+	return nil
+}
+
+// ScanReader scans the content read from the given reader without holding it in memory entirely. The content is
+// passed to YARA as overlapping blocks, see SetReaderBlockSize for the size of the matches guaranteed to be found.
+// Since the content is streamed, the conditions reading the data at an offset, e.g. uint32(0), are undefined and
+// filesize is not available. Use variables.BufferScanContext or DefineScannerVariables to define the variables before
+// the scan.
+//
+// If reading fails, the scan stops and the read error is returned. It returns ErrMaxScanBytes if the content exceeds
+// the limit set by SetMaxScanBytes.
+func (c *Compiled) ScanReader(r io.Reader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scanner == nil {
+		return ErrScannerNotCreated
+	}
+	size := c.blockSz
+	if size == 0 {
+		size = DefaultReaderBlockSize
+	}
+
+	blocks := &readerBlocks{r: r, buf: make([]byte, size), overlap: size / 4, maxBytes: c.maxBytes}
+	err := c.scanner.ScanMemBlocks(blocks)
+	if blocks.err != nil {
+		return blocks.err
+	}
+	return err
+}
+
+// readerBlocks implements the yara.MemoryBlockIterator interface to scan a reader. The blocks are read when they are
+// iterated and the iteration is not restarted, so YARA can not read the data after the scan.
+type readerBlocks struct {
+	r        io.Reader
+	buf      []byte
+	overlap  int
+	maxBytes int64
+
+	n       int    // number of the valid bytes in buf.
+	base    uint64 // offset of buf in the content.
+	read    int64  // total number of bytes read.
+	started bool
+	eof     bool
+	err     error
+}
+
+func (b *readerBlocks) First() *yara.MemoryBlock {
+	if b.started {
+		return nil
+	}
+	b.started = true
+	return b.fill(0)
+}
+
+func (b *readerBlocks) Next() *yara.MemoryBlock {
+	if b.eof || b.err != nil {
+		return nil
+	}
+	// Keep the tail of the previous block so that the matches crossing the boundary are found in the next one.
+	keep := copy(b.buf, b.buf[b.n-b.overlap:b.n])
+	b.base += uint64(b.n - keep)
+	return b.fill(keep)
+}
+
+// fill reads the rest of the buffer after the given number of kept bytes and returns the block.
+func (b *readerBlocks) fill(keep int) *yara.MemoryBlock {
+	m, err := io.ReadFull(b.r, b.buf[keep:])
+	b.read += int64(m)
+	switch {
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		b.eof = true
+	case err != nil:
+		b.err = err
+		return nil
+	}
+	if b.maxBytes > 0 && b.read > b.maxBytes {
+		b.err = fmt.Errorf("%w: more than %d bytes read", ErrMaxScanBytes, b.maxBytes)
+		return nil
+	}
+	if m == 0 {
+		return nil
+	}
+
+	b.n = keep + m
+	data := b.buf[:b.n]
+	return &yara.MemoryBlock{
+		Base:      b.base,
+		Size:      uint64(b.n),
+		FetchData: func(p []byte) { copy(p, data) },
+	}
+}
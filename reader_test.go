@@ -0,0 +1,70 @@
+package gora_test
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+	"testing/iotest"
+
+	"github.com/hillu/go-yara/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/binalyze/gora"
+)
+
+func TestScanReader(t *testing.T) {
+	comp := gora.NewCompiled()
+	defer comp.Destroy()
+	require.ErrorIs(t, comp.ScanReader(bytes.NewReader(nil)), gora.ErrScannerNotCreated)
+	require.NoError(t, comp.CompileString(gora.ScanFile, `rule r { strings: $a = "BOUNDARY" condition: $a }`, ""))
+	require.NoError(t, comp.CreateScanner())
+
+	require.Error(t, comp.SetReaderBlockSize(gora.MinReaderBlockSize-1))
+	const blockSize = gora.MinReaderBlockSize
+	require.NoError(t, comp.SetReaderBlockSize(blockSize))
+
+	// The pattern is placed across the first, the second and the last block boundaries.
+	for _, offset := range []int{0, blockSize - 4, 2*blockSize - blockSize/4 - 4, 3*blockSize - 8} {
+		t.Run(strconv.Itoa(offset), func(t *testing.T) {
+			content := make([]byte, 3*blockSize)
+			copy(content[offset:], "BOUNDARY")
+
+			var matches yara.MatchRules
+			require.NoError(t, comp.SetCallback(&matches).ScanReader(bytes.NewReader(content)))
+			require.Len(t, matches, 1)
+		})
+	}
+
+	var matches yara.MatchRules
+	require.NoError(t, comp.SetCallback(&matches).ScanReader(bytes.NewReader(make([]byte, 3*blockSize))))
+	require.Empty(t, matches)
+	require.NoError(t, comp.ScanReader(bytes.NewReader(nil)))
+
+	errTest := errors.New("test error")
+	require.ErrorIs(t, comp.ScanReader(iotest.ErrReader(errTest)), errTest)
+
+	comp.SetMaxScanBytes(blockSize)
+	require.NoError(t, comp.ScanReader(bytes.NewReader(make([]byte, blockSize))))
+	require.ErrorIs(t, comp.ScanReader(bytes.NewReader(make([]byte, blockSize+1))), gora.ErrMaxScanBytes)
+}
+
+func BenchmarkScanReader(b *testing.B) {
+	comp := gora.NewCompiled()
+	defer comp.Destroy()
+	require.NoError(b, comp.CompileString(gora.ScanFile, `rule r { strings: $a = "BOUNDARY" condition: $a }`, ""))
+	require.NoError(b, comp.CreateScanner())
+
+	content := make([]byte, 16<<20)
+	for _, size := range []int{gora.MinReaderBlockSize, 64 << 10, gora.DefaultReaderBlockSize, 4 << 20} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			require.NoError(b, comp.SetReaderBlockSize(size))
+			b.SetBytes(int64(len(content)))
+			for i := 0; i < b.N; i++ {
+				if err := comp.ScanReader(bytes.NewReader(content)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
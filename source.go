@@ -0,0 +1,43 @@
+package gora
+
+// RuleSource is an interface that wraps the Rules method which provides the YARA rules to be compiled by CompileFrom,
+// e.g. from embedded files, a remote server or a database.
+type RuleSource interface {
+	Rules() ([]RuleNamespace, error)
+}
+
+// RuleSourceFunc is an helper type to implement RuleSource interface using a function.
+type RuleSourceFunc func() ([]RuleNamespace, error)
+
+// Rules implements RuleSource interface.
+func (fn RuleSourceFunc) Rules() ([]RuleNamespace, error) {
+	return fn()
+}
+
+// RuleNamespaces implements RuleSource interface for the rules which are already in memory.
+type RuleNamespaces []RuleNamespace
+
+// Rules implements RuleSource interface.
+func (r RuleNamespaces) Rules() ([]RuleNamespace, error) {
+	return r, nil
+}
+
+// DirRuleSource returns a RuleSource which reads the YARA rule files in the given directory using LoadRuleNamespaces.
+func DirRuleSource(dir string, filenameNS bool) RuleSource {
+	return RuleSourceFunc(func() ([]RuleNamespace, error) {
+		return LoadRuleNamespaces(dir, filenameNS)
+	})
+}
+
+// CompileFrom compiles the YARA rules provided by the given source as CompileStrings does. Since the rules are compiled
+// as strings, the relative includes are resolved relative to the working directory.
+func (c *Compiled) CompileFrom(target ScanTarget, src RuleSource) error {
+	if c.rules != nil {
+		return ErrAlreadyCompiled
+	}
+	ruleNs, err := src.Rules()
+	if err != nil {
+		return err
+	}
+	return c.CompileStrings(target, ruleNs)
+}
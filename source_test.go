@@ -0,0 +1,67 @@
+package gora_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/binalyze/gora"
+	"github.com/binalyze/gora/variables"
+)
+
+// memorySource is a custom rule store keeping the rules by their namespaces.
+type memorySource map[string]string
+
+func (m memorySource) Rules() ([]gora.RuleNamespace, error) {
+	ruleNs := make([]gora.RuleNamespace, 0, len(m))
+	for ns, rule := range m {
+		ruleNs = append(ruleNs, gora.RuleNamespace{Rule: rule, Namespace: ns})
+	}
+	return ruleNs, nil
+}
+
+func TestCompileFrom(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileFrom(gora.ScanFile, memorySource{
+		"ns1": `rule r1 { condition: file_name == "a" }`,
+		"ns2": `rule r2 { condition: true }`,
+	}))
+	require.ElementsMatch(t, []string{"ns1", "ns2"}, ruleNamespaces(comp))
+	require.Equal(t, []variables.VariableType{variables.VarFileName}, comp.Variables().Variables())
+
+	require.ErrorIs(t, comp.CompileFrom(gora.ScanFile, gora.RuleNamespaces{}), gora.ErrAlreadyCompiled)
+
+	// The rules from a custom source can be appended.
+	require.NoError(t, comp.AppendStrings([]gora.RuleNamespace{{Rule: `rule r3 { condition: true }`, Namespace: "ns3"}}))
+	require.ElementsMatch(t, []string{"ns1", "ns2", "ns3"}, ruleNamespaces(comp))
+}
+
+func TestCompileFrom_builtin(t *testing.T) {
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileFrom(gora.ScanFile, gora.RuleNamespaces{{Rule: `rule r { condition: true }`}}))
+	require.Equal(t, []string{"default"}, ruleNamespaces(comp))
+
+	dir := t.TempDir()
+	writeRuleFile(t, filepath.Join(dir, "b.yar"), `rule r2 { condition: true }`)
+	writeRuleFile(t, filepath.Join(dir, "a.yar"), `rule r1 { condition: true }`)
+	comp = gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileFrom(gora.ScanFile, gora.DirRuleSource(dir, true)))
+	require.Equal(t, []string{"a.yar", "b.yar"}, ruleNamespaces(comp))
+
+	require.Error(t, gora.NewCompiled().CompileFrom(gora.ScanFile, gora.DirRuleSource(t.TempDir(), true)))
+}
+
+func TestCompileFrom_error(t *testing.T) {
+	errTest := errors.New("test error")
+	comp := gora.NewCompiled()
+	err := comp.CompileFrom(gora.ScanFile, gora.RuleSourceFunc(func() ([]gora.RuleNamespace, error) {
+		return nil, errTest
+	}))
+	require.ErrorIs(t, err, errTest)
+	require.Nil(t, comp.Rules())
+}
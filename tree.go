@@ -0,0 +1,234 @@
+package gora
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hillu/go-yara/v4"
+
+	"github.com/binalyze/gora/variables"
+)
+
+// errScanTimeout is the ERROR_SCAN_TIMEOUT error of libyara.
+const errScanTimeout yara.Error = 26
+
+// ScanTreeOptions holds the options of ScanTree and ScanTreeParallel.
+type ScanTreeOptions struct {
+	// PerFileTimeout limits the duration of each file scan, a file exceeding it is reported in TreeResult.TimedOut and
+	// the walk continues. The timeout is applied by YARA, which measures it in whole seconds, so it is rounded up to a
+	// second, e.g. 500ms allows a file to be scanned for a second. It does not limit the calculation of the scanner
+	// variables. Zero means no limit.
+	PerFileTimeout time.Duration
+}
+
+// TreeResult holds the results of ScanTree and ScanTreeParallel.
+type TreeResult struct {
+	// Matches holds the matched rules of the files having at least one match.
+	Matches map[string]yara.MatchRules
+	// TimedOut holds the sorted paths of the files whose scans exceeded ScanTreeOptions.PerFileTimeout.
+	TimedOut []string
+	// Errors holds the errors of the files which could not be walked or scanned.
+	Errors map[string]error
+	// Root is the walked directory, Started and Duration are the start time and the duration of the walk.
+	Root     string
+	Started  time.Time
+	Duration time.Duration
+}
+
+// ScanReport is the JSON serializable report of a tree scan created by TreeResult.Report.
+type ScanReport struct {
+	Root     string        `json:"root"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	// Files holds the files having matches, timeouts or errors sorted by their paths.
+	Files []FileReport `json:"files"`
+}
+
+// FileReport is the report of a file in ScanReport.
+type FileReport struct {
+	Path     string      `json:"path"`
+	Matches  []RuleMatch `json:"matches,omitempty"`
+	TimedOut bool        `json:"timed_out,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// RuleMatch is a matched rule in FileReport.
+type RuleMatch struct {
+	Namespace string   `json:"namespace"`
+	Rule      string   `json:"rule"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// Report creates the serializable report of the result.
+func (r *TreeResult) Report() *ScanReport {
+	files := make(map[string]*FileReport, len(r.Matches)+len(r.TimedOut)+len(r.Errors))
+	file := func(path string) *FileReport {
+		f, ok := files[path]
+		if !ok {
+			f = &FileReport{Path: path}
+			files[path] = f
+		}
+		return f
+	}
+	for path, matches := range r.Matches {
+		f := file(path)
+		for _, m := range matches {
+			f.Matches = append(f.Matches, RuleMatch{Namespace: m.Namespace, Rule: m.Rule, Tags: m.Tags})
+		}
+	}
+	for _, path := range r.TimedOut {
+		file(path).TimedOut = true
+	}
+	for path, err := range r.Errors {
+		file(path).Error = err.Error()
+	}
+
+	report := &ScanReport{
+		Root:     r.Root,
+		Started:  r.Started,
+		Duration: r.Duration,
+		Files:    make([]FileReport, 0, len(files)),
+	}
+	for _, f := range files {
+		report.Files = append(report.Files, *f)
+	}
+	sort.Slice(report.Files, func(i, j int) bool { return report.Files[i].Path < report.Files[j].Path })
+	return report
+}
+
+// ScanTree walks the given directory tree and scans the regular files. See ScanTreeParallel.
+func (c *Compiled) ScanTree(ctx context.Context, root string, opts ScanTreeOptions) (*TreeResult, error) {
+	return c.ScanTreeParallel(ctx, root, 1, opts)
+}
+
+// ScanTreeParallel walks the given directory tree and scans the regular files using the given number of workers. Each
+// worker uses its own scanner and variables, so the scanner created by CreateScanner is not used and the rules must be
+// compiled for file scan. The matches are collected in the result instead of calling the callback set by SetCallback,
+// the tag filter and the module data are applied as in the other scans. The walk does not block the other scans and
+// the setters of the instance, but Destroy and the methods replacing the rules wait for it.
+//
+// The errors of the individual files do not stop the walk, they are recorded in the result. If the context is done, the
+// walk stops and the result so far is returned along with the context's error. The scans in progress are completed,
+// the files whose variables could not be defined due to the context are not recorded.
+func (c *Compiled) ScanTreeParallel(ctx context.Context, root string, workers int,
+	opts ScanTreeOptions) (*TreeResult, error) {
+	// Destroy waits for the walk since the worker scanners use the rules.
+	c.rulesMu.RLock()
+	defer c.rulesMu.RUnlock()
+	if workers < 1 {
+		workers = 1
+	}
+
+	timeout := opts.PerFileTimeout
+	if rem := timeout % time.Second; rem > 0 {
+		timeout += time.Second - rem
+	}
+
+	sw, err := c.beginSweep(workers, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer sw.end()
+
+	result := &TreeResult{
+		Matches: make(map[string]yara.MatchRules),
+		Errors:  make(map[string]error),
+		Root:    root,
+		Started: time.Now(),
+	}
+	var mu sync.Mutex
+	record := func(path string, matches yara.MatchRules, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()):
+			// The file is not scanned since the walk is stopped.
+		case errors.Is(err, errScanTimeout):
+			result.TimedOut = append(result.TimedOut, path)
+		case err != nil:
+			result.Errors[path] = err
+		case len(matches) > 0:
+			result.Matches[path] = matches
+		}
+	}
+
+	files := make(chan treeFile)
+	var wg sync.WaitGroup
+	for _, s := range sw.scanners {
+		wg.Add(1)
+		go func(s *yara.Scanner) {
+			defer wg.Done()
+			vars := sw.vars.Copy()
+			for file := range files {
+				matches, err := c.scanTreeFile(ctx, sw, s, vars, file)
+				record(file.path, matches, err)
+			}
+		}(s)
+	}
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			record(path, nil, err)
+			if d != nil && d.IsDir() && path != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		select {
+		case files <- treeFile{path: path, entry: d}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(files)
+	wg.Wait()
+
+	sort.Strings(result.TimedOut)
+	result.Duration = time.Since(result.Started)
+	return result, err
+}
+
+// treeFile is a regular file found by the tree walk.
+type treeFile struct {
+	path  string
+	entry fs.DirEntry
+}
+
+// scanTreeFile defines the scanner variables for the file using the given context and scans it. The scan is limited
+// by the timeout of the scanner, the context cannot stop it once started.
+func (c *Compiled) scanTreeFile(ctx context.Context, sw *sweep, s *yara.Scanner, vars *variables.Variables,
+	file treeFile) (yara.MatchRules, error) {
+	info, err := file.entry.Info()
+	if err != nil {
+		return nil, err
+	}
+	if err = c.checkScanBytes(info.Size()); err != nil {
+		return nil, err
+	}
+
+	var sctx variables.ScanContextImpl
+	sctx.SetContext(ctx)
+	sctx.SetFilePath(file.path)
+	sctx.SetFileInfo(info)
+	if err = vars.DefineScannerVariables(&sctx, s); err != nil {
+		return nil, err
+	}
+
+	var matches yara.MatchRules
+	if err = s.SetCallback(sw.wrapCallback(&matches)).ScanFile(extendedPath(file.path)); err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
@@ -0,0 +1,149 @@
+package gora_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hillu/go-yara/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/binalyze/gora"
+)
+
+// rulestrSlow takes quadratic time in the file size.
+const rulestrSlow = `
+rule fast { strings: $a = "test" condition: $a }
+rule slow { condition: for all i in (0..filesize) : ( for all j in (0..filesize) : ( uint8(i) != 0xff or uint8(j) != 0xff ) ) }
+`
+
+func TestScanTree_perFileTimeout(t *testing.T) {
+	dir := t.TempDir()
+	fast := writeRuleFile(t, filepath.Join(dir, "fast.txt"), "test")
+	slow := writeRuleFile(t, filepath.Join(dir, "sub", "slow.bin"), string(make([]byte, 1<<16)))
+
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileString(gora.ScanFile, rulestrSlow, ""))
+
+	for _, workers := range []int{1, 2} {
+		start := time.Now()
+		res, err := comp.ScanTreeParallel(context.Background(), dir, workers, gora.ScanTreeOptions{
+			PerFileTimeout: 500 * time.Millisecond,
+		})
+		require.NoError(t, err)
+		require.Less(t, time.Since(start), 10*time.Second)
+
+		require.Equal(t, []string{slow}, res.TimedOut)
+		require.Empty(t, res.Errors)
+		require.Len(t, res.Matches, 1)
+		require.Equal(t, []string{"fast", "slow"}, matchedRuleNames(res.Matches[fast]))
+	}
+}
+
+func TestScanTree_notBlocking(t *testing.T) {
+	dir := t.TempDir()
+	slow := writeRuleFile(t, filepath.Join(dir, "slow.bin"), string(make([]byte, 1<<16)))
+
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileString(gora.ScanFile, rulestrSlow, ""))
+	require.NoError(t, comp.CreateScanner())
+
+	done := make(chan *gora.TreeResult)
+	go func() {
+		res, _ := comp.ScanTree(context.Background(), dir, gora.ScanTreeOptions{PerFileTimeout: 2 * time.Second})
+		done <- res
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// The scans of the instance are not blocked by the walk.
+	fast := writeRuleFile(t, filepath.Join(t.TempDir(), "fast.txt"), "test")
+	var matches yara.MatchRules
+	comp.SetCallback(&matches).SetTagFilter()
+	require.NoError(t, comp.ScanFile(fast))
+	require.Equal(t, []string{"fast", "slow"}, matchedRuleNames(matches))
+	select {
+	case <-done:
+		t.Fatal("walk finished before the file scan")
+	default:
+	}
+
+	res := <-done
+	require.Equal(t, []string{slow}, res.TimedOut)
+}
+
+func TestScanTree(t *testing.T) {
+	dir := t.TempDir()
+	match := writeRuleFile(t, filepath.Join(dir, "a", "match.txt"), "test")
+	writeRuleFile(t, filepath.Join(dir, "b", "nomatch.txt"), "none")
+
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+
+	_, err := comp.ScanTree(context.Background(), dir, gora.ScanTreeOptions{})
+	require.ErrorIs(t, err, gora.ErrNotCompiled)
+
+	rule := `rule r { strings: $a = "test" condition: $a and file_name == "match.txt" }`
+	require.NoError(t, comp.CompileString(gora.ScanFile, rule, ""))
+
+	res, err := comp.ScanTree(context.Background(), dir, gora.ScanTreeOptions{})
+	require.NoError(t, err)
+	require.Empty(t, res.TimedOut)
+	require.Empty(t, res.Errors)
+	require.Len(t, res.Matches, 1)
+	require.Equal(t, []string{"r"}, matchedRuleNames(res.Matches[match]))
+
+	res, err = comp.ScanTree(context.Background(), filepath.Join(dir, "missing"), gora.ScanTreeOptions{})
+	require.NoError(t, err)
+	require.Len(t, res.Errors, 1)
+	require.True(t, os.IsNotExist(res.Errors[filepath.Join(dir, "missing")]))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = comp.ScanTree(ctx, dir, gora.ScanTreeOptions{})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestTreeResult_Report(t *testing.T) {
+	dir := t.TempDir()
+	match := writeRuleFile(t, filepath.Join(dir, "a", "match.txt"), "test")
+	slow := writeRuleFile(t, filepath.Join(dir, "b", "slow.bin"), string(make([]byte, 1<<16)))
+	writeRuleFile(t, filepath.Join(dir, "c", "nomatch.txt"), "none")
+
+	comp := gora.NewCompiled()
+	t.Cleanup(comp.Destroy)
+	require.NoError(t, comp.CompileStrings(gora.ScanFile, []gora.RuleNamespace{
+		{Rule: `rule r : t1 t2 { strings: $a = "test" condition: $a }`, Namespace: "ns"},
+		{Rule: rulestrSlow},
+	}))
+
+	res, err := comp.ScanTree(context.Background(), dir, gora.ScanTreeOptions{PerFileTimeout: 500 * time.Millisecond})
+	require.NoError(t, err)
+	res.Errors[filepath.Join(dir, "d")] = os.ErrPermission
+
+	report := res.Report()
+	require.Equal(t, dir, report.Root)
+	require.False(t, report.Started.IsZero())
+	require.Positive(t, report.Duration)
+	require.Equal(t, []gora.FileReport{
+		{Path: match, Matches: []gora.RuleMatch{
+			{Namespace: "ns", Rule: "r", Tags: []string{"t1", "t2"}},
+			{Namespace: "default", Rule: "fast"},
+			{Namespace: "default", Rule: "slow"},
+		}},
+		{Path: slow, TimedOut: true},
+		{Path: filepath.Join(dir, "d"), Error: os.ErrPermission.Error()},
+	}, report.Files)
+
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	var decoded gora.ScanReport
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.True(t, report.Started.Equal(decoded.Started))
+	decoded.Started = report.Started
+	require.Equal(t, *report, decoded)
+}
@@ -0,0 +1,92 @@
+package variables
+
+import (
+	"context"
+	"sync"
+)
+
+// cachingProcessInfo implements the ProcessInfo interface by memoizing the results of the underlying ProcessInfo.
+type cachingProcessInfo struct {
+	proc  ProcessInfo
+	mu    sync.Mutex
+	cache map[string]cachedResult
+}
+
+type cachedResult struct {
+	value interface{}
+	err   error
+}
+
+var (
+	_ ProcessInfo             = (*cachingProcessInfo)(nil)
+	_ ProcessOpenFilesCounter = (*cachingProcessInfo)(nil)
+	_ ProcessExecutable       = (*cachingProcessInfo)(nil)
+)
+
+// CacheProcessInfo wraps the given ProcessInfo to memoize the result of each method, including the errors, so that
+// repeated calls within DefineScannerVariables and the caller's own code query the process only once. The context of
+// the first call is used for the context aware methods. There is no invalidation, a new wrapper should be created for
+// every scan.
+func CacheProcessInfo(p ProcessInfo) ProcessInfo {
+	if p == nil {
+		return nil
+	}
+	return &cachingProcessInfo{
+		proc:  p,
+		cache: make(map[string]cachedResult),
+	}
+}
+
+func (c *cachingProcessInfo) Ppid() (int32, error) {
+	v, err := c.get("Ppid", func() (interface{}, error) {
+		return c.proc.Ppid()
+	})
+	return v.(int32), err
+}
+
+func (c *cachingProcessInfo) Username() (string, error) {
+	v, err := c.get("Username", func() (interface{}, error) {
+		return c.proc.Username()
+	})
+	return v.(string), err
+}
+
+func (c *cachingProcessInfo) NameWithContext(ctx context.Context) (string, error) {
+	v, err := c.get("NameWithContext", func() (interface{}, error) {
+		return c.proc.NameWithContext(ctx)
+	})
+	return v.(string), err
+}
+
+func (c *cachingProcessInfo) CmdlineWithContext(ctx context.Context) (string, error) {
+	v, err := c.get("CmdlineWithContext", func() (interface{}, error) {
+		return c.proc.CmdlineWithContext(ctx)
+	})
+	return v.(string), err
+}
+
+func (c *cachingProcessInfo) OpenFilesCountWithContext(ctx context.Context) (int, error) {
+	v, err := c.get("OpenFilesCountWithContext", func() (interface{}, error) {
+		return processOpenFilesCount(ctx, c.proc)
+	})
+	return v.(int), err
+}
+
+func (c *cachingProcessInfo) ExeWithContext(ctx context.Context) (string, error) {
+	v, err := c.get("ExeWithContext", func() (interface{}, error) {
+		return processExe(ctx, c.proc)
+	})
+	return v.(string), err
+}
+
+func (c *cachingProcessInfo) get(method string, fn func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if res, ok := c.cache[method]; ok {
+		return res.value, res.err
+	}
+	v, err := fn()
+	c.cache[method] = cachedResult{value: v, err: err}
+	return v, err
+}
@@ -0,0 +1,80 @@
+package variables_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/binalyze/gora/variables"
+)
+
+func TestCacheProcessInfo(t *testing.T) {
+	require.Nil(t, CacheProcessInfo(nil))
+
+	vars := []VariableType{
+		VarProcessName, VarProcessUserName, VarProcessUserSid, VarProcessParentId, VarProcessCommandLine,
+		VarProcessOpenFiles,
+	}
+
+	newMock := func(times, usernameTimes int) *openFilesProcessInfoMock {
+		pi := new(openFilesProcessInfoMock)
+		pi.On("NameWithContext", context.Background()).Return("init", nil).Times(times)
+		pi.On("Username").Return("", errors.New("test error")).Times(usernameTimes)
+		pi.On("Ppid").Return(1, nil).Times(times)
+		pi.On("CmdlineWithContext", context.Background()).Return("/sbin/init", nil).Times(times)
+		pi.On("OpenFilesCountWithContext", context.Background()).Return(3, nil).Times(times)
+		return pi
+	}
+
+	scan := func(pi ProcessInfo) {
+		sCtx := new(scanContextMock)
+		sCtx.On("Context").Return(context.Background())
+		sCtx.On("ProcessInfo").Return(pi)
+
+		// Valuers are called twice to simulate the caller's own queries besides DefineScannerVariables.
+		for i := 0; i < 2; i++ {
+			for _, vid := range vars {
+				_, _ = Valuers[vid].Value(sCtx)
+			}
+		}
+	}
+
+	// Without the cache, process_user_sid queries the user name again, and all the values are queried twice.
+	pi := newMock(2, 4)
+	scan(pi)
+	pi.AssertExpectations(t)
+
+	pi = newMock(1, 1)
+	cached := CacheProcessInfo(pi)
+	scan(cached)
+	pi.AssertExpectations(t)
+
+	name, err := cached.NameWithContext(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "init", name)
+	_, err = cached.Username()
+	require.Error(t, err)
+	pi.AssertExpectations(t)
+}
+
+func TestCacheProcessInfo_openFilesNotSupported(t *testing.T) {
+	pi := new(processInfoMock)
+
+	sCtx := new(scanContextMock)
+	sCtx.On("Context").Return(context.Background())
+	sCtx.On("ProcessInfo").Return(CacheProcessInfo(pi))
+
+	got, err := Valuers[VarProcessOpenFiles].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+
+	sCtx = new(scanContextMock)
+	sCtx.On("Context").Return(context.Background())
+	sCtx.On("ProcessInfo").Return(RetryProcessInfo(pi, RetryPolicy{Attempts: 3}))
+
+	got, err = Valuers[VarProcessOpenFiles].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
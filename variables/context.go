@@ -3,6 +3,8 @@ package variables
 import (
 	"context"
 	"io/fs"
+	"path/filepath"
+	"time"
 )
 
 // ScanContextImpl implements the ScanContext interface. It is a simple implementation to set the required values to be
@@ -75,6 +77,18 @@ func (sc *ScanContextImpl) SetHandleValueError(fn func(VariableDefiner, Variable
 	sc.valErrFn = fn
 }
 
+// IgnoreValueErrors is a value error handler which ignores the errors, so the variables get their default values and the
+// scan continues. It can be set using ScanContextImpl.SetHandleValueError.
+func IgnoreValueErrors(VariableDefiner, VariableType, error) error {
+	return nil
+}
+
+// AbortOnValueError is a value error handler which returns the error, so the variables are not defined further and the
+// scan is aborted. It is the default behavior of ScanContextImpl.
+func AbortOnValueError(_ VariableDefiner, _ VariableType, err error) error {
+	return err
+}
+
 // Pid is to implement the ScanContext interface.
 func (sc *ScanContextImpl) Pid() int {
 	return sc.pid
@@ -94,3 +108,107 @@ func (sc *ScanContextImpl) ProcessInfo() ProcessInfo {
 func (sc *ScanContextImpl) SetProcessInfo(p ProcessInfo) {
 	sc.proc = p
 }
+
+// NewProcessScanContext creates a scan context for the process having the given pid and ProcessInfo. If the ProcessInfo
+// implements ProcessExecutable, the path of the process executable is set as the file path which process_path variable
+// presents, so the file variables describe the executable if they are defined for the process scan as well. The
+// variable value errors are ignored and the variables get their default values, since some process information may not
+// be available, e.g. for the processes of other users.
+func NewProcessScanContext(ctx context.Context, pid int, proc ProcessInfo) *ScanContextImpl {
+	sc := new(ScanContextImpl)
+	sc.SetContext(ctx)
+	sc.SetPid(pid)
+	sc.SetProcessInfo(proc)
+	sc.SetHandleValueError(IgnoreValueErrors)
+	// The path is left empty if it is not available, as the other process variables get their default values.
+	if exe, err := processExe(ctx, proc); err == nil {
+		sc.SetFilePath(exe)
+	}
+	return sc
+}
+
+// BufferScanContext implements the ScanContext interface for in-memory buffer scans. Since there is no file on the
+// disk, the file variables are calculated using the supplied synthetic name, size and timestamps, and the content
+// derived variables are calculated using the buffer.
+type BufferScanContext struct {
+	ScanContextImpl
+	buf   []byte
+	finfo bufferFileInfo
+}
+
+var _ ScanContext = (*BufferScanContext)(nil)
+
+// NewBufferScanContext creates a new BufferScanContext for the given buffer. The name is used as the file path and the
+// size of the buffer is used as the file size.
+func NewBufferScanContext(name string, buf []byte) *BufferScanContext {
+	sc := &BufferScanContext{buf: buf}
+	sc.SetFilePath(name)
+	sc.finfo = bufferFileInfo{
+		name: filepath.Base(name),
+		size: int64(len(buf)),
+		mode: 0644,
+	}
+	return sc
+}
+
+// Buffer returns the underlying buffer which is used to calculate the content derived variables.
+func (sc *BufferScanContext) Buffer() []byte {
+	return sc.buf
+}
+
+// FileInfo is to implement the ScanContext interface. It returns the file info set by SetFileInfo if exists, otherwise
+// it returns the synthetic file info built from the supplied size and timestamps.
+func (sc *BufferScanContext) FileInfo() fs.FileInfo {
+	if info := sc.ScanContextImpl.FileInfo(); info != nil {
+		return info
+	}
+	return &sc.finfo
+}
+
+// SetSize sets the original size of the file.
+func (sc *BufferScanContext) SetSize(size int64) {
+	sc.finfo.size = size
+}
+
+// SetModTime sets the modification time of the file.
+func (sc *BufferScanContext) SetModTime(t time.Time) {
+	sc.finfo.mtime = t
+}
+
+// SetAccessTime sets the access time of the file.
+func (sc *BufferScanContext) SetAccessTime(t time.Time) {
+	sc.finfo.atime = t
+}
+
+// SetChangeTime sets the change time of the file. Zero value means the change time is not available.
+func (sc *BufferScanContext) SetChangeTime(t time.Time) {
+	sc.finfo.ctime = t
+}
+
+// SetBirthTime sets the birth time of the file. Zero value means the birth time is not available.
+func (sc *BufferScanContext) SetBirthTime(t time.Time) {
+	sc.finfo.btime = t
+}
+
+// bufferFileInfo implements both fs.FileInfo and times.Timespec interfaces for BufferScanContext.
+type bufferFileInfo struct {
+	name  string
+	size  int64
+	mode  fs.FileMode
+	mtime time.Time
+	atime time.Time
+	ctime time.Time
+	btime time.Time
+}
+
+func (fi *bufferFileInfo) Name() string          { return fi.name }
+func (fi *bufferFileInfo) Size() int64           { return fi.size }
+func (fi *bufferFileInfo) Mode() fs.FileMode     { return fi.mode }
+func (fi *bufferFileInfo) ModTime() time.Time    { return fi.mtime }
+func (fi *bufferFileInfo) IsDir() bool           { return false }
+func (fi *bufferFileInfo) Sys() interface{}      { return nil }
+func (fi *bufferFileInfo) AccessTime() time.Time { return fi.atime }
+func (fi *bufferFileInfo) ChangeTime() time.Time { return fi.ctime }
+func (fi *bufferFileInfo) BirthTime() time.Time  { return fi.btime }
+func (fi *bufferFileInfo) HasChangeTime() bool   { return !fi.ctime.IsZero() }
+func (fi *bufferFileInfo) HasBirthTime() bool    { return !fi.btime.IsZero() }
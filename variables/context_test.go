@@ -2,9 +2,11 @@ package variables_test
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/shirou/gopsutil/v3/process"
 	"github.com/stretchr/testify/require"
@@ -59,3 +61,71 @@ func testScanContextImplDefaults(t *testing.T, sctx *ScanContextImpl) {
 	require.Zero(t, sctx.Pid())
 	require.Nil(t, sctx.ProcessInfo())
 }
+
+func TestBufferScanContext(t *testing.T) {
+	buf := []byte("\x7fELF buffer content")
+	sctx := NewBufferScanContext("/tmp/dir/invoice.pdf", buf)
+	require.Equal(t, buf, sctx.Buffer())
+	require.Equal(t, "/tmp/dir/invoice.pdf", sctx.FilePath())
+
+	info := sctx.FileInfo()
+	require.NotNil(t, info)
+	require.Equal(t, "invoice.pdf", info.Name())
+	require.Equal(t, int64(len(buf)), info.Size())
+	require.False(t, info.IsDir())
+
+	mtime := time.Date(2022, 5, 20, 10, 11, 12, 0, time.Local)
+	btime := time.Date(2021, 1, 2, 3, 4, 5, 0, time.Local)
+	sctx.SetSize(1024)
+	sctx.SetModTime(mtime)
+	sctx.SetAccessTime(mtime)
+	sctx.SetBirthTime(btime)
+	require.Equal(t, int64(1024), sctx.FileInfo().Size())
+
+	expects := map[VariableType]interface{}{
+		VarFileName:         "invoice.pdf",
+		VarFileExtension:    "pdf",
+		VarFileModifiedTime: int64(20220520101112),
+		VarFileAccessedTime: int64(20220520101112),
+		VarFileBirthTime:    int64(20210102030405),
+		VarFileChangedTime:  nil,
+		VarFileHeaderHex:    hex.EncodeToString(buf[:FileHeaderSize]),
+	}
+	for vid, expect := range expects {
+		got, err := Valuers[vid].Value(sctx)
+		require.NoError(t, err)
+		require.Equal(t, expect, got, vid.String())
+	}
+
+	finfo, err := os.Stat(os.Args[0])
+	require.NoError(t, err)
+	sctx.SetFileInfo(finfo)
+	require.Same(t, finfo, sctx.FileInfo())
+}
+
+func TestNewProcessScanContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	proc, err := process.NewProcess(int32(os.Getpid()))
+	require.NoError(t, err)
+	exe, err := proc.Exe()
+	require.NoError(t, err)
+
+	sctx := NewProcessScanContext(ctx, os.Getpid(), CacheProcessInfo(proc))
+	require.Same(t, ctx, sctx.Context())
+	require.Equal(t, os.Getpid(), sctx.Pid())
+	require.Equal(t, exe, sctx.FilePath())
+	require.NoError(t, sctx.HandleValueError(nil, VarProcessName, errors.New("test error")))
+
+	var vr Variables
+	vr.InitProcessVariables([]VariableType{VarProcessPath})
+	values := make(mapDefiner)
+	require.NoError(t, vr.DefineScannerVariables(sctx, values))
+	require.Equal(t, exe, values[VarProcessPath.String()])
+
+	// The file path is empty if the ProcessInfo does not provide the executable.
+	sctx = NewProcessScanContext(ctx, os.Getpid(), new(processInfoMock))
+	require.Empty(t, sctx.FilePath())
+	sctx = NewProcessScanContext(ctx, os.Getpid(), RetryProcessInfo(new(processInfoMock), RetryPolicy{Attempts: 3}))
+	require.Empty(t, sctx.FilePath())
+}
@@ -0,0 +1,54 @@
+package variables
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrMaxScanBytes is returned by the content derived variables, e.g. file_hash, if they need to read more than the
+// limit set by Variables.SetMaxScanBytes.
+var ErrMaxScanBytes = errors.New("max scan bytes exceeded")
+
+var errNotSeekable = errors.New("content is not seekable")
+
+// limitedScanContext wraps the scan context of a DefineScannerVariables call to limit the number of bytes each content
+// derived variable reads. See openContent.
+type limitedScanContext struct {
+	ScanContext
+	maxBytes int64
+}
+
+// limitedReader reads from the underlying reader until its remaining bytes are exhausted, then it returns
+// ErrMaxScanBytes instead of reading further. The underlying reader is not embedded, so that io.Copy can not bypass
+// Read using its io.WriterTo implementation, e.g. of os.File.
+type limitedReader struct {
+	rc        io.ReadCloser
+	remaining int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	// Read one more byte than remaining to tell the content ending at the limit from the content exceeding it.
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.rc.Read(p)
+	if int64(n) > r.remaining {
+		r.remaining = 0
+		return 0, ErrMaxScanBytes
+	}
+	r.remaining -= int64(n)
+	return n, err
+}
+
+func (r *limitedReader) Close() error {
+	return r.rc.Close()
+}
+
+// Seek seeks the underlying reader if it implements io.Seeker. Seeking does not consume the remaining bytes.
+func (r *limitedReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := r.rc.(io.Seeker)
+	if !ok {
+		return 0, errNotSeekable
+	}
+	return s.Seek(offset, whence)
+}
@@ -1,8 +1,12 @@
 package variables
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/VirusTotal/gyp/ast"
 	"github.com/VirusTotal/gyp/parser"
@@ -10,13 +14,49 @@ import (
 
 const depthLimit = 1024
 
+// includeDepthLimit is the maximum nesting level of the followed includes, which is the same as YARA's
+// YR_MAX_INCLUDE_DEPTH.
+const includeDepthLimit = 16
+
+var (
+	// ErrCircularInclude is returned by the Parser when a followed include includes itself directly or indirectly.
+	ErrCircularInclude = errors.New("circular include")
+	// ErrIncludeDepth is returned by the Parser when the followed includes are nested deeper than YARA allows.
+	ErrIncludeDepth = errors.New("include depth limit exceeded")
+)
+
 // Parser reprents a parser which parses the given yara rule(s) to identify all external variables, includes and imports
 // used in the rule(s).
 type Parser struct {
-	vars     []VariableType
-	includes []string
-	imports  []string
-	varmap   map[string]struct{}
+	vars       []VariableType
+	includes   []string
+	imports    []string
+	varmap     map[string]struct{}
+	resolver   IncludeResolver
+	unresolved []string
+	parsed     map[string]struct{}
+	stack      []string // the files being parsed while following the includes.
+}
+
+// IncludeResolver returns the path of the rule file included by the given include directive. The parent is the path of
+// the including file, or empty if the including rules are not read from a file.
+type IncludeResolver func(include, parent string) (string, error)
+
+// FileIncludeResolver resolves the includes as YARA does by default. Relative includes are resolved relative to the
+// directory of the including file, or to the working directory if the including rules are not read from a file.
+func FileIncludeResolver(include, parent string) (string, error) {
+	if filepath.IsAbs(include) || parent == "" {
+		return include, nil
+	}
+	return filepath.Join(filepath.Dir(parent), include), nil
+}
+
+// SetIncludeResolver sets the resolver used to follow the includes. If it is set, the included files are parsed too, so
+// Variables returns the variables of the included rules as well. The includes which can not be resolved or parsed are
+// reported by Unresolved, while the circular includes fail the parsing with ErrCircularInclude as they fail YARA
+// compiler. By default, the includes are not followed.
+func (p *Parser) SetIncludeResolver(fn IncludeResolver) {
+	p.resolver = fn
 }
 
 // ParseFromFile parses the given file which must be a valid yara rule file to identify external variables, includes and
@@ -29,7 +69,7 @@ func (p *Parser) ParseFromFile(file string) error {
 		return err
 	}
 	defer f.Close()
-	return p.ParseFromReader(f)
+	return p.ParseFromNamedReader(f, file)
 }
 
 // ParseFromReader parses the given io.Reader which must provide a valid yara rule to identify external variables,
@@ -37,6 +77,12 @@ func (p *Parser) ParseFromFile(file string) error {
 // Note that, subsequent calls do not reset underlying list of variables, includes and imports identified. Use this
 // behaviour to parse multiple inputs to aggregate.
 func (p *Parser) ParseFromReader(rd io.Reader) error {
+	return p.ParseFromNamedReader(rd, "")
+}
+
+// ParseFromNamedReader is like ParseFromReader, but the given name is used as the path of the including file while
+// resolving the includes. See SetIncludeResolver.
+func (p *Parser) ParseFromNamedReader(rd io.Reader, name string) error {
 	ast, err := parser.Parse(rd)
 	if err != nil {
 		return err
@@ -46,9 +92,65 @@ func (p *Parser) ParseFromReader(rd io.Reader) error {
 	p.includes = dedupStringSlice(p.includes)
 	p.imports = dedupStringSlice(p.imports)
 	p.visit(ast.Rules)
+	if p.resolver != nil {
+		if name != "" {
+			p.stack = append(p.stack, includeKey(name))
+			defer func() {
+				p.stack = p.stack[:len(p.stack)-1]
+			}()
+		}
+		for _, include := range ast.Includes {
+			if err = p.parseInclude(include, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseInclude parses the included file. Each file is parsed once to aggregate the variables. It returns
+// ErrCircularInclude naming the cycle if the file is being parsed already, and ErrIncludeDepth if the includes are
+// nested too deep. The other errors are not returned but the include is reported by Unresolved.
+func (p *Parser) parseInclude(include, parent string) error {
+	path, err := p.resolver(include, parent)
+	if err != nil {
+		p.unresolved = dedupStringSlice(append(p.unresolved, include))
+		return nil
+	}
+	key := includeKey(path)
+	for i, s := range p.stack {
+		if s == key {
+			cycle := append(p.stack[i:len(p.stack):len(p.stack)], key)
+			return fmt.Errorf("%w: %s", ErrCircularInclude, strings.Join(cycle, " -> "))
+		}
+	}
+	if len(p.stack) >= includeDepthLimit {
+		return fmt.Errorf("%w: %s", ErrIncludeDepth, key)
+	}
+	if p.parsed == nil {
+		p.parsed = make(map[string]struct{})
+	}
+	if _, ok := p.parsed[key]; ok {
+		return nil
+	}
+	p.parsed[key] = struct{}{}
+	if err = p.ParseFromFile(path); err != nil {
+		if errors.Is(err, ErrCircularInclude) || errors.Is(err, ErrIncludeDepth) {
+			return err
+		}
+		p.unresolved = dedupStringSlice(append(p.unresolved, include))
+	}
 	return nil
 }
 
+// includeKey returns the absolute path of the given rule file to identify it regardless of how it is included.
+func includeKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
 // Variables returns the list of variables parsed.
 func (p *Parser) Variables() []VariableType {
 	return p.vars
@@ -59,6 +161,15 @@ func (p *Parser) Includes() []string {
 	return p.includes
 }
 
+// Unresolved returns the includes which could not be resolved or parsed, so their variables are unknown. It returns all
+// the includes if the include resolver is not set.
+func (p *Parser) Unresolved() []string {
+	if p.resolver == nil {
+		return p.includes
+	}
+	return p.unresolved
+}
+
 // Imports returns the list of imported modules parsed.
 func (p *Parser) Imports() []string {
 	return p.imports
@@ -133,3 +244,77 @@ func dedupStringSlice(s []string) []string {
 	}
 	return result
 }
+
+// ParseResult is the result of ParseRule.
+type ParseResult struct {
+	// Variables holds the external variables referenced by the rules in the order of their first reference.
+	Variables []VariableType
+	// Includes holds the include directives as they are written, they are not followed.
+	Includes []string
+	// Unknown holds the identifiers which are neither a variable, a rule nor a module imported in the given rules, e.g.
+	// a misspelled variable or a rule defined in an included file.
+	Unknown []string
+}
+
+// ParseRule parses the given YARA rules to find the referenced external variables, includes and unknown identifiers at
+// once, e.g. for the rule authoring tools. Unlike Parser, it does not follow the includes.
+func ParseRule(rule string) (ParseResult, error) {
+	rs, err := parser.Parse(strings.NewReader(rule))
+	if err != nil {
+		return ParseResult{}, err
+	}
+	p := new(Parser)
+	p.visit(rs.Rules)
+
+	known := make(map[string]int, len(rs.Rules)+len(rs.Imports))
+	for _, r := range rs.Rules {
+		if r != nil {
+			known[r.Identifier]++
+		}
+	}
+	for _, module := range rs.Imports {
+		known[module]++
+	}
+	var unknown []string
+	for _, r := range rs.Rules {
+		if r != nil {
+			unknown = visitUnknown(r.Condition, known, unknown, 1)
+		}
+	}
+	return ParseResult{
+		Variables: p.Variables(),
+		Includes:  dedupStringSlice(append([]string(nil), rs.Includes...)),
+		Unknown:   dedupStringSlice(unknown),
+	}, nil
+}
+
+// visitUnknown appends the identifiers in the given node which are not variables or known to the unknown ones. The
+// variables of the for loops are known within their conditions.
+func visitUnknown(node ast.Node, known map[string]int, unknown []string, depth int) []string {
+	if node == nil || depth > depthLimit {
+		return unknown
+	}
+	switch n := node.(type) {
+	case *ast.Identifier:
+		if n != nil && n.Identifier != "" && known[n.Identifier] == 0 {
+			if _, err := ParseVariableType(n.Identifier); err != nil {
+				unknown = append(unknown, n.Identifier)
+			}
+		}
+	case *ast.ForIn:
+		unknown = visitUnknown(n.Quantifier, known, unknown, depth+1)
+		unknown = visitUnknown(n.Iterator, known, unknown, depth+1)
+		for _, v := range n.Variables {
+			known[v]++
+		}
+		unknown = visitUnknown(n.Condition, known, unknown, depth+1)
+		for _, v := range n.Variables {
+			known[v]--
+		}
+		return unknown
+	}
+	for _, child := range node.Children() {
+		unknown = visitUnknown(child, known, unknown, depth+1)
+	}
+	return unknown
+}
@@ -1,6 +1,7 @@
 package variables_test
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -61,6 +62,102 @@ func TestParseIncludesImportsVariables(t *testing.T) {
 	require.Equal(t, vars[0], variables.VarFilePath)
 }
 
+func TestParseIncludes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, rule string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o777))
+		require.NoError(t, os.WriteFile(path, []byte(rule), 0o666))
+		return path
+	}
+	write("common/names.yar", `rule names { condition: file_name == "a" }`)
+	write("common/os.yar", `include "names.yar" rule os_check { condition: os_linux }`)
+	main := write("main.yar", `
+include "common/os.yar"
+include "common/names.yar"
+rule main { condition: file_path == "" }`)
+
+	p := new(variables.Parser)
+	p.SetIncludeResolver(variables.FileIncludeResolver)
+	require.NoError(t, p.ParseFromFile(main))
+	require.ElementsMatch(t, []variables.VariableType{
+		variables.VarFilePath, variables.VarOsLinux, variables.VarFileName,
+	}, p.Variables())
+	require.Empty(t, p.Unresolved())
+	require.ElementsMatch(t, []string{"common/os.yar", "common/names.yar", "names.yar"}, p.Includes())
+
+	p = new(variables.Parser)
+	p.SetIncludeResolver(variables.FileIncludeResolver)
+	require.NoError(t, p.ParseFromReader(strings.NewReader(`include "missing.yar" rule r { condition: os == "" }`)))
+	require.Equal(t, []variables.VariableType{variables.VarOs}, p.Variables())
+	require.Equal(t, []string{"missing.yar"}, p.Unresolved())
+
+	p = new(variables.Parser)
+	p.SetIncludeResolver(func(include, parent string) (string, error) {
+		return "", os.ErrNotExist
+	})
+	require.NoError(t, p.ParseFromFile(main))
+	require.Equal(t, []string{"common/os.yar", "common/names.yar"}, p.Unresolved())
+
+	p = new(variables.Parser)
+	require.NoError(t, p.ParseFromFile(main))
+	require.Equal(t, []variables.VariableType{variables.VarFilePath}, p.Variables())
+	require.Equal(t, []string{"common/os.yar", "common/names.yar"}, p.Unresolved())
+}
+
+func TestParseIncludes_circular(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, rule string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(rule), 0o666))
+		return path
+	}
+	a := write("a.yar", `include "b.yar" rule a { condition: true }`)
+	b := write("b.yar", `include "a.yar" rule b { condition: true }`)
+	self := write("self.yar", `include "self.yar" rule self { condition: true }`)
+
+	p := new(variables.Parser)
+	p.SetIncludeResolver(variables.FileIncludeResolver)
+	err := p.ParseFromFile(a)
+	require.ErrorIs(t, err, variables.ErrCircularInclude)
+	require.Contains(t, err.Error(), a+" -> "+b+" -> "+a)
+
+	p = new(variables.Parser)
+	p.SetIncludeResolver(variables.FileIncludeResolver)
+	err = p.ParseFromFile(self)
+	require.ErrorIs(t, err, variables.ErrCircularInclude)
+	require.Contains(t, err.Error(), self+" -> "+self)
+
+	// The cycles are not detected when the includes are not followed.
+	p = new(variables.Parser)
+	require.NoError(t, p.ParseFromFile(a))
+	require.Equal(t, []string{"b.yar"}, p.Unresolved())
+}
+
+func TestParseIncludes_depth(t *testing.T) {
+	dir := t.TempDir()
+	const n = 20
+	for i := 0; i < n; i++ {
+		rule := fmt.Sprintf(`include "%d.yar" rule r%d { condition: true }`, i+1, i)
+		if i == n-1 {
+			rule = `rule last { condition: true }`
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.yar", i)), []byte(rule), 0o666))
+	}
+
+	p := new(variables.Parser)
+	p.SetIncludeResolver(variables.FileIncludeResolver)
+	require.ErrorIs(t, p.ParseFromFile(filepath.Join(dir, "0.yar")), variables.ErrIncludeDepth)
+
+	// The includes nested as deep as YARA allows are followed.
+	p = new(variables.Parser)
+	p.SetIncludeResolver(variables.FileIncludeResolver)
+	require.NoError(t, p.ParseFromFile(filepath.Join(dir, "4.yar")))
+	require.Empty(t, p.Unresolved())
+}
+
 const exampleRule = `
 private rule HexExample {
 	strings:
@@ -74,3 +171,35 @@ private rule HexExample {
 		$hex_string1 or $hex_string2 or $hex_string3 and file_path=="" and file_path=="" and os=="linux"
 }
 `
+
+func TestParseRule(t *testing.T) {
+	const rule = `
+include "common.yar"
+include "common.yar"
+import "pe"
+
+rule base {
+	condition:
+		file_name == "a.exe" and pe.is_pe
+}
+
+rule mixed {
+	strings:
+		$a = "abc"
+	condition:
+		base and common_rule and file_extension == "exe" and
+		for any i in (1..#a): (@a[i] > file_name_len) and
+		i > 0 and fiel_path contains "x" and file_name != ""
+}
+`
+	res, err := variables.ParseRule(rule)
+	require.NoError(t, err)
+	require.Equal(t, []variables.VariableType{
+		variables.VarFileName, variables.VarFileExtension,
+	}, res.Variables)
+	require.Equal(t, []string{"common.yar"}, res.Includes)
+	require.Equal(t, []string{"common_rule", "file_name_len", "i", "fiel_path"}, res.Unknown)
+
+	_, err = variables.ParseRule("rule invalid { condition: }")
+	require.Error(t, err)
+}
@@ -0,0 +1,34 @@
+// Package procinfo provides the process scan context backed by gopsutil. It is kept apart from the variables package so
+// that the variables package does not depend on gopsutil.
+package procinfo
+
+import (
+	"context"
+
+	"github.com/shirou/gopsutil/v3/process"
+
+	"github.com/binalyze/gora/variables"
+)
+
+// NewProcessInfo creates the gopsutil backed ProcessInfo of the process having the given pid. It implements the
+// variables.ProcessInfoFactory signature. It returns an error if the process does not exist.
+func NewProcessInfo(pid int) (variables.ProcessInfo, error) {
+	proc, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return nil, err
+	}
+	return proc, nil
+}
+
+var _ variables.ProcessInfoFactory = NewProcessInfo
+
+// ProcessScanContextFromPid creates a scan context for the process having the given pid using
+// variables.NewProcessScanContext. Its ProcessInfo is backed by gopsutil, its file path is the path of the process
+// executable if it is available, and its file info is not set. It returns an error if the process does not exist.
+func ProcessScanContextFromPid(ctx context.Context, pid int) (*variables.ScanContextImpl, error) {
+	proc, err := process.NewProcessWithContext(ctx, int32(pid))
+	if err != nil {
+		return nil, err
+	}
+	return variables.NewProcessScanContext(ctx, pid, proc), nil
+}
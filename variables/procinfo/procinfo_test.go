@@ -0,0 +1,71 @@
+package procinfo_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/binalyze/gora/variables"
+	"github.com/binalyze/gora/variables/procinfo"
+)
+
+type mapDefiner map[string]interface{}
+
+func (m mapDefiner) DefineVariable(name string, value interface{}) error {
+	m[name] = value
+	return nil
+}
+
+func TestProcessScanContextFromPid(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sctx, err := procinfo.ProcessScanContextFromPid(ctx, os.Getpid())
+	require.NoError(t, err)
+
+	require.Same(t, ctx, sctx.Context())
+	require.Equal(t, os.Getpid(), sctx.Pid())
+	require.NotNil(t, sctx.ProcessInfo())
+	require.Nil(t, sctx.FileInfo())
+	require.NoError(t, sctx.HandleValueError(nil, variables.VarProcessName, errors.New("test error")))
+
+	var vr variables.Variables
+	vr.InitProcessVariables([]variables.VariableType{
+		variables.VarProcessId,
+		variables.VarProcessParentId,
+		variables.VarProcessName,
+	})
+	values := make(mapDefiner)
+	require.NoError(t, vr.DefineScannerVariables(sctx, values))
+	require.Equal(t, int64(os.Getpid()), values["process_id"])
+	require.Equal(t, int64(os.Getppid()), values["process_parent_id"])
+
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	require.Equal(t, filepath.Base(exe), values["process_name"])
+	exe, err = filepath.EvalSymlinks(exe)
+	require.NoError(t, err)
+	path, err := filepath.EvalSymlinks(sctx.FilePath())
+	require.NoError(t, err)
+	require.Equal(t, exe, path)
+}
+
+func TestProcessScanContextFromPid_notExist(t *testing.T) {
+	_, err := procinfo.ProcessScanContextFromPid(context.Background(), 1<<30)
+	require.Error(t, err)
+}
+
+func TestNewProcessInfo(t *testing.T) {
+	proc, err := procinfo.NewProcessInfo(os.Getpid())
+	require.NoError(t, err)
+	ppid, err := proc.Ppid()
+	require.NoError(t, err)
+	require.Equal(t, int32(os.Getppid()), ppid)
+
+	proc, err = procinfo.NewProcessInfo(1 << 30)
+	require.Error(t, err)
+	require.Nil(t, proc)
+}
@@ -0,0 +1,102 @@
+package variables
+
+import (
+	"context"
+	"time"
+)
+
+// RetryPolicy represents how many times and how often a failing ProcessInfo call is attempted.
+type RetryPolicy struct {
+	// Attempts is the maximum number of calls including the first one. Values less than 1 are treated as 1.
+	Attempts int
+	// Backoff is the duration to wait between the attempts.
+	Backoff time.Duration
+}
+
+// retryProcessInfo implements the ProcessInfo interface by retrying the failing calls of the underlying ProcessInfo.
+type retryProcessInfo struct {
+	proc   ProcessInfo
+	policy RetryPolicy
+}
+
+var (
+	_ ProcessInfo             = (*retryProcessInfo)(nil)
+	_ ProcessOpenFilesCounter = (*retryProcessInfo)(nil)
+	_ ProcessExecutable       = (*retryProcessInfo)(nil)
+)
+
+// RetryProcessInfo wraps the given ProcessInfo to retry its failing calls according to the given policy. It is useful
+// for live process scans where the process information is transiently unavailable. If all the attempts fail, the last
+// error is returned which ends up in ScanContext.HandleValueError and the variable gets its default value.
+func RetryProcessInfo(p ProcessInfo, policy RetryPolicy) ProcessInfo {
+	if p == nil {
+		return nil
+	}
+	return &retryProcessInfo{proc: p, policy: policy}
+}
+
+func (r *retryProcessInfo) Ppid() (ppid int32, err error) {
+	err = r.retry(context.Background(), func() (e error) {
+		ppid, e = r.proc.Ppid()
+		return
+	})
+	return
+}
+
+func (r *retryProcessInfo) Username() (name string, err error) {
+	err = r.retry(context.Background(), func() (e error) {
+		name, e = r.proc.Username()
+		return
+	})
+	return
+}
+
+func (r *retryProcessInfo) NameWithContext(ctx context.Context) (name string, err error) {
+	err = r.retry(ctx, func() (e error) {
+		name, e = r.proc.NameWithContext(ctx)
+		return
+	})
+	return
+}
+
+func (r *retryProcessInfo) CmdlineWithContext(ctx context.Context) (cmdline string, err error) {
+	err = r.retry(ctx, func() (e error) {
+		cmdline, e = r.proc.CmdlineWithContext(ctx)
+		return
+	})
+	return
+}
+
+func (r *retryProcessInfo) OpenFilesCountWithContext(ctx context.Context) (n int, err error) {
+	err = r.retry(ctx, func() (e error) {
+		n, e = processOpenFilesCount(ctx, r.proc)
+		return
+	})
+	return
+}
+
+func (r *retryProcessInfo) ExeWithContext(ctx context.Context) (exe string, err error) {
+	err = r.retry(ctx, func() (e error) {
+		exe, e = processExe(ctx, r.proc)
+		return
+	})
+	return
+}
+
+func (r *retryProcessInfo) retry(ctx context.Context, fn func() error) (err error) {
+	for i := 0; ; i++ {
+		if err = fn(); err == nil || err == errOpenFilesNotSupported || err == errExeNotSupported || i+1 >= r.policy.Attempts {
+			return
+		}
+		if r.policy.Backoff <= 0 {
+			continue
+		}
+		timer := time.NewTimer(r.policy.Backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package variables_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/binalyze/gora/variables"
+)
+
+func TestRetryProcessInfo(t *testing.T) {
+	require.Nil(t, RetryProcessInfo(nil, RetryPolicy{Attempts: 2}))
+
+	errTest := errors.New("transient error")
+	policy := RetryPolicy{Attempts: 2, Backoff: time.Millisecond}
+
+	pi := new(processInfoMock)
+	pi.On("Username").Return("", errTest).Once()
+	pi.On("Username").Return("root", nil).Once()
+	pi.On("Ppid").Return(0, errTest).Once()
+	pi.On("Ppid").Return(1, nil).Once()
+	pi.On("NameWithContext", context.Background()).Return("", errTest).Once()
+	pi.On("NameWithContext", context.Background()).Return("init", nil).Once()
+	pi.On("CmdlineWithContext", context.Background()).Return("", errTest).Once()
+	pi.On("CmdlineWithContext", context.Background()).Return("/sbin/init", nil).Once()
+
+	sCtx := new(scanContextMock)
+	sCtx.On("Context").Return(context.Background())
+	sCtx.On("ProcessInfo").Return(RetryProcessInfo(pi, policy))
+
+	expects := map[VariableType]interface{}{
+		VarProcessUserName:    "root",
+		VarProcessParentId:    int64(1),
+		VarProcessName:        "init",
+		VarProcessCommandLine: "/sbin/init",
+	}
+	for vid, expect := range expects {
+		got, err := Valuers[vid].Value(sCtx)
+		require.NoError(t, err)
+		require.Equal(t, expect, got, vid.String())
+	}
+	pi.AssertExpectations(t)
+}
+
+func TestRetryProcessInfo_exhausted(t *testing.T) {
+	errTest := errors.New("transient error")
+
+	pi := new(processInfoMock)
+	pi.On("Username").Return("", errTest).Times(3)
+
+	_, err := RetryProcessInfo(pi, RetryPolicy{Attempts: 3}).Username()
+	require.Same(t, errTest, err)
+	pi.AssertExpectations(t)
+
+	// Zero policy calls only once.
+	pi = new(processInfoMock)
+	pi.On("Username").Return("", errTest).Once()
+
+	_, err = RetryProcessInfo(pi, RetryPolicy{}).Username()
+	require.Same(t, errTest, err)
+	pi.AssertExpectations(t)
+}
+
+func TestRetryProcessInfo_canceled(t *testing.T) {
+	errTest := errors.New("transient error")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	pi := new(processInfoMock)
+	pi.On("NameWithContext", ctx).Return("", errTest).Once()
+
+	_, err := RetryProcessInfo(pi, RetryPolicy{Attempts: 5, Backoff: time.Hour}).NameWithContext(ctx)
+	require.Same(t, errTest, err)
+	pi.AssertExpectations(t)
+}
@@ -0,0 +1,82 @@
+package variables_test
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/binalyze/gora/variables"
+)
+
+func TestFileCompressed(t *testing.T) {
+	_, info := writeFile(t, "flags.txt", []byte("test"))
+	sCtx := new(scanContextMock)
+	sCtx.On("FileInfo").Return(info)
+
+	got, err := Valuers[VarFileCompressed].Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, false, got)
+
+	// UF_COMPRESSED can not be set by chflags, so the flag is set on a copy of the stat data.
+	st := *info.Sys().(*syscall.Stat_t)
+	st.Flags |= 0x20
+	mi := new(mockFileInfo)
+	mi.On("Sys").Return(&st)
+	sCtx = new(scanContextMock)
+	sCtx.On("FileInfo").Return(mi)
+
+	got, err = Valuers[VarFileCompressed].Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, true, got)
+}
+
+func TestFileSigned(t *testing.T) {
+	VerifyFileSignatures = true
+	t.Cleanup(func() {
+		VerifyFileSignatures = false
+	})
+
+	unsigned, _ := writeFile(t, "unsigned", []byte("#!/bin/sh\n"))
+	tests := []struct {
+		path   string
+		signed interface{}
+		signer string
+	}{
+		{path: "/bin/ls", signed: true, signer: "Apple"},
+		{path: unsigned, signed: false},
+	}
+	for _, tt := range tests {
+		info, err := os.Stat(tt.path)
+		require.NoError(t, err)
+		sCtx := new(scanContextMock)
+		sCtx.On("Context").Return(context.Background())
+		sCtx.On("FilePath").Return(tt.path)
+		sCtx.On("FileInfo").Return(info)
+
+		got, err := Valuers[VarFileSigned].Value(sCtx)
+		require.NoError(t, err)
+		require.Equal(t, tt.signed, got, tt.path)
+
+		got, err = Valuers[VarFileSigner].Value(sCtx)
+		require.NoError(t, err)
+		if tt.signer == "" {
+			require.Nil(t, got, tt.path)
+		} else {
+			require.Contains(t, got, tt.signer, tt.path)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	info, err := os.Stat("/bin/ls")
+	require.NoError(t, err)
+	sCtx := new(scanContextMock)
+	sCtx.On("Context").Return(ctx)
+	sCtx.On("FilePath").Return("/bin/ls")
+	sCtx.On("FileInfo").Return(info)
+	_, err = Valuers[VarFileSigned].Value(sCtx)
+	require.ErrorIs(t, err, context.Canceled)
+}
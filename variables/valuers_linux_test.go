@@ -0,0 +1,72 @@
+package variables_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	. "github.com/binalyze/gora/variables"
+)
+
+func TestFileCompressedEncrypted(t *testing.T) {
+	path, info := writeFile(t, "flags.txt", []byte("test"))
+	sCtx := new(scanContextMock)
+	sCtx.On("FileInfo").Return(info)
+	sCtx.On("FilePath").Return(path)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	flags, err := unix.IoctlGetUint32(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+	if errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EINVAL) {
+		for _, vid := range []VariableType{VarFileCompressed, VarFileEncrypted} {
+			got, err := Valuers[vid].Value(sCtx)
+			require.NoError(t, err)
+			require.Nil(t, got, vid.String())
+		}
+		t.Skip("file system does not support inode flags")
+	}
+	require.NoError(t, err)
+
+	for _, vid := range []VariableType{VarFileCompressed, VarFileEncrypted} {
+		got, err := Valuers[vid].Value(sCtx)
+		require.NoError(t, err)
+		require.Equal(t, false, got, vid.String())
+	}
+
+	// FS_COMPR_FL is settable only on the file systems supporting compression, such as Btrfs.
+	if err = unix.IoctlSetPointerInt(int(f.Fd()), unix.FS_IOC_SETFLAGS, int(flags|0x4)); err != nil {
+		t.Skipf("file system does not support compression flag: %v", err)
+	}
+	got, err := Valuers[VarFileCompressed].Value(sCtx)
+	require.NoError(t, err)
+	if got == false {
+		t.Skip("file system ignores compression flag")
+	}
+	require.Equal(t, true, got)
+}
+
+func TestFileSigned(t *testing.T) {
+	VerifyFileSignatures = true
+	t.Cleanup(func() {
+		VerifyFileSignatures = false
+	})
+
+	path, info := writeFile(t, "signed", []byte("test"))
+	sCtx := new(scanContextMock)
+	sCtx.On("FilePath").Return(path)
+	sCtx.On("FileInfo").Return(info)
+	sCtx.On("Context").Return(context.Background())
+
+	got, err := Valuers[VarFileSigned].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+
+	got, err = Valuers[VarFileSigner].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
@@ -1,15 +1,28 @@
 package variables_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"io/fs"
+	"math/rand"
 	"os"
+	"os/user"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/djherbis/times"
 	"github.com/shirou/gopsutil/v3/process"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -195,6 +208,8 @@ func TestValuers(t *testing.T) {
 					mi := new(mockFileInfo)
 					mi.On("Sys").Return(windowsFileAttributeData(FILE_ATTRIBUTE_COMPRESSED)).Times(1)
 					c.On("FileInfo").Return(mi).Times(1)
+				} else if runtime.GOOS != "windows" {
+					c.On("FileInfo").Return(nil).Times(1)
 				}
 				return c
 			}(),
@@ -214,6 +229,8 @@ func TestValuers(t *testing.T) {
 					mi := new(mockFileInfo)
 					mi.On("Sys").Return(windowsFileAttributeData(0)).Times(1)
 					c.On("FileInfo").Return(mi).Times(1)
+				} else if runtime.GOOS != "windows" {
+					c.On("FileInfo").Return(nil).Times(1)
 				}
 				return c
 			}(),
@@ -233,6 +250,8 @@ func TestValuers(t *testing.T) {
 					mi := new(mockFileInfo)
 					mi.On("Sys").Return(windowsFileAttributeData(FILE_ATTRIBUTE_ENCRYPTED)).Times(1)
 					c.On("FileInfo").Return(mi).Times(1)
+				} else if runtime.GOOS == "linux" {
+					c.On("FileInfo").Return(nil).Times(1)
 				}
 				return c
 			}(),
@@ -252,6 +271,8 @@ func TestValuers(t *testing.T) {
 					mi := new(mockFileInfo)
 					mi.On("Sys").Return(windowsFileAttributeData(0)).Times(1)
 					c.On("FileInfo").Return(mi).Times(1)
+				} else if runtime.GOOS == "linux" {
+					c.On("FileInfo").Return(nil).Times(1)
 				}
 				return c
 			}(),
@@ -518,6 +539,300 @@ func TestValuers(t *testing.T) {
 				return c
 			}(),
 		},
+		{
+			vid:    VarFileHeaderHex,
+			expect: nil,
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return("").Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileHeaderHex,
+			expect: nil,
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return(filepath.Join(t.TempDir(), "missing")).Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileHeaderHex,
+			expect: "",
+			c: func() *scanContextMock {
+				path, _ := writeFile(t, "empty", nil)
+				c := new(scanContextMock)
+				c.On("FilePath").Return(path).Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileHeaderHex,
+			expect: "4d5a90000300000004000000ffff0000",
+			c: func() *scanContextMock {
+				path, _ := writeFile(t, "pe.exe", []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xff\xff\x00\x00\xb8\x00"))
+				c := new(scanContextMock)
+				c.On("FilePath").Return(path).Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileHeaderHex,
+			expect: "7f454c46",
+			c: func() *scanContextMock {
+				path, _ := writeFile(t, "elf", []byte("\x7fELF"))
+				c := new(scanContextMock)
+				c.On("FilePath").Return(path).Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileMimeFromExt,
+			expect: "application/pdf",
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return(filepath.Join("a", "b.pdf")).Times(1)
+				return c
+			}(),
+		},
+		{
+			vid: VarFileMimeFromExt,
+			expect: func(t *testing.T, got interface{}) {
+				// System mime tables may register either text/javascript or application/javascript.
+				require.Contains(t, got, "javascript")
+				require.NotContains(t, got, ";")
+			},
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return(filepath.Join("a", "b.js")).Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileMimeFromExt,
+			expect: nil,
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return(filepath.Join("a", "b.xyz")).Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileMimeFromExt,
+			expect: nil,
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return(filepath.Join("a", "b")).Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileMimeFromExt,
+			expect: nil,
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return("").Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileDirName,
+			expect: nil,
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return("").Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileDirName,
+			expect: "",
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return("x.txt").Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileDirName,
+			expect: "",
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				if runtime.GOOS != "windows" {
+					c.On("FilePath").Return("/x.txt").Times(1)
+				} else {
+					c.On("FilePath").Return(`C:\x.exe`).Times(1)
+				}
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileDirName,
+			expect: "a",
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				if runtime.GOOS != "windows" {
+					c.On("FilePath").Return("/a/b.txt").Times(1)
+				} else {
+					c.On("FilePath").Return(`C:\a\b.txt`).Times(1)
+				}
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileDirName,
+			expect: "Downloads",
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				if runtime.GOOS != "windows" {
+					c.On("FilePath").Return("/home/bob/Downloads/x.exe").Times(1)
+				} else {
+					c.On("FilePath").Return(`C:\Users\bob\Downloads\x.exe`).Times(1)
+				}
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileExtCount,
+			expect: nil,
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return("").Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileExtCount,
+			expect: int64(1),
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return("report.docx").Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileExtCount,
+			expect: int64(2),
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return("invoice.pdf.exe").Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileExtCount,
+			expect: int64(3),
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return("backup.tar.gz.exe").Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileExtCount,
+			expect: int64(0),
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return("archive").Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileExtCount,
+			expect: int64(0),
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return(".bashrc").Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileExtCount,
+			expect: int64(1),
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return(".config.bak").Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileExecutable,
+			expect: nil,
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				if runtime.GOOS != "windows" {
+					c.On("FileInfo").Return(nil).Times(1)
+				} else {
+					c.On("FilePath").Return("").Times(1)
+				}
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileExecutable,
+			expect: true,
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				if runtime.GOOS != "windows" {
+					p := filepath.Join(t.TempDir(), "run.sh")
+					require.NoError(t, os.WriteFile(p, nil, 0755))
+					info, err := os.Stat(p)
+					require.NoError(t, err)
+					c.On("FileInfo").Return(info).Times(1)
+				} else {
+					c.On("FilePath").Return(`C:\Users\bob\Downloads\Setup.EXE`).Times(1)
+				}
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileExecutable,
+			expect: false,
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				if runtime.GOOS != "windows" {
+					p := filepath.Join(t.TempDir(), "run.exe")
+					require.NoError(t, os.WriteFile(p, nil, 0644))
+					info, err := os.Stat(p)
+					require.NoError(t, err)
+					c.On("FileInfo").Return(info).Times(1)
+				} else {
+					c.On("FilePath").Return(`C:\Users\bob\Downloads\readme.txt`).Times(1)
+				}
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileExists,
+			expect: nil,
+			c: func() *scanContextMock {
+				c := new(scanContextMock)
+				c.On("FilePath").Return("").Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileExists,
+			expect: true,
+			c: func() *scanContextMock {
+				p, _ := writeFile(t, "exists.txt", nil)
+				c := new(scanContextMock)
+				c.On("FilePath").Return(p).Times(1)
+				return c
+			}(),
+		},
+		{
+			vid:    VarFileExists,
+			expect: false,
+			c: func() *scanContextMock {
+				p, _ := writeFile(t, "deleted.txt", nil)
+				require.NoError(t, os.Remove(p))
+				c := new(scanContextMock)
+				c.On("FilePath").Return(p).Times(1)
+				return c
+			}(),
+		},
 	}
 
 	for _, tC := range testCases {
@@ -539,6 +854,64 @@ func TestValuers(t *testing.T) {
 
 }
 
+type fakeTimespec struct {
+	mtime, atime, ctime, btime time.Time
+}
+
+func (ts fakeTimespec) ModTime() time.Time    { return ts.mtime }
+func (ts fakeTimespec) AccessTime() time.Time { return ts.atime }
+func (ts fakeTimespec) ChangeTime() time.Time { return ts.ctime }
+func (ts fakeTimespec) BirthTime() time.Time  { return ts.btime }
+func (ts fakeTimespec) HasChangeTime() bool   { return !ts.ctime.IsZero() }
+func (ts fakeTimespec) HasBirthTime() bool    { return !ts.btime.IsZero() }
+
+func TestFileTimes(t *testing.T) {
+	orig := FileTimes
+	t.Cleanup(func() {
+		FileTimes = orig
+	})
+
+	fake := fakeTimespec{
+		atime: time.Date(2022, 1, 2, 3, 4, 5, 0, time.Local),
+		ctime: time.Date(2021, 6, 7, 8, 9, 10, 0, time.Local),
+		btime: time.Date(2020, 11, 12, 13, 14, 15, 0, time.Local),
+	}
+	FileTimes = func(fs.FileInfo) times.Timespec {
+		return fake
+	}
+
+	_, info := touchFile(t, "c.txt")
+	sCtx := new(scanContextMock)
+	sCtx.On("FileInfo").Return(info)
+
+	expects := map[VariableType]interface{}{
+		VarFileAccessedTime: int64(20220102030405),
+		VarFileChangedTime:  int64(20210607080910),
+		VarFileBirthTime:    int64(20201112131415),
+	}
+	for vid, expect := range expects {
+		got, err := Valuers[vid].Value(sCtx)
+		require.NoError(t, err)
+		require.Equal(t, expect, got, vid.String())
+	}
+
+	// The unavailable times are not formatted as 00010101000000 but left to their defaults.
+	fake.atime, fake.ctime, fake.btime = time.Time{}, time.Time{}, time.Time{}
+	for _, vid := range []VariableType{VarFileAccessedTime, VarFileChangedTime, VarFileBirthTime} {
+		got, err := Valuers[vid].Value(sCtx)
+		require.NoError(t, err)
+		require.Nil(t, got, vid.String())
+	}
+
+	mi := new(mockFileInfo)
+	mi.On("ModTime").Return(time.Time{})
+	sCtx = new(scanContextMock)
+	sCtx.On("FileInfo").Return(mi)
+	got, err := Valuers[VarFileModifiedTime].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
 func touchFile(t *testing.T, name string) (path string, info fs.FileInfo) {
 	t.Helper()
 
@@ -551,6 +924,18 @@ func touchFile(t *testing.T, name string) (path string, info fs.FileInfo) {
 	return
 }
 
+func writeFile(t *testing.T, name string, data []byte) (path string, info fs.FileInfo) {
+	t.Helper()
+
+	path = filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, data, 0666)
+	require.NoError(t, err)
+
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	return
+}
+
 type mockFileInfo struct {
 	mock.Mock
 }
@@ -601,3 +986,591 @@ func getAppFilePath() string {
 	})
 	return appFile
 }
+
+func TestFileSymlink(t *testing.T) {
+	target, _ := writeFile(t, "target.txt", nil)
+	link := filepath.Join(t.TempDir(), "link.txt")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skip("symbolic link can not be created:", err)
+	}
+
+	sCtx := new(scanContextMock)
+	sCtx.On("FilePath").Return(link)
+	got, err := Valuers[VarFileIsSymlink].Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, true, got)
+	got, err = Valuers[VarFileSymlinkTarget].Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, target, got)
+
+	sCtx = new(scanContextMock)
+	sCtx.On("FilePath").Return(target)
+	got, err = Valuers[VarFileIsSymlink].Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, false, got)
+	got, err = Valuers[VarFileSymlinkTarget].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+
+	sCtx = new(scanContextMock)
+	sCtx.On("FilePath").Return("")
+	for _, vid := range []VariableType{VarFileIsSymlink, VarFileSymlinkTarget} {
+		got, err = Valuers[vid].Value(sCtx)
+		require.NoError(t, err)
+		require.Nil(t, got, vid.String())
+	}
+}
+
+// TestFileSymlink_notExist checks that a path which does not exist, e.g. a synthetic path, is not a symbolic link.
+func TestFileSymlink_notExist(t *testing.T) {
+	sCtx := new(scanContextMock)
+	sCtx.On("FilePath").Return(filepath.Join(t.TempDir(), "missing.txt"))
+	got, err := Valuers[VarFileIsSymlink].Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, false, got)
+	got, err = Valuers[VarFileSymlinkTarget].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+func TestPathNormalization(t *testing.T) {
+	t.Cleanup(func() {
+		PathNormalization = 0
+	})
+
+	path := "/Home/Bob/Downloads/Invoice.PDF"
+	if runtime.GOOS == "windows" {
+		path = `C:\Users\Bob\Downloads\Invoice.PDF`
+	}
+	sCtx := new(scanContextMock)
+	sCtx.On("FilePath").Return(path)
+
+	values := func() []interface{} {
+		var values []interface{}
+		for _, vid := range []VariableType{VarFilePath, VarFileName, VarFileExtension, VarFileDirName} {
+			got, err := Valuers[vid].Value(sCtx)
+			require.NoError(t, err)
+			values = append(values, got)
+		}
+		return values
+	}
+
+	require.Equal(t, []interface{}{path, "Invoice.PDF", "PDF", "Downloads"}, values())
+
+	PathNormalization = PathLowerCase
+	require.Equal(t, []interface{}{strings.ToLower(path), "invoice.pdf", "pdf", "downloads"}, values())
+
+	PathNormalization = PathLowerCase | PathForwardSlash
+	expect := "/home/bob/downloads/invoice.pdf"
+	if runtime.GOOS == "windows" {
+		expect = "c:/users/bob/downloads/invoice.pdf"
+	}
+	require.Equal(t, []interface{}{expect, "invoice.pdf", "pdf", "downloads"}, values())
+
+	PathNormalization = PathForwardSlash
+	got, err := Valuers[VarFilePath].Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, filepath.ToSlash(path), got)
+}
+
+func TestScannerUser(t *testing.T) {
+	usr, err := user.Current()
+	if err != nil {
+		t.Skip("current user is not available:", err)
+	}
+	got, err := Valuers[VarScannerUser].Value(new(scanContextMock))
+	require.NoError(t, err)
+	require.Equal(t, usr.Username, got)
+}
+
+func TestFileHash(t *testing.T) {
+	t.Cleanup(func() {
+		HashAlgorithm = "sha256"
+	})
+
+	data := []byte("test data")
+	path, _ := writeFile(t, "hash.txt", data)
+	sCtx := new(scanContextMock)
+	sCtx.On("FilePath").Return(path)
+
+	digests := map[string]string{
+		"sha256": fmt.Sprintf("%x", sha256.Sum256(data)),
+		"sha1":   fmt.Sprintf("%x", sha1.Sum(data)),
+		"md5":    fmt.Sprintf("%x", md5.Sum(data)),
+		"sha512": fmt.Sprintf("%x", sha512.Sum512(data)),
+	}
+	for alg, digest := range digests {
+		HashAlgorithm = alg
+		got, err := Valuers[VarFileHash].Value(sCtx)
+		require.NoError(t, err)
+		require.Equal(t, digest, got, alg)
+	}
+
+	// The instance keeps the algorithm at initialization.
+	HashAlgorithm = "md5"
+	var vr Variables
+	vr.InitFileVariables([]VariableType{VarFileHash})
+	require.NoError(t, vr.Validate())
+	HashAlgorithm = "sha1"
+
+	scanner := new(variableDefinerMock)
+	scanner.On("DefineVariable", VarFileHash.String(), digests["md5"]).Return(nil).Once()
+	require.NoError(t, vr.DefineScannerVariables(sCtx, scanner))
+	scanner.AssertExpectations(t)
+
+	HashAlgorithm = "crc32"
+	_, err := Valuers[VarFileHash].Value(sCtx)
+	require.Error(t, err)
+	vr.InitFileVariables([]VariableType{VarFileHash})
+	require.Error(t, vr.Validate())
+
+	HashAlgorithm = "sha256"
+	sCtx = new(scanContextMock)
+	sCtx.On("FilePath").Return("")
+	got, err := Valuers[VarFileHash].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+
+	// An unreadable file has no hash as the other content derived variables.
+	sCtx = new(scanContextMock)
+	sCtx.On("FilePath").Return(filepath.Join(t.TempDir(), "missing.txt"))
+	got, err = Valuers[VarFileHash].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+func TestFileSizeBucket(t *testing.T) {
+	sizes := map[int64]int64{
+		0:           0,
+		4<<10 - 1:   0,
+		4 << 10:     1,
+		1<<20 - 1:   1,
+		1 << 20:     2,
+		100<<20 - 1: 2,
+		100 << 20:   3,
+		1 << 40:     3,
+	}
+	for size, bucket := range sizes {
+		info := new(mockFileInfo)
+		info.On("Size").Return(size)
+		sCtx := new(scanContextMock)
+		sCtx.On("FileInfo").Return(info)
+
+		got, err := Valuers[VarFileSizeBucket].Value(sCtx)
+		require.NoError(t, err)
+		require.Equal(t, bucket, got, size)
+	}
+
+	orig := FileSizeBuckets
+	t.Cleanup(func() {
+		FileSizeBuckets = orig
+	})
+	FileSizeBuckets = []int64{10}
+	_, info := writeFile(t, "bucket.txt", make([]byte, 10))
+	sCtx := new(scanContextMock)
+	sCtx.On("FileInfo").Return(info)
+	got, err := Valuers[VarFileSizeBucket].Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), got)
+
+	sCtx = new(scanContextMock)
+	sCtx.On("FileInfo").Return(nil)
+	got, err = Valuers[VarFileSizeBucket].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+func TestFileExtCategory(t *testing.T) {
+	paths := map[string]interface{}{
+		"/tmp/backup.tar.gz":   "archive",
+		"/tmp/setup.EXE":       "executable",
+		"/tmp/libc.so":         "executable",
+		"/tmp/report.docx":     "document",
+		"/tmp/invoice.pdf":     "document",
+		"/tmp/run.ps1":         "script",
+		"/tmp/install.sh":      "script",
+		"/tmp/photo.JPG":       "image",
+		"/tmp/data.unknownext": "other",
+		"/tmp/Makefile":        "other",
+		"/tmp/.bashrc":         "other",
+		"":                     nil,
+	}
+	for path, category := range paths {
+		sCtx := new(scanContextMock)
+		sCtx.On("FilePath").Return(path)
+
+		got, err := Valuers[VarFileExtCategory].Value(sCtx)
+		require.NoError(t, err)
+		require.Equal(t, category, got, path)
+	}
+
+	orig := ExtensionCategories
+	t.Cleanup(func() {
+		ExtensionCategories = orig
+	})
+	ExtensionCategories = map[string]string{".log": "document"}
+	sCtx := new(scanContextMock)
+	sCtx.On("FilePath").Return("/tmp/app.log")
+	got, err := Valuers[VarFileExtCategory].Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, "document", got)
+}
+
+func TestFilePrintableRatio(t *testing.T) {
+	ratio := func(path string) interface{} {
+		t.Helper()
+		sCtx := new(scanContextMock)
+		sCtx.On("FilePath").Return(path)
+		got, err := Valuers[VarFilePrintableRatio].Value(sCtx)
+		require.NoError(t, err)
+		return got
+	}
+
+	text, _ := writeFile(t, "text.txt", []byte("#!/bin/sh\r\necho \"hello world\"\n\texit 0\n"))
+	require.Equal(t, 1.0, ratio(text))
+
+	binary := make([]byte, 1024)
+	for i := range binary {
+		binary[i] = byte(i%32) | byte(i%2)<<7
+	}
+	bin, _ := writeFile(t, "binary.bin", binary)
+	got := ratio(bin)
+	require.InDelta(t, 0.0, got, 0.05)
+
+	mixed, _ := writeFile(t, "mixed.bin", append([]byte("abcd"), 0, 1, 2, 3))
+	require.Equal(t, 0.5, ratio(mixed))
+
+	empty, _ := writeFile(t, "empty.txt", nil)
+	require.Nil(t, ratio(empty))
+	require.Nil(t, ratio(filepath.Join(t.TempDir(), "missing.txt")))
+	require.Nil(t, ratio(""))
+
+	orig := MaxContentBytes
+	t.Cleanup(func() {
+		MaxContentBytes = orig
+	})
+	MaxContentBytes = 4
+	require.Equal(t, 1.0, ratio(mixed))
+
+	got, err := Valuers[VarFilePrintableRatio].Value(NewBufferScanContext("buffer.bin", []byte{'a', 0}))
+	require.NoError(t, err)
+	require.Equal(t, 0.5, got)
+}
+
+func TestFileInDirs(t *testing.T) {
+	origTemp, origHome, origSystem := TempDirs, HomeDirs, SystemDirs
+	t.Cleanup(func() {
+		TempDirs, HomeDirs, SystemDirs = origTemp, origHome, origSystem
+	})
+	root := t.TempDir()
+	TempDirs = []string{filepath.Join(root, "temp"), ""}
+	HomeDirs = []string{filepath.Join(root, "home") + string(filepath.Separator)}
+	SystemDirs = nil
+
+	tests := []struct {
+		path   string
+		temp   interface{}
+		home   interface{}
+		system interface{}
+	}{
+		{path: filepath.Join(root, "temp", "file.exe"), temp: true, home: false, system: false},
+		{path: filepath.Join(root, "temp", "dir", "..", "file.exe"), temp: true, home: false, system: false},
+		{path: filepath.Join(root, "home", "user", "file.txt"), temp: false, home: true, system: false},
+		{path: filepath.Join(root, "temporary", "file.exe"), temp: false, home: false, system: false},
+		{path: filepath.Join(root, "temp"), temp: false, home: false, system: false},
+		{path: "", temp: nil, home: nil, system: nil},
+	}
+	for _, tt := range tests {
+		sCtx := new(scanContextMock)
+		sCtx.On("FilePath").Return(tt.path)
+		for vid, expect := range map[VariableType]interface{}{
+			VarFileInTemp:   tt.temp,
+			VarFileInHome:   tt.home,
+			VarFileInSystem: tt.system,
+		} {
+			got, err := Valuers[vid].Value(sCtx)
+			require.NoError(t, err)
+			require.Equal(t, expect, got, "%s: %s", vid, tt.path)
+		}
+	}
+}
+
+func TestFileMimeTop(t *testing.T) {
+	files := map[string]struct {
+		content []byte
+		expect  interface{}
+	}{
+		"doc.pdf":   {content: []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n1 0 obj"), expect: "application"},
+		"image.png": {content: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), expect: "image"},
+		"notes.txt": {content: []byte("plain text notes\n"), expect: "text"},
+		"empty":     {content: nil, expect: nil},
+	}
+	for name, file := range files {
+		path, _ := writeFile(t, name, file.content)
+		sCtx := new(scanContextMock)
+		sCtx.On("FilePath").Return(path)
+		got, err := Valuers[VarFileMimeTop].Value(sCtx)
+		require.NoError(t, err)
+		require.Equal(t, file.expect, got, name)
+	}
+
+	sCtx := new(scanContextMock)
+	sCtx.On("FilePath").Return("")
+	got, err := Valuers[VarFileMimeTop].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+func TestFileEntropy(t *testing.T) {
+	entropy := func(sCtx ScanContext) interface{} {
+		t.Helper()
+		got, err := Valuers[VarFileEntropy].Value(sCtx)
+		require.NoError(t, err)
+		return got
+	}
+	fileCtx := func(path string) ScanContext {
+		sCtx := new(scanContextMock)
+		sCtx.On("FilePath").Return(path)
+		return sCtx
+	}
+
+	uniform := make([]byte, 4096)
+	for i := range uniform {
+		uniform[i] = byte(i)
+	}
+	path, _ := writeFile(t, "uniform.bin", uniform)
+	require.InDelta(t, 8.0, entropy(fileCtx(path)), 1e-9)
+	path, _ = writeFile(t, "zeros.bin", make([]byte, 4096))
+	require.Equal(t, 0.0, entropy(fileCtx(path)))
+	path, _ = writeFile(t, "empty.bin", nil)
+	require.Nil(t, entropy(fileCtx(path)))
+	require.Nil(t, entropy(fileCtx("")))
+
+	origMax, origChunk, origSampling := MaxContentBytes, EntropyChunkSize, EntropySampling
+	t.Cleanup(func() {
+		MaxContentBytes, EntropyChunkSize, EntropySampling = origMax, origChunk, origSampling
+	})
+	MaxContentBytes = 64 << 10
+	EntropyChunkSize = 4 << 10
+
+	// The installer-like file has a low entropy head and a packed tail.
+	content := make([]byte, 1<<20)
+	_, _ = rand.New(rand.NewSource(1)).Read(content[len(content)/2:])
+	path, _ = writeFile(t, "installer.exe", content)
+
+	for _, sCtx := range []ScanContext{fileCtx(path), NewBufferScanContext("installer.exe", content)} {
+		EntropySampling = SamplePrefix
+		require.Equal(t, 0.0, entropy(sCtx))
+
+		EntropySampling = SampleEven
+		even := entropy(sCtx).(float64)
+		require.Greater(t, even, 0.9)
+
+		EntropySampling = SampleExponential
+		exponential := entropy(sCtx).(float64)
+		require.Greater(t, exponential, 0.0)
+		require.Less(t, exponential, even)
+	}
+
+	// The samples are bounded by the scan limit.
+	var vr Variables
+	vr.InitFileVariables([]VariableType{VarFileEntropy})
+	vr.SetMaxScanBytes(MaxContentBytes)
+	var sCtx ScanContextImpl
+	sCtx.SetFilePath(path)
+	EntropySampling = SampleEven
+	values := make(mapDefiner)
+	require.NoError(t, vr.DefineScannerVariables(&sCtx, values))
+	require.Greater(t, values[VarFileEntropy.String()], 0.9)
+	vr.SetMaxScanBytes(MaxContentBytes - 1)
+	require.ErrorIs(t, vr.DefineScannerVariables(&sCtx, make(mapDefiner)), ErrMaxScanBytes)
+}
+
+func TestProcessCmdlineLengthSha256(t *testing.T) {
+	value := func(vid VariableType, proc ProcessInfo) interface{} {
+		t.Helper()
+		sCtx := new(scanContextMock)
+		sCtx.On("Context").Return(context.Background())
+		sCtx.On("ProcessInfo").Return(proc)
+		got, err := Valuers[vid].Value(sCtx)
+		require.NoError(t, err)
+		return got
+	}
+	cmdline := func(s string) ProcessInfo {
+		pi := new(processInfoMock)
+		pi.On("CmdlineWithContext", context.Background()).Return(s, nil)
+		return pi
+	}
+
+	const known = `powershell.exe -nop -w hidden -enc SQBFAFgA`
+	require.Equal(t, int64(len(known)), value(VarProcessCmdlineLength, cmdline(known)))
+	require.Equal(t, "089992bbd0952df25dec5e77b2beee8ec3be5381a36f563198ed797b3de67246",
+		value(VarProcessCmdlineSha256, cmdline(known)))
+
+	require.Equal(t, int64(0), value(VarProcessCmdlineLength, cmdline("")))
+	require.Nil(t, value(VarProcessCmdlineSha256, cmdline("")))
+	require.Nil(t, value(VarProcessCmdlineLength, nil))
+	require.Nil(t, value(VarProcessCmdlineSha256, nil))
+
+	errTest := errors.New("test error")
+	pi := new(processInfoMock)
+	pi.On("CmdlineWithContext", context.Background()).Return("", errTest)
+	sCtx := new(scanContextMock)
+	sCtx.On("Context").Return(context.Background())
+	sCtx.On("ProcessInfo").Return(pi)
+	for _, vid := range []VariableType{VarProcessCmdlineLength, VarProcessCmdlineSha256} {
+		_, err := Valuers[vid].Value(sCtx)
+		require.ErrorIs(t, err, errTest)
+	}
+}
+
+func TestFilePathAllowlisted(t *testing.T) {
+	root := t.TempDir()
+	vendor := filepath.Join(root, "opt", "vendor")
+	orig := PathAllowlist
+	t.Cleanup(func() {
+		PathAllowlist = orig
+	})
+	PathAllowlist = []string{filepath.Join(vendor, "*"), filepath.Join(root, "bin", "*.exe")}
+
+	tests := []struct {
+		path   string
+		expect interface{}
+	}{
+		{path: filepath.Join(vendor, "agent"), expect: true},
+		{path: filepath.Join(vendor, "lib", "plugins", "x.so"), expect: true},
+		{path: filepath.Join(vendor, "lib", "..", "agent"), expect: true},
+		{path: filepath.Join(root, "bin", "tool.exe"), expect: true},
+		{path: filepath.Join(root, "bin", "tool.sh"), expect: false},
+		{path: filepath.Join(root, "opt", "vendor2", "agent"), expect: false},
+		{path: vendor, expect: false},
+		{path: "", expect: nil},
+	}
+	for _, tt := range tests {
+		sCtx := new(scanContextMock)
+		sCtx.On("FilePath").Return(tt.path)
+		got, err := Valuers[VarFilePathAllowlisted].Value(sCtx)
+		require.NoError(t, err)
+		require.Equal(t, tt.expect, got, tt.path)
+	}
+
+	// The allowlist of the instance overrides the global one.
+	var vr Variables
+	vr.InitFileVariables([]VariableType{VarFilePathAllowlisted})
+	define := func(path string) interface{} {
+		t.Helper()
+		var sCtx ScanContextImpl
+		sCtx.SetFilePath(path)
+		values := make(mapDefiner)
+		require.NoError(t, vr.DefineScannerVariables(&sCtx, values))
+		return values[VarFilePathAllowlisted.String()]
+	}
+	require.Equal(t, true, define(filepath.Join(vendor, "agent")))
+	require.NoError(t, vr.SetPathAllowlist([]string{filepath.Join(root, "bin", "*")}))
+	require.Equal(t, false, define(filepath.Join(vendor, "agent")))
+	require.Equal(t, true, define(filepath.Join(root, "bin", "tool.sh")))
+	require.NoError(t, vr.SetPathAllowlist([]string{}))
+	require.Equal(t, false, define(filepath.Join(root, "bin", "tool.sh")))
+	require.NoError(t, vr.SetPathAllowlist(nil))
+	require.Equal(t, true, define(filepath.Join(root, "bin", "tool.exe")))
+
+	require.ErrorIs(t, vr.SetPathAllowlist([]string{"[a-"}), filepath.ErrBadPattern)
+	require.NoError(t, vr.Validate())
+	PathAllowlist = []string{"[a-"}
+	require.ErrorIs(t, vr.Validate(), filepath.ErrBadPattern)
+}
+
+func TestFileSigned_disabled(t *testing.T) {
+	require.False(t, VerifyFileSignatures)
+
+	// The file is not even stat'ed while the verification is disabled.
+	sCtx := new(scanContextMock)
+	got, err := Valuers[VarFileSigned].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+
+	got, err = Valuers[VarFileSigner].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+func TestFileFirstLine(t *testing.T) {
+	orig := FirstLineMaxLength
+	t.Cleanup(func() {
+		FirstLineMaxLength = orig
+	})
+	FirstLineMaxLength = 16
+
+	binary := []byte{0x7f, 'E', 'L', 'F', 0x02, 0x01, 0x01, 0x00}
+	files := map[string]struct {
+		content []byte
+		expect  interface{}
+	}{
+		"script.sh":  {content: []byte("#!/bin/bash\necho test\n"), expect: "#!/bin/bash"},
+		"script.bat": {content: []byte("@echo off\r\necho test\r\n"), expect: "@echo off"},
+		"long.txt":   {content: []byte("a line longer than the limit\n"), expect: "a line longer th"},
+		"binary":     {content: append(binary, make([]byte, 32)...), expect: string(append(binary, make([]byte, 8)...))},
+		"binary_nl":  {content: []byte("\x00\x01\n\x02\x03"), expect: "\x00\x01"},
+		"empty":      {content: nil, expect: ""},
+	}
+	for name, file := range files {
+		path, _ := writeFile(t, name, file.content)
+		sCtx := new(scanContextMock)
+		sCtx.On("FilePath").Return(path)
+		got, err := Valuers[VarFileFirstLine].Value(sCtx)
+		require.NoError(t, err)
+		require.Equal(t, file.expect, got, name)
+	}
+
+	for _, path := range []string{"", filepath.Join(t.TempDir(), "missing")} {
+		sCtx := new(scanContextMock)
+		sCtx.On("FilePath").Return(path)
+		got, err := Valuers[VarFileFirstLine].Value(sCtx)
+		require.NoError(t, err)
+		require.Nil(t, got)
+	}
+}
+
+// readerScanContext provides the scanned content through ContentReaderProvider.
+type readerScanContext struct {
+	*scanContextMock
+	content []byte
+	err     error
+}
+
+func (sc *readerScanContext) ContentReader() (io.ReadCloser, error) {
+	if sc.err != nil {
+		return nil, sc.err
+	}
+	return io.NopCloser(bytes.NewReader(sc.content)), nil
+}
+
+func TestContentReaderProvider(t *testing.T) {
+	path, _ := writeFile(t, "content.txt", []byte("content at the path\n"))
+	content := []byte("#!/bin/sh\ncontent in memory")
+	sum := sha256.Sum256(content)
+
+	sCtx := &readerScanContext{scanContextMock: new(scanContextMock), content: content}
+	sCtx.On("FilePath").Return(path)
+	got, err := Valuers[VarFileHash].Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, hex.EncodeToString(sum[:]), got)
+	got, err = Valuers[VarFileFirstLine].Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, "#!/bin/sh", got)
+
+	// The file path is opened if the scan context has no reader.
+	sCtx.err = ErrNoContentReader
+	got, err = Valuers[VarFileFirstLine].Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, "content at the path", got)
+
+	// The other errors are not fallen back.
+	sCtx.err = errors.New("test error")
+	got, err = Valuers[VarFileFirstLine].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+	got, err = Valuers[VarFileHash].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
@@ -0,0 +1,95 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package variables_test
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/binalyze/gora/variables"
+)
+
+func TestFileOwnerGroup(t *testing.T) {
+	_, info := writeFile(t, "group.txt", nil)
+	gid := info.Sys().(*syscall.Stat_t).Gid
+	grp, err := user.LookupGroupId(strconv.FormatUint(uint64(gid), 10))
+	require.NoError(t, err)
+
+	sCtx := new(scanContextMock)
+	sCtx.On("FileInfo").Return(info)
+
+	got, err := Valuers[VarFileOwnerGid].Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, int64(gid), got)
+
+	got, err = Valuers[VarFileOwnerGroupName].Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, grp.Name, got)
+
+	sCtx = new(scanContextMock)
+	sCtx.On("FileInfo").Return(nil)
+	for _, vid := range []VariableType{VarFileOwnerGid, VarFileOwnerGroupName} {
+		got, err = Valuers[vid].Value(sCtx)
+		require.NoError(t, err)
+		require.Nil(t, got, vid.String())
+	}
+}
+
+func TestProcessElevated(t *testing.T) {
+	sCtx := new(scanContextMock)
+	sCtx.On("Pid").Return(os.Getpid())
+	got, err := Valuers[VarProcessElevated].Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, os.Geteuid() == 0, got)
+
+	sCtx = new(scanContextMock)
+	sCtx.On("Pid").Return(1 << 30)
+	_, err = Valuers[VarProcessElevated].Value(sCtx)
+	require.Error(t, err)
+
+	sCtx = new(scanContextMock)
+	sCtx.On("Pid").Return(0)
+	got, err = Valuers[VarProcessElevated].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+func TestFileInDirs_defaults(t *testing.T) {
+	tests := []struct {
+		path   string
+		vid    VariableType
+		expect bool
+	}{
+		{path: "/tmp/payload", vid: VarFileInTemp, expect: true},
+		{path: "/var/tmp/dir/payload", vid: VarFileInTemp, expect: true},
+		{path: "/tmpdir/payload", vid: VarFileInTemp, expect: false},
+		{path: HomeDirs[0] + "/user/.bashrc", vid: VarFileInHome, expect: true},
+		{path: "/opt/app/config", vid: VarFileInHome, expect: false},
+		{path: "/usr/bin/ls", vid: VarFileInSystem, expect: true},
+		{path: "/etc/passwd", vid: VarFileInSystem, expect: true},
+		{path: "/tmp/usr/bin/ls", vid: VarFileInSystem, expect: false},
+	}
+	for _, tt := range tests {
+		sCtx := new(scanContextMock)
+		sCtx.On("FilePath").Return(tt.path)
+		got, err := Valuers[tt.vid].Value(sCtx)
+		require.NoError(t, err)
+		require.Equal(t, tt.expect, got, tt.path)
+	}
+}
+
+func TestFileAttributes(t *testing.T) {
+	_, info := writeFile(t, "attrs.txt", []byte("test"))
+	sCtx := new(scanContextMock)
+	sCtx.On("FileInfo").Return(info)
+
+	got, err := Valuers[VarFileAttributes].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
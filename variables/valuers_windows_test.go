@@ -0,0 +1,166 @@
+package variables_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/windows"
+
+	. "github.com/binalyze/gora/variables"
+)
+
+func TestProcessElevated(t *testing.T) {
+	sCtx := new(scanContextMock)
+	sCtx.On("Pid").Return(os.Getpid())
+	got, err := Valuers[VarProcessElevated].Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, windows.GetCurrentProcessToken().IsElevated(), got)
+
+	sCtx = new(scanContextMock)
+	sCtx.On("Pid").Return(0)
+	got, err = Valuers[VarProcessElevated].Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+func TestExtendedLengthPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		name    string
+		ext     string
+		dirName string
+	}{
+		{path: `\\?\C:\dir\file.exe`, name: "file.exe", ext: "exe", dirName: "dir"},
+		{path: `\\?\C:\file.exe`, name: "file.exe", ext: "exe", dirName: ""},
+		{path: `\\?\UNC\server\share\dir\file.tar.gz`, name: "file.tar.gz", ext: "gz", dirName: "dir"},
+		{path: `\\?\unc\server\share\file.txt`, name: "file.txt", ext: "txt", dirName: ""},
+		{path: `\\server\share\dir\file.txt`, name: "file.txt", ext: "txt", dirName: "dir"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			sCtx := new(scanContextMock)
+			sCtx.On("FilePath").Return(tt.path)
+
+			got, err := Valuers[VarFilePath].Value(sCtx)
+			require.NoError(t, err)
+			require.Equal(t, tt.path, got)
+
+			got, err = Valuers[VarFileName].Value(sCtx)
+			require.NoError(t, err)
+			require.Equal(t, tt.name, got)
+
+			got, err = Valuers[VarFileExtension].Value(sCtx)
+			require.NoError(t, err)
+			require.Equal(t, tt.ext, got)
+
+			got, err = Valuers[VarFileDirName].Value(sCtx)
+			require.NoError(t, err)
+			require.Equal(t, tt.dirName, got)
+		})
+	}
+}
+
+func TestFileInDirs_defaults(t *testing.T) {
+	tests := []struct {
+		path   string
+		vid    VariableType
+		expect bool
+	}{
+		{path: os.Getenv("TEMP") + `\payload.exe`, vid: VarFileInTemp, expect: true},
+		{path: os.Getenv("SystemRoot") + `\Temp\payload.exe`, vid: VarFileInTemp, expect: true},
+		{path: os.Getenv("USERPROFILE") + `\Desktop\file.docx`, vid: VarFileInHome, expect: true},
+		{path: `c:\users\public\file.docx`, vid: VarFileInHome, expect: true},
+		{path: `C:\Program Files\app\app.exe`, vid: VarFileInHome, expect: false},
+		{path: `C:\Windows\System32\kernel32.dll`, vid: VarFileInSystem, expect: true},
+		{path: `c:\windows\system32\kernel32.dll`, vid: VarFileInSystem, expect: true},
+		{path: `\\?\C:\Windows\System32\kernel32.dll`, vid: VarFileInSystem, expect: true},
+		{path: `C:\WindowsApps\app.exe`, vid: VarFileInSystem, expect: false},
+	}
+	for _, tt := range tests {
+		sCtx := new(scanContextMock)
+		sCtx.On("FilePath").Return(tt.path)
+		got, err := Valuers[tt.vid].Value(sCtx)
+		require.NoError(t, err)
+		require.Equal(t, tt.expect, got, tt.path)
+	}
+}
+
+func TestFileSigned(t *testing.T) {
+	VerifyFileSignatures = true
+	t.Cleanup(func() {
+		VerifyFileSignatures = false
+	})
+
+	// The kernel image always has an embedded signature, unlike many system binaries signed through catalogs.
+	signed := filepath.Join(os.Getenv("SystemRoot"), "System32", "ntoskrnl.exe")
+	unsigned, _ := writeFile(t, "unsigned.exe", []byte("MZ"))
+	tests := []struct {
+		path   string
+		signed interface{}
+		signer string
+	}{
+		{path: signed, signed: true, signer: "Microsoft"},
+		{path: unsigned, signed: false},
+	}
+	for _, tt := range tests {
+		info, err := os.Stat(tt.path)
+		require.NoError(t, err)
+		sCtx := new(scanContextMock)
+		sCtx.On("Context").Return(context.Background())
+		sCtx.On("FilePath").Return(tt.path)
+		sCtx.On("FileInfo").Return(info)
+
+		got, err := Valuers[VarFileSigned].Value(sCtx)
+		require.NoError(t, err)
+		require.Equal(t, tt.signed, got, tt.path)
+
+		got, err = Valuers[VarFileSigner].Value(sCtx)
+		require.NoError(t, err)
+		if tt.signer == "" {
+			require.Nil(t, got, tt.path)
+		} else {
+			require.Contains(t, got, tt.signer, tt.path)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	info, err := os.Stat(signed)
+	require.NoError(t, err)
+	sCtx := new(scanContextMock)
+	sCtx.On("Context").Return(ctx)
+	sCtx.On("FilePath").Return(signed)
+	sCtx.On("FileInfo").Return(info)
+	_, err = Valuers[VarFileSigned].Value(sCtx)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestFileAttributes(t *testing.T) {
+	path, _ := writeFile(t, "attrs.txt", []byte("test"))
+	p, err := windows.UTF16PtrFromString(path)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		// The read-only file can not be removed by the cleanup of the temporary directory.
+		_ = windows.SetFileAttributes(p, windows.FILE_ATTRIBUTE_NORMAL)
+	})
+
+	tests := []uint32{
+		windows.FILE_ATTRIBUTE_NORMAL,
+		windows.FILE_ATTRIBUTE_HIDDEN | windows.FILE_ATTRIBUTE_SYSTEM,
+		windows.FILE_ATTRIBUTE_READONLY | windows.FILE_ATTRIBUTE_HIDDEN | windows.FILE_ATTRIBUTE_ARCHIVE,
+	}
+	for _, attrs := range tests {
+		require.NoError(t, windows.SetFileAttributes(p, attrs))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		sCtx := new(scanContextMock)
+		sCtx.On("FileInfo").Return(info)
+
+		got, err := Valuers[VarFileAttributes].Value(sCtx)
+		require.NoError(t, err)
+		require.Equal(t, int64(attrs), got)
+	}
+}
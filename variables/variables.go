@@ -1,14 +1,33 @@
 package variables
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
 	"io/fs"
+	"math"
+	"mime"
+	"net/http"
+	"os"
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/djherbis/times"
@@ -19,9 +38,37 @@ type (
 	// Variables holds the list of applicable variables to define external variables for yara compiler and scanner, and
 	// it provides methods to set values for the yara compiler and scanner.
 	Variables struct {
-		list []VariableType
+		list      []VariableType
+		filtered  []VariableType // variables filtered by the meta mask of the last Init call.
+		errLogger ErrorLogger
+		observer  ValueObserver
+		defaults  DefaultsObserver
+		constants map[VariableType]interface{} // values cached by CacheConstants, it is not modified once created.
+		hashAlg   string                       // HashAlgorithm at the last Init call.
+		recover   bool
+		maxBytes  int64    // limit of the bytes read by the content derived variables, zero means no limit.
+		allowlist []string // path allowlist set by SetPathAllowlist, nil means PathAllowlist.
+		scanID    string   // scan session id of scan_id variable, generated by the first Init call if not set.
 	}
 
+	// ErrorLogger is called with the variable and the error returned from its Valuer, to record the value errors
+	// separately from ScanContext.HandleValueError which decides whether to abort.
+	ErrorLogger func(VariableType, error)
+
+	// PathNormalizationFlags represents the normalizations applied to the paths of file and process variables.
+	PathNormalizationFlags uint8
+
+	// SamplingMode represents how the file content is sampled by file_entropy. See EntropySampling.
+	SamplingMode uint8
+
+	// ValueObserver is called with the variable, the duration of its Valuer's Value call and the returned error, e.g.
+	// to measure the cost of the variables.
+	ValueObserver func(v VariableType, dur time.Duration, err error)
+
+	// DefaultsObserver is called with the variables which are defined using their default values since their Valuers
+	// return an error or nil, e.g. to detect the misconfigurations causing a high rate of default values.
+	DefaultsObserver func(defaulted []VariableType)
+
 	ProcessInfo interface {
 		Ppid() (int32, error)
 		Username() (string, error)
@@ -29,6 +76,31 @@ type (
 		CmdlineWithContext(context.Context) (string, error)
 	}
 
+	// ProcessInfoFactory creates the ProcessInfo of the process having the given pid, e.g. for each process scanned by a
+	// worker of a parallel scan.
+	ProcessInfoFactory func(pid int) (ProcessInfo, error)
+
+	// ProcessOpenFilesCounter is an optional interface of a ProcessInfo to provide the number of open files of the
+	// process for process_open_files variable. gopsutil's process.Process is supported through its NumFDsWithContext
+	// method as well.
+	ProcessOpenFilesCounter interface {
+		OpenFilesCountWithContext(context.Context) (int, error)
+	}
+
+	// ProcessExecutable is an optional interface of a ProcessInfo to provide the path of the process executable, which
+	// NewProcessScanContext sets as the file path for process_path variable. gopsutil's process.Process implements it.
+	ProcessExecutable interface {
+		ExeWithContext(context.Context) (string, error)
+	}
+
+	// ContentReaderProvider is an optional interface of a ScanContext to provide the scanned content when it is not at
+	// the file path, e.g. in memory or behind a virtual file system. The content derived variables, such as file_hash
+	// and file_entropy, read it instead of opening the file path, unless it returns ErrNoContentReader. The returned
+	// reader is closed by the caller and it is requested again by each variable reading the content.
+	ContentReaderProvider interface {
+		ContentReader() (io.ReadCloser, error)
+	}
+
 	// ScanContext is an interface that wraps the methods required to calculate variable values for yara scanner.
 	ScanContext interface {
 		Context() context.Context
@@ -67,33 +139,63 @@ type (
 
 const (
 	_ VariableType = iota
-	//                       | Name                 | OS  | Type    | Default | Description                                                   |
-	//                       |----------------------|-----|---------|---------|---------------------------------------------------------------|
-	VarOs                 // | os                   | LWD | String  | ""      | Operating system name, linux, windows or darwin |
-	VarOsLinux            // | os_linux             | LWD | Boolean | false   | If operating system is linux, its value is true |
-	VarOsWindows          // | os_windows           | LWD | Boolean | false   | If operating system is Windows, its value is true |
-	VarOsDarwin           // | os_darwin            | LWD | Boolean | false   | If operating system is Darwin/macOS, its value is true |
-	VarTimeNow            // | time_now             | LWD | Integer | 0       | Current time in YYYYMMDDHHMMSS format |
-	VarFilePath           // | file_path            | LWD | String  | ""      | Path of the file |
-	VarFileName           // | file_name            | LWD | String  | ""      | Name of the file including extension. Example: document.docx |
-	VarFileExtension      // | file_extension       | LWD | String  | ""      | Extension of the file without leading dot. Example: docx |
-	VarFileReadonly       // | file_readonly        | LWD | Boolean | false   | If it is a readonly file, its value is true |
-	VarFileHidden         // | file_hidden          | LWD | Boolean | false   | If it is a hidden file, its value is true |
-	VarFileSystem         // | file_system          |  W  | Boolean | false   | If it is a system file, its value is true |
-	VarFileCompressed     // | file_compressed      |  W  | Boolean | false   | If it is a compressed file, its value is true |
-	VarFileEncrypted      // | file_encrypted       |  W  | Boolean | false   | If it is an encrypted file, its value is true |
-	VarFileModifiedTime   // | file_modified_time   | LWD | Integer | 0       | File's modification time in YYYYMMDDHHMMSS format |
-	VarFileAccessedTime   // | file_accessed_time   | LWD | Integer | 0       | File's access time in YYYYMMDDHHMMSS format |
-	VarFileChangedTime    // | file_changed_time    | L D | Integer | 0       | File's change time in YYYYMMDDHHMMSS format |
-	VarFileBirthTime      // | file_birth_time      |  WD | Integer | 0       | File's birth time in YYYYMMDDHHMMSS format |
-	VarProcessId          // | process_id           | LWD | Integer | 0	      | Process's id |
-	VarProcessParentId    // | process_parent_id    | LWD | Integer | 0       | Parent process id |
-	VarProcessUserName    // | process_user_name    | LWD | String  | ""      | Process's user name. Windows format: <computer name or domain name>\<user name> |
-	VarProcessUserSid     // | process_user_sid     | LWD | String  | ""      | Process's user SID. This returns UID of the user as string on Unixes. |
-	VarProcessSessionId   // | process_session_id   | LWD | Integer | 0       | Process's session id |
-	VarProcessName        // | process_name         | LWD | String  | ""      | Process's name |
-	VarProcessPath        // | process_path         | LWD | String  | ""      | Process's path |
-	VarProcessCommandLine // | process_command_line | LWD | String  | ""      | Process's command line |
+	//                         | Name                   | OS  | Type    | Default | Description                                                   |
+	//                         |------------------------|-----|---------|---------|---------------------------------------------------------------|
+	VarOs                   // | os                     | LWD | String  | ""      | Operating system name, linux, windows or darwin |
+	VarOsLinux              // | os_linux               | LWD | Boolean | false   | If operating system is linux, its value is true |
+	VarOsWindows            // | os_windows             | LWD | Boolean | false   | If operating system is Windows, its value is true |
+	VarOsDarwin             // | os_darwin              | LWD | Boolean | false   | If operating system is Darwin/macOS, its value is true |
+	VarTimeNow              // | time_now               | LWD | Integer | 0       | Current time in YYYYMMDDHHMMSS format |
+	VarFilePath             // | file_path              | LWD | String  | ""      | Path of the file |
+	VarFileName             // | file_name              | LWD | String  | ""      | Name of the file including extension. Example: document.docx |
+	VarFileExtension        // | file_extension         | LWD | String  | ""      | Extension of the file without leading dot. Example: docx |
+	VarFileReadonly         // | file_readonly          | LWD | Boolean | false   | If it is a readonly file, its value is true |
+	VarFileHidden           // | file_hidden            | LWD | Boolean | false   | If it is a hidden file, its value is true |
+	VarFileSystem           // | file_system            |  W  | Boolean | false   | If it is a system file, its value is true |
+	VarFileCompressed       // | file_compressed        | LWD | Boolean | false   | If it is a compressed file, its value is true. See FS_COMPR_FL for Linux |
+	VarFileEncrypted        // | file_encrypted         | LW  | Boolean | false   | If it is an encrypted file, its value is true. See FS_ENCRYPT_FL for Linux |
+	VarFileModifiedTime     // | file_modified_time     | LWD | Integer | 0       | File's modification time in YYYYMMDDHHMMSS format |
+	VarFileAccessedTime     // | file_accessed_time     | LWD | Integer | 0       | File's access time in YYYYMMDDHHMMSS format |
+	VarFileChangedTime      // | file_changed_time      | L D | Integer | 0       | File's change time in YYYYMMDDHHMMSS format |
+	VarFileBirthTime        // | file_birth_time        |  WD | Integer | 0       | File's birth time in YYYYMMDDHHMMSS format |
+	VarProcessId            // | process_id             | LWD | Integer | 0	      | Process's id |
+	VarProcessParentId      // | process_parent_id      | LWD | Integer | 0       | Parent process id |
+	VarProcessUserName      // | process_user_name      | LWD | String  | ""      | Process's user name. Windows format: <computer name or domain name>\<user name> |
+	VarProcessUserSid       // | process_user_sid       | LWD | String  | ""      | Process's user SID. This returns UID of the user as string on Unixes. |
+	VarProcessSessionId     // | process_session_id     | LWD | Integer | 0       | Process's session id |
+	VarProcessName          // | process_name           | LWD | String  | ""      | Process's name |
+	VarProcessPath          // | process_path           | LWD | String  | ""      | Process's path |
+	VarProcessCommandLine   // | process_command_line   | LWD | String  | ""      | Process's command line |
+	VarFileHeaderHex        // | file_header_hex        | LWD | Bytes   | ""      | Hex encoded first FileHeaderSize bytes of the file. Example: 4d5a9000 |
+	VarFileMimeFromExt      // | file_mime_from_ext     | LWD | String  | ""      | MIME type of the file derived from its extension without parameters. Example: application/pdf |
+	VarProcessOpenFiles     // | process_open_files     | LWD | Integer | 0       | Number of process's open files. See ProcessOpenFilesCounter |
+	VarFileDirName          // | file_dir_name          | LWD | String  | ""      | Name of the file's parent directory. Example: Downloads |
+	VarFileExtCount         // | file_extension_count   | LWD | Integer | 0       | Number of extensions after the file's base name. Example: 2 for invoice.pdf.exe |
+	VarFileExecutable       // | file_is_executable     | LWD | Boolean | false   | If it is an executable file, its value is true. See ExecutableExtensions for Windows |
+	VarFileExists           // | file_exists            | LWD | Boolean | false   | If the file path currently exists on disk, its value is true |
+	VarFileIsSymlink        // | file_is_symlink        | LWD | Boolean | false   | If the file path is a symbolic link, its value is true |
+	VarFileSymlinkTarget    // | file_symlink_target    | LWD | String  | ""      | Target of the symbolic link without resolving it. Example: ../lib/libc.so |
+	VarScannerUser          // | scanner_user           | LWD | String  | ""      | User name of the scanner process, not the scanned process. See process_user_name |
+	VarFileHash             // | file_hash              | LWD | String  | ""      | Hex encoded hash of the file content using HashAlgorithm. Example: sha256 digest |
+	VarFileOwnerGid         // | file_owner_gid         | L D | Integer | 0       | Group id of the file's owner group |
+	VarFileOwnerGroupName   // | file_owner_group       | L D | String  | ""      | Name of the file's owner group. Example: wheel |
+	VarFileSizeBucket       // | file_size_bucket       | LWD | Integer | 0       | Index of the file size range defined by FileSizeBuckets. Example: 1 for 10KB |
+	VarFileExtCategory      // | file_ext_category      | LWD | String  | ""      | Category of the file extension defined by ExtensionCategories, or other. Example: archive |
+	VarFilePrintableRatio   // | file_printable_ratio   | LWD | Float   | 0       | Ratio of the printable ASCII bytes in the first MaxContentBytes bytes of the file. Example: 0.98 |
+	VarProcessElevated      // | process_elevated       | LWD | Boolean | false   | If the process runs as root or elevated by UAC on Windows, its value is true |
+	VarFileInTemp           // | file_in_temp           | LWD | Boolean | false   | If the file is under one of TempDirs, its value is true |
+	VarFileInHome           // | file_in_home           | LWD | Boolean | false   | If the file is under one of HomeDirs, its value is true |
+	VarFileInSystem         // | file_in_system         | LWD | Boolean | false   | If the file is under one of SystemDirs, its value is true |
+	VarFileMimeTop          // | file_mime_top          | LWD | String  | ""      | Top-level MIME type detected from the first 512 bytes of the file content. Example: application |
+	VarFileEntropy          // | file_entropy           | LWD | Float   | 0       | Shannon entropy in bits per byte of the file content sampled according to EntropySampling. Example: 7.99 |
+	VarProcessCmdlineLength // | process_cmdline_length | LWD | Integer | 0       | Length of the process's command line in bytes |
+	VarProcessCmdlineSha256 // | process_cmdline_sha256 | LWD | String  | ""      | Hex encoded SHA-256 digest of the process's command line |
+	VarFilePathAllowlisted  // | file_path_allowlisted  | LWD | Boolean | false   | If the file path or any of its parent directories matches a glob pattern of the path allowlist, its value is true. See PathAllowlist |
+	VarFileSigned           // | file_signed            | WD  | Boolean | false   | If the file has a valid code signature, its value is true. See VerifyFileSignatures |
+	VarFileSigner           // | file_signer            | WD  | String  | ""      | Subject name of the file's signing certificate. See VerifyFileSignatures |
+	VarFileAttributes       // | file_attributes        | W   | Integer | 0       | Windows file attribute bitmask, e.g. 1 readonly, 2 hidden, 4 system, 32 archive, 2048 compressed, 16384 encrypted. See FILE_ATTRIBUTE_* constants |
+	VarScanId               // | scan_id                | LWD | String  | ""      | Identifier of the scan session which is the same for all the scans of the Variables instance and its copies. See SetScanId |
+	VarFileFirstLine        // | file_first_line        | LWD | String  | ""      | First line of the file up to FirstLineMaxLength bytes without the line ending. Example: #!/bin/bash |
 	typeEnd
 )
 
@@ -105,104 +207,322 @@ const (
 	MetaString
 	MetaFile
 	MetaProcess
+	// MetaBytes is the type of the variables holding binary data. Since YARA external variables can not hold raw bytes,
+	// they are defined as lower case hex encoded strings. Their Valuer implementations may return either []byte or the
+	// hex encoded string.
+	MetaBytes
 	MetaFileProcess = MetaFile | MetaProcess
 )
 
 var (
 	// varNames holds the string names of variables.
 	varNames = [typeEnd]string{
-		VarOs:                 "os",
-		VarOsLinux:            "os_linux",
-		VarOsWindows:          "os_windows",
-		VarOsDarwin:           "os_darwin",
-		VarTimeNow:            "time_now",
-		VarFilePath:           "file_path",
-		VarFileName:           "file_name",
-		VarFileExtension:      "file_extension",
-		VarFileReadonly:       "file_readonly",
-		VarFileHidden:         "file_hidden",
-		VarFileSystem:         "file_system",
-		VarFileCompressed:     "file_compressed",
-		VarFileEncrypted:      "file_encrypted",
-		VarFileModifiedTime:   "file_modified_time",
-		VarFileAccessedTime:   "file_accessed_time",
-		VarFileChangedTime:    "file_changed_time",
-		VarFileBirthTime:      "file_birth_time",
-		VarProcessId:          "process_id",
-		VarProcessParentId:    "process_parent_id",
-		VarProcessUserName:    "process_user_name",
-		VarProcessUserSid:     "process_user_sid",
-		VarProcessSessionId:   "process_session_id",
-		VarProcessName:        "process_name",
-		VarProcessPath:        "process_path",
-		VarProcessCommandLine: "process_command_line",
+		VarOs:                   "os",
+		VarOsLinux:              "os_linux",
+		VarOsWindows:            "os_windows",
+		VarOsDarwin:             "os_darwin",
+		VarTimeNow:              "time_now",
+		VarFilePath:             "file_path",
+		VarFileName:             "file_name",
+		VarFileExtension:        "file_extension",
+		VarFileReadonly:         "file_readonly",
+		VarFileHidden:           "file_hidden",
+		VarFileSystem:           "file_system",
+		VarFileCompressed:       "file_compressed",
+		VarFileEncrypted:        "file_encrypted",
+		VarFileModifiedTime:     "file_modified_time",
+		VarFileAccessedTime:     "file_accessed_time",
+		VarFileChangedTime:      "file_changed_time",
+		VarFileBirthTime:        "file_birth_time",
+		VarProcessId:            "process_id",
+		VarProcessParentId:      "process_parent_id",
+		VarProcessUserName:      "process_user_name",
+		VarProcessUserSid:       "process_user_sid",
+		VarProcessSessionId:     "process_session_id",
+		VarProcessName:          "process_name",
+		VarProcessPath:          "process_path",
+		VarProcessCommandLine:   "process_command_line",
+		VarFileHeaderHex:        "file_header_hex",
+		VarFileMimeFromExt:      "file_mime_from_ext",
+		VarProcessOpenFiles:     "process_open_files",
+		VarFileDirName:          "file_dir_name",
+		VarFileExtCount:         "file_extension_count",
+		VarFileExecutable:       "file_is_executable",
+		VarFileExists:           "file_exists",
+		VarFileIsSymlink:        "file_is_symlink",
+		VarFileSymlinkTarget:    "file_symlink_target",
+		VarScannerUser:          "scanner_user",
+		VarFileHash:             "file_hash",
+		VarFileOwnerGid:         "file_owner_gid",
+		VarFileOwnerGroupName:   "file_owner_group",
+		VarFileSizeBucket:       "file_size_bucket",
+		VarFileExtCategory:      "file_ext_category",
+		VarFilePrintableRatio:   "file_printable_ratio",
+		VarProcessElevated:      "process_elevated",
+		VarFileInTemp:           "file_in_temp",
+		VarFileInHome:           "file_in_home",
+		VarFileInSystem:         "file_in_system",
+		VarFileMimeTop:          "file_mime_top",
+		VarFileEntropy:          "file_entropy",
+		VarProcessCmdlineLength: "process_cmdline_length",
+		VarProcessCmdlineSha256: "process_cmdline_sha256",
+		VarFilePathAllowlisted:  "file_path_allowlisted",
+		VarFileSigned:           "file_signed",
+		VarFileSigner:           "file_signer",
+		VarFileAttributes:       "file_attributes",
+		VarScanId:               "scan_id",
+		VarFileFirstLine:        "file_first_line",
 	}
 
 	// varMetas holds the metadata of all variables.
 	varMetas = [typeEnd]MetaType{
-		VarOs:                 MetaFileProcess | MetaString,
-		VarOsLinux:            MetaFileProcess | MetaBool,
-		VarOsWindows:          MetaFileProcess | MetaBool,
-		VarOsDarwin:           MetaFileProcess | MetaBool,
-		VarTimeNow:            MetaFileProcess | MetaInt,
-		VarFilePath:           MetaFileProcess | MetaString,
-		VarFileName:           MetaFileProcess | MetaString,
-		VarFileExtension:      MetaFileProcess | MetaString,
-		VarFileReadonly:       MetaFileProcess | MetaBool,
-		VarFileHidden:         MetaFileProcess | MetaBool,
-		VarFileSystem:         MetaFileProcess | MetaBool,
-		VarFileCompressed:     MetaFileProcess | MetaBool,
-		VarFileEncrypted:      MetaFileProcess | MetaBool,
-		VarFileModifiedTime:   MetaFileProcess | MetaInt,
-		VarFileAccessedTime:   MetaFileProcess | MetaInt,
-		VarFileChangedTime:    MetaFileProcess | MetaInt,
-		VarFileBirthTime:      MetaFileProcess | MetaInt,
-		VarProcessId:          MetaProcess | MetaInt,
-		VarProcessParentId:    MetaProcess | MetaInt,
-		VarProcessUserName:    MetaProcess | MetaString,
-		VarProcessUserSid:     MetaProcess | MetaString,
-		VarProcessSessionId:   MetaProcess | MetaInt,
-		VarProcessName:        MetaProcess | MetaString,
-		VarProcessPath:        MetaProcess | MetaString,
-		VarProcessCommandLine: MetaProcess | MetaString,
+		VarOs:                   MetaFileProcess | MetaString,
+		VarOsLinux:              MetaFileProcess | MetaBool,
+		VarOsWindows:            MetaFileProcess | MetaBool,
+		VarOsDarwin:             MetaFileProcess | MetaBool,
+		VarTimeNow:              MetaFileProcess | MetaInt,
+		VarFilePath:             MetaFileProcess | MetaString,
+		VarFileName:             MetaFileProcess | MetaString,
+		VarFileExtension:        MetaFileProcess | MetaString,
+		VarFileReadonly:         MetaFileProcess | MetaBool,
+		VarFileHidden:           MetaFileProcess | MetaBool,
+		VarFileSystem:           MetaFileProcess | MetaBool,
+		VarFileCompressed:       MetaFileProcess | MetaBool,
+		VarFileEncrypted:        MetaFileProcess | MetaBool,
+		VarFileModifiedTime:     MetaFileProcess | MetaInt,
+		VarFileAccessedTime:     MetaFileProcess | MetaInt,
+		VarFileChangedTime:      MetaFileProcess | MetaInt,
+		VarFileBirthTime:        MetaFileProcess | MetaInt,
+		VarProcessId:            MetaProcess | MetaInt,
+		VarProcessParentId:      MetaProcess | MetaInt,
+		VarProcessUserName:      MetaProcess | MetaString,
+		VarProcessUserSid:       MetaProcess | MetaString,
+		VarProcessSessionId:     MetaProcess | MetaInt,
+		VarProcessName:          MetaProcess | MetaString,
+		VarProcessPath:          MetaProcess | MetaString,
+		VarProcessCommandLine:   MetaProcess | MetaString,
+		VarFileHeaderHex:        MetaFileProcess | MetaBytes,
+		VarFileMimeFromExt:      MetaFileProcess | MetaString,
+		VarProcessOpenFiles:     MetaProcess | MetaInt,
+		VarFileDirName:          MetaFileProcess | MetaString,
+		VarFileExtCount:         MetaFileProcess | MetaInt,
+		VarFileExecutable:       MetaFileProcess | MetaBool,
+		VarFileExists:           MetaFileProcess | MetaBool,
+		VarFileIsSymlink:        MetaFileProcess | MetaBool,
+		VarFileSymlinkTarget:    MetaFileProcess | MetaString,
+		VarScannerUser:          MetaFileProcess | MetaString,
+		VarFileHash:             MetaFileProcess | MetaString,
+		VarFileOwnerGid:         MetaFileProcess | MetaInt,
+		VarFileOwnerGroupName:   MetaFileProcess | MetaString,
+		VarFileSizeBucket:       MetaFileProcess | MetaInt,
+		VarFileExtCategory:      MetaFileProcess | MetaString,
+		VarFilePrintableRatio:   MetaFileProcess | MetaFloat,
+		VarProcessElevated:      MetaProcess | MetaBool,
+		VarFileInTemp:           MetaFileProcess | MetaBool,
+		VarFileInHome:           MetaFileProcess | MetaBool,
+		VarFileInSystem:         MetaFileProcess | MetaBool,
+		VarFileMimeTop:          MetaFileProcess | MetaString,
+		VarFileEntropy:          MetaFileProcess | MetaFloat,
+		VarProcessCmdlineLength: MetaProcess | MetaInt,
+		VarProcessCmdlineSha256: MetaProcess | MetaString,
+		VarFilePathAllowlisted:  MetaFileProcess | MetaBool,
+		VarFileSigned:           MetaFileProcess | MetaBool,
+		VarFileSigner:           MetaFileProcess | MetaString,
+		VarFileAttributes:       MetaFileProcess | MetaInt,
+		VarScanId:               MetaFileProcess | MetaString,
+		VarFileFirstLine:        MetaFileProcess | MetaString,
 	}
 
 	// Valuers holds the Valuer implementations of all variables.
 	Valuers = [typeEnd]Valuer{
-		VarOs:                 ValueFunc(varOsFunc),
-		VarOsLinux:            ValueFunc(varOsLinuxFunc),
-		VarOsWindows:          ValueFunc(varOsWindowsFunc),
-		VarOsDarwin:           ValueFunc(varOsDarwinFunc),
-		VarTimeNow:            ValueFunc(varTimeNowFunc),
-		VarFilePath:           ValueFunc(varFilePathFunc),
-		VarFileName:           ValueFunc(varFileNameFunc),
-		VarFileExtension:      ValueFunc(varFileExtensionFunc),
-		VarFileReadonly:       ValueFunc(varFileReadonlyFunc),
-		VarFileHidden:         ValueFunc(varFileHiddenFunc),
-		VarFileSystem:         ValueFunc(varFileSystemFunc),
-		VarFileCompressed:     ValueFunc(varFileCompressedFunc),
-		VarFileEncrypted:      ValueFunc(varFileEncryptedFunc),
-		VarFileModifiedTime:   ValueFunc(varFileModifiedTimeFunc),
-		VarFileAccessedTime:   ValueFunc(varFileAccessedTimeFunc),
-		VarFileChangedTime:    ValueFunc(varFileChangedTimeFunc),
-		VarFileBirthTime:      ValueFunc(varFileBirthTimeFunc),
-		VarProcessId:          ValueFunc(varProcessIdFunc),
-		VarProcessParentId:    ValueFunc(varProcessParentIdFunc),
-		VarProcessUserName:    ValueFunc(varProcessUserNameFunc),
-		VarProcessUserSid:     ValueFunc(varProcessUserSidFunc),
-		VarProcessSessionId:   ValueFunc(varProcessSessionIdFunc),
-		VarProcessName:        ValueFunc(varProcessNameFunc),
-		VarProcessPath:        ValueFunc(varFilePathFunc), // FilePath holds the process's path as well.
-		VarProcessCommandLine: ValueFunc(varProcessCommandLineFunc),
+		VarOs:                   ValueFunc(varOsFunc),
+		VarOsLinux:              ValueFunc(varOsLinuxFunc),
+		VarOsWindows:            ValueFunc(varOsWindowsFunc),
+		VarOsDarwin:             ValueFunc(varOsDarwinFunc),
+		VarTimeNow:              ValueFunc(varTimeNowFunc),
+		VarFilePath:             ValueFunc(varFilePathFunc),
+		VarFileName:             ValueFunc(varFileNameFunc),
+		VarFileExtension:        ValueFunc(varFileExtensionFunc),
+		VarFileReadonly:         ValueFunc(varFileReadonlyFunc),
+		VarFileHidden:           ValueFunc(varFileHiddenFunc),
+		VarFileSystem:           ValueFunc(varFileSystemFunc),
+		VarFileCompressed:       ValueFunc(varFileCompressedFunc),
+		VarFileEncrypted:        ValueFunc(varFileEncryptedFunc),
+		VarFileModifiedTime:     ValueFunc(varFileModifiedTimeFunc),
+		VarFileAccessedTime:     ValueFunc(varFileAccessedTimeFunc),
+		VarFileChangedTime:      ValueFunc(varFileChangedTimeFunc),
+		VarFileBirthTime:        ValueFunc(varFileBirthTimeFunc),
+		VarProcessId:            ValueFunc(varProcessIdFunc),
+		VarProcessParentId:      ValueFunc(varProcessParentIdFunc),
+		VarProcessUserName:      ValueFunc(varProcessUserNameFunc),
+		VarProcessUserSid:       ValueFunc(varProcessUserSidFunc),
+		VarProcessSessionId:     ValueFunc(varProcessSessionIdFunc),
+		VarProcessName:          ValueFunc(varProcessNameFunc),
+		VarProcessPath:          ValueFunc(varFilePathFunc), // FilePath holds the process's path as well.
+		VarProcessCommandLine:   ValueFunc(varProcessCommandLineFunc),
+		VarFileHeaderHex:        ValueFunc(varFileHeaderHexFunc),
+		VarFileMimeFromExt:      ValueFunc(varFileMimeFromExtFunc),
+		VarProcessOpenFiles:     ValueFunc(varProcessOpenFilesFunc),
+		VarFileDirName:          ValueFunc(varFileDirNameFunc),
+		VarFileExtCount:         ValueFunc(varFileExtCountFunc),
+		VarFileExecutable:       ValueFunc(varFileExecutableFunc),
+		VarFileExists:           ValueFunc(varFileExistsFunc),
+		VarFileIsSymlink:        ValueFunc(varFileIsSymlinkFunc),
+		VarFileSymlinkTarget:    ValueFunc(varFileSymlinkTargetFunc),
+		VarScannerUser:          ValueFunc(varScannerUserFunc),
+		VarFileHash:             ValueFunc(varFileHashFunc),
+		VarFileOwnerGid:         ValueFunc(varFileOwnerGidFunc),
+		VarFileOwnerGroupName:   ValueFunc(varFileOwnerGroupNameFunc),
+		VarFileSizeBucket:       ValueFunc(varFileSizeBucketFunc),
+		VarFileExtCategory:      ValueFunc(varFileExtCategoryFunc),
+		VarFilePrintableRatio:   ValueFunc(varFilePrintableRatioFunc),
+		VarProcessElevated:      ValueFunc(varProcessElevatedFunc),
+		VarFileInTemp:           ValueFunc(varFileInTempFunc),
+		VarFileInHome:           ValueFunc(varFileInHomeFunc),
+		VarFileInSystem:         ValueFunc(varFileInSystemFunc),
+		VarFileMimeTop:          ValueFunc(varFileMimeTopFunc),
+		VarFileEntropy:          ValueFunc(varFileEntropyFunc),
+		VarProcessCmdlineLength: ValueFunc(varProcessCmdlineLengthFunc),
+		VarProcessCmdlineSha256: ValueFunc(varProcessCmdlineSha256Func),
+		VarFilePathAllowlisted:  ValueFunc(varFilePathAllowlistedFunc),
+		VarFileSigned:           ValueFunc(varFileSignedFunc),
+		VarFileSigner:           ValueFunc(varFileSignerFunc),
+		VarFileAttributes:       ValueFunc(varFileAttributesFunc),
+		VarScanId:               ValueFunc(noopVarFunc),
+		VarFileFirstLine:        ValueFunc(varFileFirstLineFunc),
+	}
+
+	// FileHeaderSize is the number of leading bytes of the file used by the file_header_hex variable.
+	FileHeaderSize = 16
+
+	// FirstLineMaxLength is the maximum number of bytes of the file_first_line variable. Only as many bytes are read, so
+	// a file without a line ending in its first bytes, e.g. a binary file, gets its leading bytes truncated.
+	FirstLineMaxLength = 256
+
+	// MaxContentBytes is the number of leading bytes of the file sampled by the content statistics variables, such as
+	// file_printable_ratio, to bound their cost for large files.
+	MaxContentBytes int64 = 1 << 20
+
+	// EntropySampling is how file_entropy samples the content of the files larger than MaxContentBytes. The sampled
+	// chunks are EntropyChunkSize bytes long and their total size is at most MaxContentBytes. The content which can not
+	// be seeked is always sampled using SamplePrefix.
+	EntropySampling  = SamplePrefix
+	EntropyChunkSize = int64(64 << 10)
+
+	// ProcessOpenFiles enables the process_open_files variable. Counting the open files requires enumerating the
+	// process's file descriptors or handles which may be costly for the processes having many of them. If it is
+	// disabled, the variable is always defined with its default value.
+	ProcessOpenFiles = true
+
+	// VerifyFileSignatures enables the file_signed and file_signer variables. Verifying the code signature of a file
+	// requires reading and hashing the whole file, and may run the codesign tool on macOS. If it is disabled, which is
+	// the default, the variables are always defined with their default values. They are not supported on Linux.
+	VerifyFileSignatures = false
+
+	// ExecutableExtensions is the list of file extensions that file_is_executable variable considers executable on
+	// Windows, where the file permissions do not have an execute bit. Extensions are compared case-insensitively.
+	ExecutableExtensions = []string{
+		".exe", ".dll", ".sys", ".com", ".bat", ".cmd", ".ps1", ".vbs", ".vbe", ".js", ".jse", ".wsf", ".wsh", ".msi",
+		".scr", ".cpl", ".hta", ".pif",
+	}
+
+	// PathAllowlist holds the glob patterns of the file_path_allowlisted variable, e.g. trusted vendor directories. The
+	// patterns use the syntax of filepath.Match and a file is allowlisted if its cleaned path or any of its parent
+	// directories matches a pattern, compared case-insensitively on Windows. It is used by the Variables instances
+	// unless their own allowlist is set by SetPathAllowlist.
+	PathAllowlist []string
+
+	// HashAlgorithm is the algorithm used by the file_hash variable, one of sha256, sha1, md5 or sha512. It is read by
+	// the Init methods, so changing it does not affect the already initialized Variables instances.
+	HashAlgorithm = "sha256"
+
+	// FileSizeBuckets holds the ascending size thresholds of the file_size_bucket variable. A file gets the index of the
+	// first threshold greater than its size, or the number of thresholds if there is none. By default, the buckets are
+	// 0 for less than 4KB, 1 for less than 1MB, 2 for less than 100MB and 3 for larger files.
+	FileSizeBuckets = []int64{4 << 10, 1 << 20, 100 << 20}
+
+	// ExtensionCategories maps the file extensions to the categories of the file_ext_category variable. The extensions
+	// are in lower case with a leading dot and compared case-insensitively. The files having an extension missing in the
+	// map get the "other" category.
+	ExtensionCategories = map[string]string{
+		".7z": "archive", ".bz2": "archive", ".cab": "archive", ".gz": "archive", ".iso": "archive", ".rar": "archive",
+		".tar": "archive", ".tgz": "archive", ".xz": "archive", ".zip": "archive",
+
+		".app": "executable", ".bin": "executable", ".com": "executable", ".dll": "executable", ".dylib": "executable",
+		".elf": "executable", ".exe": "executable", ".msi": "executable", ".scr": "executable", ".so": "executable",
+		".sys": "executable",
+
+		".csv": "document", ".doc": "document", ".docm": "document", ".docx": "document", ".odt": "document",
+		".pdf": "document", ".ppt": "document", ".pptx": "document", ".rtf": "document", ".txt": "document",
+		".xls": "document", ".xlsm": "document", ".xlsx": "document",
+
+		".bat": "script", ".cmd": "script", ".hta": "script", ".js": "script", ".jse": "script", ".ps1": "script",
+		".py": "script", ".sh": "script", ".vbe": "script", ".vbs": "script", ".wsf": "script",
+
+		".bmp": "image", ".gif": "image", ".ico": "image", ".jpeg": "image", ".jpg": "image", ".png": "image",
+		".svg": "image", ".tif": "image", ".tiff": "image", ".webp": "image",
+	}
+
+	// HeavyVars is the list of the variables which are expensive to calculate since they read the file content. They are
+	// defined using their default values by DefineLightScannerVariables.
+	HeavyVars = []VariableType{
+		VarFileHeaderHex, VarFileHash, VarFilePrintableRatio, VarFileMimeTop, VarFileEntropy, VarFileSigned, VarFileSigner,
+		VarFileFirstLine,
 	}
+
+	// TempDirs, HomeDirs and SystemDirs are the directories checked by the file_in_temp, file_in_home and file_in_system
+	// variables respectively. A file is in a directory if its cleaned path is under the directory, compared
+	// case-insensitively on Windows. The defaults are the well-known directories of the OS, including the ones set by
+	// the environment variables such as TMPDIR, HOME, TEMP and SystemRoot.
+	TempDirs   = defaultTempDirs()
+	HomeDirs   = defaultHomeDirs()
+	SystemDirs = defaultSystemDirs()
+
+	// PathNormalization is the normalizations applied to the file_path and process_path variables, and to the variables
+	// derived from them, e.g. file_name and file_extension. By default, the paths are only cleaned.
+	PathNormalization PathNormalizationFlags
+
+	// FileTimes returns the times of the given file info to be used by the file time variables. It can be replaced to
+	// supply the file times from another source, e.g. where times package can not extract birth or change time.
+	FileTimes = defaultFileTimes
 )
 
 const intFileTimeLayout = "20060102150405"
 
+// hashAlgorithms holds the supported algorithms of the file_hash variable.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+	"sha512": sha512.New,
+}
+
+// constantVars is the list of variables whose values do not change during the process lifetime. See CacheConstants.
+var constantVars = []VariableType{VarOs, VarOsLinux, VarOsWindows, VarOsDarwin, VarScannerUser}
+
+const (
+	// PathLowerCase converts the paths to lower case.
+	PathLowerCase PathNormalizationFlags = 1 << iota
+	// PathForwardSlash replaces the path separators with forward slashes. It has no effect where the separator is
+	// already a forward slash.
+	PathForwardSlash
+)
+
+// Sampling modes.
+const (
+	// SamplePrefix reads the leading MaxContentBytes bytes of the file.
+	SamplePrefix SamplingMode = iota
+	// SampleEven reads the chunks at evenly spaced offsets from the beginning to the end of the file.
+	SampleEven
+	// SampleExponential reads the chunks at the exponentially growing offsets, i.e. 0, 1, 2, 4, 8... chunks, and the last
+	// chunk of the file, so the beginning of the file is sampled more densely.
+	SampleExponential
+)
+
 // List returns the list of all available variables. It creates a new slice at every call.
 func List() []VariableType {
 	list := make([]VariableType, 0, len(varNames)-1)
-	for v := range varNames[1:] {
+	for v := 1; v < len(varNames); v++ {
 		list = append(list, VariableType(v))
 	}
 	return list
@@ -213,6 +533,20 @@ func (fn ValueFunc) Value(sCtx ScanContext) (interface{}, error) {
 	return fn(sCtx)
 }
 
+// Derive returns a Valuer which calculates its value by applying transform to the value of base, e.g. a custom variable
+// can be derived from Valuers[VarFilePath]. The transform is not called if base returns an error or a nil value, which
+// stands for the default value of the variable, and they are returned as is. The base is called once per Value call,
+// so it should be a caching Valuer to share its value with the other variables deriving from it.
+func Derive(base Valuer, transform func(interface{}) (interface{}, error)) Valuer {
+	return ValueFunc(func(sCtx ScanContext) (interface{}, error) {
+		v, err := base.Value(sCtx)
+		if err != nil || v == nil {
+			return v, err
+		}
+		return transform(v)
+	})
+}
+
 // String implements the fmt.Stringer interface and returns the string representation of a VariableType.
 func (v VariableType) String() string {
 	if v < typeEnd {
@@ -221,6 +555,16 @@ func (v VariableType) String() string {
 	return ""
 }
 
+// ParseVariableType returns the VariableType of the given variable name. It returns an error if the name is unknown.
+func ParseVariableType(name string) (VariableType, error) {
+	for i, n := range varNames {
+		if i > 0 && n == name {
+			return VariableType(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown variable: %q", name)
+}
+
 // Meta returns the meta data of the variable.
 func (v VariableType) Meta() MetaType {
 	if v < typeEnd {
@@ -229,19 +573,71 @@ func (v VariableType) Meta() MetaType {
 	return 0
 }
 
+// IsFileApplicable reports whether the variable is applicable for file scan.
+func (v VariableType) IsFileApplicable() bool {
+	return v.Meta()&MetaFile != 0
+}
+
+// IsProcessApplicable reports whether the variable is applicable for process scan.
+func (v VariableType) IsProcessApplicable() bool {
+	return v.Meta()&MetaProcess != 0
+}
+
+// InitOption transforms the variables given to the Init methods before they are set, e.g. Without.
+type InitOption func(vars []VariableType) []VariableType
+
+// All returns the list of all available variables. It is the same as List, to be read as the argument of the Init
+// methods along with the options, e.g. InitFileVariables(All(), Without(VarFileHash)).
+func All() []VariableType {
+	return List()
+}
+
+// Without returns an InitOption which removes the given variables.
+func Without(vars ...VariableType) InitOption {
+	return func(list []VariableType) []VariableType {
+		filtered := make([]VariableType, 0, len(list))
+	next:
+		for _, vid := range list {
+			for _, v := range vars {
+				if vid == v {
+					continue next
+				}
+			}
+			filtered = append(filtered, vid)
+		}
+		return filtered
+	}
+}
+
 // InitFileVariables sets Variables instance's applicable variables. It filters the given variables if they are not
-// applicable for file scan. See metadata of the variable.
-func (vr *Variables) InitFileVariables(vars []VariableType) {
-	vr.setVariables(vars, MetaFile)
+// applicable for file scan. See metadata of the variable. The options are applied to the given variables in order.
+func (vr *Variables) InitFileVariables(vars []VariableType, opts ...InitOption) {
+	vr.setVariables(applyInitOptions(vars, opts), MetaFile)
 }
 
 // InitProcessVariables sets Variables instance's applicable variables. It filters the given variables if they are not
-// applicable for process scan. See metadata of the variable.
-func (vr *Variables) InitProcessVariables(vars []VariableType) {
-	vr.setVariables(vars, MetaProcess)
+// applicable for process scan. See metadata of the variable. The options are applied to the given variables in order.
+func (vr *Variables) InitProcessVariables(vars []VariableType, opts ...InitOption) {
+	vr.setVariables(applyInitOptions(vars, opts), MetaProcess)
+}
+
+// InitFileProcessVariables sets Variables instance's applicable variables. It filters the given variables if they are
+// applicable for neither file nor process scan. See metadata of the variable. The options are applied to the given
+// variables in order.
+func (vr *Variables) InitFileProcessVariables(vars []VariableType, opts ...InitOption) {
+	vr.setVariables(applyInitOptions(vars, opts), MetaFileProcess)
+}
+
+func applyInitOptions(vars []VariableType, opts []InitOption) []VariableType {
+	for _, opt := range opts {
+		vars = opt(vars)
+	}
+	return vars
 }
 
-// DefineCompilerVariables defines the already set variables to the given compiler using their default zero values.
+// DefineCompilerVariables defines the already set variables to the given compiler using their default zero values. The
+// variables are defined in the ascending order of their types regardless of the order they are given or parsed, so the
+// compiled rules are reproducible.
 func (vr *Variables) DefineCompilerVariables(compiler VariableDefiner) (err error) {
 	for _, vid := range vr.list {
 		err = defineDefaultValue(vid, compiler)
@@ -252,15 +648,89 @@ func (vr *Variables) DefineCompilerVariables(compiler VariableDefiner) (err erro
 	return
 }
 
+// DefaultsSnapshot returns the default values of the variables defined by DefineCompilerVariables by their names.
+func (vr *Variables) DefaultsSnapshot() map[string]interface{} {
+	values := make(snapshotDefiner, len(vr.list))
+	// The variables are known, so defining their defaults can not fail.
+	_ = vr.DefineCompilerVariables(values)
+	return values
+}
+
 // DefineScannerVariables defines the already set variables to the given scanner using their calculated values using
 // their Valuer implementations. Returning error from Valuer's Value method should be handled by the given
 // ScanContext.HandleValueError.
 func (vr *Variables) DefineScannerVariables(sCtx ScanContext, scanner VariableDefiner) error {
+	return vr.defineScannerVariables(sCtx, scanner, nil)
+}
+
+// DefineLightScannerVariables defines the variables as DefineScannerVariables does, except the ones in HeavyVars which
+// are defined using their default values without calculating.
+func (vr *Variables) DefineLightScannerVariables(sCtx ScanContext, scanner VariableDefiner) error {
+	return vr.defineScannerVariables(sCtx, scanner, HeavyVars)
+}
+
+// HasHeavyVariables reports whether any of the variables is in HeavyVars.
+func (vr *Variables) HasHeavyVariables() bool {
+	for _, vid := range vr.list {
+		if containsVar(HeavyVars, vid) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsVar(vars []VariableType, vid VariableType) bool {
+	for _, v := range vars {
+		if v == vid {
+			return true
+		}
+	}
+	return false
+}
+
+func (vr *Variables) defineScannerVariables(sCtx ScanContext, scanner VariableDefiner, skip []VariableType) error {
+	if vr.maxBytes > 0 {
+		sCtx = &limitedScanContext{ScanContext: sCtx, maxBytes: vr.maxBytes}
+	}
+	var defaulted []VariableType
 	for _, vid := range vr.list {
+		if value, ok := vr.constants[vid]; ok {
+			if err := scanner.DefineVariable(vid.String(), value); err != nil {
+				return err
+			}
+			continue
+		}
+		if containsVar(skip, vid) {
+			if err := defineDefaultValue(vid, scanner); err != nil {
+				return err
+			}
+			continue
+		}
+
 		valuer := Valuers[vid]
-		value, err := valuer.Value(sCtx)
+		if vid == VarFileHash {
+			// Use the algorithm of the instance even if HashAlgorithm is changed after initialization.
+			valuer = hashValuer(vr.hashAlg)
+		}
+		if vid == VarFilePathAllowlisted && vr.allowlist != nil {
+			valuer = allowlistValuer(vr.allowlist)
+		}
+		if vid == VarScanId && vr.scanID != "" {
+			valuer = scanIdValuer(vr.scanID)
+		}
+		start := time.Now()
+		value, err := vr.value(vid, valuer, sCtx)
+		if vr.observer != nil {
+			vr.observer(vid, time.Since(start), err)
+		}
+		if err != nil && vr.errLogger != nil {
+			vr.errLogger(vid, err)
+		}
 
 		if err != nil || value == nil {
+			if vr.defaults != nil {
+				defaulted = append(defaulted, vid)
+			}
 			if e := defineDefaultValue(vid, scanner); e != nil {
 				if err != nil {
 					return fmt.Errorf("%s: %w", err, e)
@@ -275,20 +745,132 @@ func (vr *Variables) DefineScannerVariables(sCtx ScanContext, scanner VariableDe
 			continue
 		}
 
+		if b, ok := value.([]byte); ok {
+			value = hex.EncodeToString(b)
+		}
 		err = scanner.DefineVariable(vid.String(), value)
 		if err != nil {
 			return err
 		}
 	}
+	if vr.defaults != nil {
+		vr.defaults(defaulted)
+	}
+	return nil
+}
+
+// Snapshot calculates the values of the variables for the given scan context without scanning, e.g. to find out why a
+// rule does or does not match. The values are calculated as DefineScannerVariables does, so the value errors are handled
+// by ScanContext.HandleValueError and the variables get their default values if it does not abort. If it aborts, the
+// values calculated until then are returned along with the error.
+func (vr *Variables) Snapshot(sCtx ScanContext) (map[string]interface{}, error) {
+	values := make(snapshotDefiner, len(vr.list))
+	err := vr.DefineScannerVariables(sCtx, values)
+	return values, err
+}
+
+// snapshotDefiner implements the VariableDefiner interface to collect the variable values for Snapshot.
+type snapshotDefiner map[string]interface{}
+
+func (s snapshotDefiner) DefineVariable(name string, value interface{}) error {
+	s[name] = value
 	return nil
 }
 
 // Copy creates a new instance of Variables by deeply copying.
 // This should be used to create new Variables instances for each scanner thread.
 func (vr *Variables) Copy() *Variables {
-	return &Variables{
-		list: vr.Variables(),
+	cp := *vr
+	cp.list = vr.Variables()
+	cp.filtered = append([]VariableType(nil), vr.filtered...)
+	return &cp
+}
+
+// CacheConstants calculates the values of the variables which do not depend on the scan context, e.g. os and
+// os_linux, once, so that DefineScannerVariables does not calculate them for every scan. The cached values are shared
+// by the copies. Note that time_now is not cached, it is still calculated for every scan.
+func (vr *Variables) CacheConstants() {
+	constants := make(map[VariableType]interface{}, len(constantVars))
+	for _, vid := range constantVars {
+		value, err := Valuers[vid].Value(nil)
+		if err == nil && value != nil {
+			constants[vid] = value
+		}
+	}
+	vr.constants = constants
+}
+
+// SetRecoverPanics sets whether the panics of the Valuer implementations are recovered by DefineScannerVariables. A
+// recovered panic is converted to an error which is handled by ScanContext.HandleValueError, so the variable gets its
+// default value. It is disabled by default not to hide the bugs.
+func (vr *Variables) SetRecoverPanics(enabled bool) {
+	vr.recover = enabled
+}
+
+// SetMaxScanBytes limits the number of bytes each content derived variable, e.g. file_hash and file_header_hex, reads
+// from the scanned content. The limit applies to each read of the content separately, so the content within the limit
+// can be read by all the variables. A variable exceeding the limit returns ErrMaxScanBytes which is handled by
+// ScanContext.HandleValueError. Zero means no limit, which is the default.
+//
+// To apply the limit, the scan context is wrapped while calling the Valuer implementations, so a custom Valuer can not
+// type assert the scan context to its own type if the limit is set.
+func (vr *Variables) SetMaxScanBytes(n int64) {
+	vr.maxBytes = n
+}
+
+// value returns the value calculated by the given valuer, recovering its panic if enabled.
+func (vr *Variables) value(vid VariableType, valuer Valuer, sCtx ScanContext) (value interface{}, err error) {
+	if vr.recover {
+		defer func() {
+			if r := recover(); r != nil {
+				value, err = nil, fmt.Errorf("variable %s panicked: %v", vid, r)
+			}
+		}()
 	}
+	return valuer.Value(sCtx)
+}
+
+// SetPathAllowlist sets the glob patterns of the file_path_allowlisted variable for the instance instead of
+// PathAllowlist. A nil list reverts to PathAllowlist while an empty list allowlists nothing. It returns an error if
+// any of the patterns is malformed.
+func (vr *Variables) SetPathAllowlist(patterns []string) error {
+	if err := validatePatterns(patterns); err != nil {
+		return err
+	}
+	if patterns == nil {
+		vr.allowlist = nil
+		return nil
+	}
+	vr.allowlist = append([]string{}, patterns...)
+	return nil
+}
+
+// SetScanId sets the scan session id defined as scan_id variable, e.g. to correlate the matches with the logs of the
+// session. Otherwise, a unique id is generated by the first Init call. The copies made afterwards share the id.
+func (vr *Variables) SetScanId(id string) {
+	vr.scanID = id
+}
+
+// ScanId returns the scan session id of scan_id variable, which is empty until it is set or generated.
+func (vr *Variables) ScanId() string {
+	return vr.scanID
+}
+
+// SetErrorLogger sets the logger which is called by DefineScannerVariables for every value error, in addition to
+// ScanContext.HandleValueError.
+func (vr *Variables) SetErrorLogger(fn ErrorLogger) {
+	vr.errLogger = fn
+}
+
+// SetValueObserver sets the observer which is called by DefineScannerVariables after each variable value is calculated.
+func (vr *Variables) SetValueObserver(fn ValueObserver) {
+	vr.observer = fn
+}
+
+// SetDefaultsObserver sets the observer which is called by DefineScannerVariables once all the variables are defined.
+// The variables skipped by DefineLightScannerVariables and the constants cached by CacheConstants are not reported.
+func (vr *Variables) SetDefaultsObserver(fn DefaultsObserver) {
+	vr.defaults = fn
 }
 
 // Variables returns a copy of variables list.
@@ -298,19 +880,173 @@ func (vr *Variables) Variables() []VariableType {
 	return list
 }
 
+// Clear removes all the variables set by the last Init call. The other settings, e.g. SetErrorLogger and
+// SetMaxScanBytes, are kept. It is safe to call on a zero value.
+func (vr *Variables) Clear() {
+	vr.list = nil
+	vr.filtered = nil
+}
+
+// VariableDescription describes a variable for documentation.
+type VariableDescription struct {
+	Name string
+	// Type is the value type of the variable, one of MetaBool, MetaInt, MetaFloat, MetaString and MetaBytes.
+	Type MetaType
+	// File and Process report whether the variable is applicable for file and process scan.
+	File    bool
+	Process bool
+}
+
+// Describe returns the descriptions of the variables in the order of Variables.
+func (vr *Variables) Describe() []VariableDescription {
+	descs := make([]VariableDescription, 0, len(vr.list))
+	for _, vid := range vr.list {
+		descs = append(descs, VariableDescription{
+			Name:    vid.String(),
+			Type:    vid.Meta() & (MetaBool | MetaInt | MetaFloat | MetaString | MetaBytes),
+			File:    vid.IsFileApplicable(),
+			Process: vid.IsProcessApplicable(),
+		})
+	}
+	return descs
+}
+
+// Diff compares the variables with the other, e.g. a previous configuration. It returns the variables which exist only
+// in this instance as added, and the variables which exist only in the other as removed.
+func (vr *Variables) Diff(other *Variables) (added, removed []VariableType) {
+	var cur, prev [typeEnd]bool
+	for _, vid := range vr.list {
+		cur[vid] = true
+	}
+	for _, vid := range other.list {
+		prev[vid] = true
+	}
+	for _, vid := range vr.list {
+		if !prev[vid] {
+			added = append(added, vid)
+		}
+	}
+	for _, vid := range other.list {
+		if !cur[vid] {
+			removed = append(removed, vid)
+		}
+	}
+	return
+}
+
+// Equal reports whether the variables are the same as the other's regardless of their order, e.g. to check if the rules
+// compiled for the other can be reused. The settings of the instances, such as the scan limits, are not compared.
+func (vr *Variables) Equal(other *Variables) bool {
+	return vr.set() == other.set()
+}
+
+// Hash returns the hash of the variables regardless of their order to be used in the cache keys along with Equal. The
+// equal variables have the same hash across the processes using the same version of the package.
+func (vr *Variables) Hash() uint64 {
+	set := vr.set()
+	h := fnv.New64a()
+	for vid := VariableType(1); vid < typeEnd; vid++ {
+		if set[vid] {
+			_, _ = h.Write([]byte{byte(vid)})
+		}
+	}
+	return h.Sum64()
+}
+
+// set returns the variables as a set indexed by the variable types.
+func (vr *Variables) set() (set [typeEnd]bool) {
+	for _, vid := range vr.list {
+		set[vid] = true
+	}
+	return
+}
+
+// MarshalJSON implements the json.Marshaler interface. It encodes the variables as a list of variable names.
+func (vr *Variables) MarshalJSON() ([]byte, error) {
+	names := make([]string, 0, len(vr.list))
+	for _, vid := range vr.list {
+		names = append(names, vid.String())
+	}
+	return json.Marshal(names)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. It decodes a list of variable names as the Init functions
+// do, and returns an error if any of the names is unknown.
+func (vr *Variables) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	list := make([]VariableType, 0, len(names))
+	vmap := make(map[VariableType]struct{}, len(names)) // deduplicate if any.
+	for _, name := range names {
+		vid, err := ParseVariableType(name)
+		if err != nil {
+			return err
+		}
+		if _, ok := vmap[vid]; ok {
+			continue
+		}
+		list = append(list, vid)
+		vmap[vid] = struct{}{}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	vr.list = list
+	vr.filtered = nil
+	vr.hashAlg = HashAlgorithm
+	if vr.scanID == "" {
+		vr.scanID = newScanId()
+	}
+	return nil
+}
+
+// Validate returns an error if all the variables given to the last Init call are filtered since none of them is
+// applicable for the scan target, e.g. only process variables are given to InitFileVariables. It returns nil if the
+// given list is empty. It also returns an error if file_hash is used with an unsupported HashAlgorithm, or
+// file_path_allowlisted is used with a malformed PathAllowlist pattern.
+func (vr *Variables) Validate() error {
+	for _, vid := range vr.list {
+		if _, ok := hashAlgorithms[vr.hashAlg]; vid == VarFileHash && !ok {
+			return fmt.Errorf("unsupported hash algorithm: %q", vr.hashAlg)
+		}
+		if vid == VarFilePathAllowlisted && vr.allowlist == nil {
+			if err := validatePatterns(PathAllowlist); err != nil {
+				return err
+			}
+		}
+	}
+	if len(vr.list) > 0 || len(vr.filtered) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(vr.filtered))
+	for _, vid := range vr.filtered {
+		names = append(names, vid.String())
+	}
+	return fmt.Errorf("none of the variables is applicable for the scan target: %s", strings.Join(names, ", "))
+}
+
 func (vr *Variables) setVariables(vars []VariableType, metaMask MetaType) {
 	vr.list = []VariableType{}
+	vr.filtered = nil
+	vr.hashAlg = HashAlgorithm
+	if vr.scanID == "" {
+		vr.scanID = newScanId()
+	}
 	vmap := make(map[VariableType]struct{}, typeEnd) // deduplicate if any.
 
 	for _, vid := range vars {
 		if _, ok := vmap[vid]; ok {
 			continue
 		}
+		vmap[vid] = struct{}{}
 		if vid.Meta()&metaMask != 0 {
 			vr.list = append(vr.list, vid)
-			vmap[vid] = struct{}{}
+		} else {
+			vr.filtered = append(vr.filtered, vid)
 		}
 	}
+	// Sort to define the variables in the same order regardless of the order they are parsed.
+	sort.Slice(vr.list, func(i, j int) bool { return vr.list[i] < vr.list[j] })
 }
 
 func defineDefaultValue(vid VariableType, def VariableDefiner) error {
@@ -319,7 +1055,7 @@ func defineDefaultValue(vid VariableType, def VariableDefiner) error {
 		defVal interface{}
 	)
 
-	if meta&MetaString != 0 {
+	if meta&(MetaString|MetaBytes) != 0 {
 		defVal = ""
 	} else if meta&MetaInt != 0 {
 		defVal = int64(0)
@@ -373,16 +1109,45 @@ func varTimeNowFunc(_ ScanContext) (interface{}, error) {
 }
 
 func varFilePathFunc(sCtx ScanContext) (interface{}, error) {
-	p := sCtx.FilePath()
+	p := cleanFilePath(sCtx)
+	if p == "" {
+		return "", nil
+	}
+	return normalizePath(p), nil
+}
+
+// fileNamePath returns the file path used to compute the file name and its parts. Unlike file_path, the extended-length
+// path prefix of Windows is removed, e.g. \\?\C:\dir\file.exe is used as C:\dir\file.exe.
+func fileNamePath(sCtx ScanContext) (interface{}, error) {
+	p := cleanFilePath(sCtx)
 	if p == "" {
 		return "", nil
 	}
-	p = filepath.Clean(p)
-	return p, nil
+	return normalizePath(trimExtendedPathPrefix(p)), nil
+}
+
+// normalizePath applies PathNormalization to the given path.
+func normalizePath(p string) string {
+	if PathNormalization&PathLowerCase != 0 {
+		p = strings.ToLower(p)
+	}
+	if PathNormalization&PathForwardSlash != 0 {
+		p = filepath.ToSlash(p)
+	}
+	return p
+}
+
+// cleanFilePath returns the cleaned file path without the normalization, which must be used to access the file.
+func cleanFilePath(sCtx ScanContext) string {
+	p := sCtx.FilePath()
+	if p == "" {
+		return ""
+	}
+	return filepath.Clean(p)
 }
 
 func varFileNameFunc(sCtx ScanContext) (interface{}, error) {
-	p, err := varFilePathFunc(sCtx)
+	p, err := fileNamePath(sCtx)
 	if err != nil || p == nil || p.(string) == "" {
 		return p, err
 	}
@@ -394,7 +1159,7 @@ func varFileNameFunc(sCtx ScanContext) (interface{}, error) {
 }
 
 func varFileExtensionFunc(sCtx ScanContext) (interface{}, error) {
-	p, err := varFilePathFunc(sCtx)
+	p, err := fileNamePath(sCtx)
 	path := p.(string)
 	if err != nil || p == nil || path == "" {
 		return p, err
@@ -417,7 +1182,12 @@ func varFileReadonlyFunc(sCtx ScanContext) (interface{}, error) {
 	return info.Mode().Perm()&0222 == 0, nil
 }
 
+// intTimeHelper formats the given time as an integer, e.g. 20220102030405. It returns nil for the zero time, which the
+// times package reports when the time is not available on the platform or the file system, e.g. the access time.
 func intTimeHelper(t time.Time) (interface{}, error) {
+	if t.IsZero() {
+		return nil, nil
+	}
 	s := t.Format(intFileTimeLayout)
 	return strconv.ParseInt(s, 10, 64)
 }
@@ -435,7 +1205,7 @@ func varFileAccessedTimeFunc(sCtx ScanContext) (interface{}, error) {
 	if info == nil {
 		return nil, nil
 	}
-	ts := times.Get(info)
+	ts := FileTimes(info)
 	return intTimeHelper(ts.AccessTime())
 }
 
@@ -444,7 +1214,7 @@ func varFileChangedTimeFunc(sCtx ScanContext) (interface{}, error) {
 	if info == nil {
 		return nil, nil
 	}
-	ts := times.Get(info)
+	ts := FileTimes(info)
 	if ts.HasChangeTime() {
 		return intTimeHelper(ts.ChangeTime())
 	}
@@ -456,7 +1226,7 @@ func varFileBirthTimeFunc(sCtx ScanContext) (interface{}, error) {
 	if info == nil {
 		return nil, nil
 	}
-	ts := times.Get(info)
+	ts := FileTimes(info)
 	if ts.HasBirthTime() {
 		return intTimeHelper(ts.BirthTime())
 	}
@@ -518,3 +1288,618 @@ func varProcessCommandLineFunc(sCtx ScanContext) (interface{}, error) {
 	}
 	return proc.CmdlineWithContext(sCtx.Context())
 }
+
+func varProcessCmdlineLengthFunc(sCtx ScanContext) (interface{}, error) {
+	cmdline, err := varProcessCommandLineFunc(sCtx)
+	if err != nil || cmdline == nil {
+		return nil, err
+	}
+	return int64(len(cmdline.(string))), nil
+}
+
+func varProcessCmdlineSha256Func(sCtx ScanContext) (interface{}, error) {
+	cmdline, err := varProcessCommandLineFunc(sCtx)
+	if err != nil || cmdline == nil || cmdline.(string) == "" {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(cmdline.(string)))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// contentBuffer is implemented by the scan contexts which provide the scanned content from memory.
+type contentBuffer interface {
+	Buffer() []byte
+}
+
+// ErrNoContentReader is returned by ContentReaderProvider implementations to fall back to opening the file path.
+var ErrNoContentReader = errors.New("no content reader")
+
+// openContent opens the content of the scanned file. The content is read from the reader or the memory if the scan
+// context provides it, otherwise the file at the scan context's file path is opened. It returns nil if there is no
+// content to open.
+func openContent(sCtx ScanContext) (io.ReadCloser, error) {
+	if l, ok := sCtx.(*limitedScanContext); ok {
+		rc, err := openContent(l.ScanContext)
+		if err != nil || rc == nil {
+			return rc, err
+		}
+		return &limitedReader{rc: rc, remaining: l.maxBytes}, nil
+	}
+	if cp, ok := sCtx.(ContentReaderProvider); ok {
+		rc, err := cp.ContentReader()
+		if !errors.Is(err, ErrNoContentReader) {
+			return rc, err
+		}
+	}
+	if cb, ok := sCtx.(contentBuffer); ok {
+		return bufferContent{bytes.NewReader(cb.Buffer())}, nil
+	}
+	p := sCtx.FilePath()
+	if p == "" {
+		return nil, nil
+	}
+	return os.Open(p)
+}
+
+// bufferContent is the content of a buffer scan, it can be seeked unlike io.NopCloser.
+type bufferContent struct {
+	*bytes.Reader
+}
+
+func (bufferContent) Close() error { return nil }
+
+// defaultFileTimes returns the file times of the given file info using times.Get. The file info can provide its own
+// times by implementing the times.Timespec interface.
+func defaultFileTimes(info fs.FileInfo) times.Timespec {
+	if ts, ok := info.(times.Timespec); ok {
+		return ts
+	}
+	return times.Get(info)
+}
+
+func varFileHeaderHexFunc(sCtx ScanContext) (interface{}, error) {
+	rc, err := openContent(sCtx)
+	if err != nil || rc == nil {
+		return nil, nil
+	}
+	defer rc.Close()
+
+	buf := make([]byte, FileHeaderSize)
+	n, err := io.ReadFull(rc, buf)
+	if errors.Is(err, ErrMaxScanBytes) {
+		return nil, err
+	}
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, nil
+	}
+	return hex.EncodeToString(buf[:n]), nil
+}
+
+func varFileFirstLineFunc(sCtx ScanContext) (interface{}, error) {
+	rc, err := openContent(sCtx)
+	if err != nil || rc == nil {
+		return nil, nil
+	}
+	defer rc.Close()
+
+	buf := make([]byte, FirstLineMaxLength)
+	n, err := io.ReadFull(rc, buf)
+	if errors.Is(err, ErrMaxScanBytes) {
+		return nil, err
+	}
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, nil
+	}
+	line := buf[:n]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	return string(bytes.TrimSuffix(line, []byte("\r"))), nil
+}
+
+func varFileMimeTopFunc(sCtx ScanContext) (interface{}, error) {
+	typ, err := sniffContentType(sCtx)
+	if err != nil || typ == "" {
+		return nil, err
+	}
+	top, _, _ := strings.Cut(typ, "/")
+	return top, nil
+}
+
+func varFileSignedFunc(sCtx ScanContext) (interface{}, error) {
+	p := signedFilePath(sCtx)
+	if p == "" {
+		return nil, nil
+	}
+	return fileSigned(sCtx.Context(), p)
+}
+
+func varFileSignerFunc(sCtx ScanContext) (interface{}, error) {
+	p := signedFilePath(sCtx)
+	if p == "" {
+		return nil, nil
+	}
+	signer, err := fileSigner(sCtx.Context(), p)
+	if err != nil || signer == "" {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// signedFilePath returns the path of the scanned file if its signature should be verified, or "" otherwise.
+func signedFilePath(sCtx ScanContext) string {
+	if !VerifyFileSignatures {
+		return ""
+	}
+	if info := sCtx.FileInfo(); info == nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	return cleanFilePath(sCtx)
+}
+
+// sniffContentType detects the content type of the file using its first 512 bytes as http.DetectContentType does. It
+// returns "" if the file is empty or can not be read.
+func sniffContentType(sCtx ScanContext) (string, error) {
+	rc, err := openContent(sCtx)
+	if err != nil || rc == nil {
+		return "", nil
+	}
+	defer rc.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(rc, buf)
+	if errors.Is(err, ErrMaxScanBytes) {
+		return "", err
+	}
+	if (err != nil && err != io.EOF && err != io.ErrUnexpectedEOF) || n == 0 {
+		return "", nil
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+func varFileMimeFromExtFunc(sCtx ScanContext) (interface{}, error) {
+	ext, err := varFileExtensionFunc(sCtx)
+	if err != nil || ext == nil || ext.(string) == "" {
+		return nil, err
+	}
+	typ := mime.TypeByExtension("." + ext.(string))
+	if typ == "" {
+		return nil, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(typ)
+	if err != nil {
+		return nil, nil
+	}
+	return mediaType, nil
+}
+
+// errOpenFilesNotSupported is returned by the ProcessInfo wrappers when the underlying ProcessInfo does not support
+// counting the open files.
+var errOpenFilesNotSupported = errors.New("open files count is not supported")
+
+// processOpenFilesCount returns the number of open files of the process if the given ProcessInfo supports it.
+func processOpenFilesCount(ctx context.Context, proc ProcessInfo) (int, error) {
+	switch p := proc.(type) {
+	case ProcessOpenFilesCounter:
+		return p.OpenFilesCountWithContext(ctx)
+	case interface {
+		NumFDsWithContext(context.Context) (int32, error)
+	}:
+		n, err := p.NumFDsWithContext(ctx)
+		return int(n), err
+	}
+	return 0, errOpenFilesNotSupported
+}
+
+var errExeNotSupported = errors.New("process executable is not supported")
+
+// processExe returns the path of the process executable if the ProcessInfo implements ProcessExecutable.
+func processExe(ctx context.Context, proc ProcessInfo) (string, error) {
+	if p, ok := proc.(ProcessExecutable); ok {
+		return p.ExeWithContext(ctx)
+	}
+	return "", errExeNotSupported
+}
+
+func varProcessOpenFilesFunc(sCtx ScanContext) (interface{}, error) {
+	if !ProcessOpenFiles {
+		return nil, nil
+	}
+	proc := sCtx.ProcessInfo()
+	if proc == nil {
+		return nil, nil
+	}
+	n, err := processOpenFilesCount(sCtx.Context(), proc)
+	if err == errOpenFilesNotSupported {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return int64(n), nil
+}
+
+func varFileDirNameFunc(sCtx ScanContext) (interface{}, error) {
+	p, err := fileNamePath(sCtx)
+	if err != nil || p == nil || p.(string) == "" {
+		return nil, err
+	}
+	dir := filepath.Dir(p.(string))
+	base := filepath.Base(dir)
+	if base == "." || base == string(filepath.Separator) || dir == filepath.VolumeName(dir)+string(filepath.Separator) {
+		return "", nil
+	}
+	return base, nil
+}
+
+func varFileExtCountFunc(sCtx ScanContext) (interface{}, error) {
+	p, err := fileNamePath(sCtx)
+	if err != nil || p == nil || p.(string) == "" {
+		return nil, err
+	}
+	// Leading dots belong to the name of dotfiles, not to an extension.
+	base := strings.TrimLeft(filepath.Base(p.(string)), ".")
+	var count int64
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		for _, ext := range strings.Split(base[i+1:], ".") {
+			if ext != "" {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+// varFileExistsFunc checks the file path at the scan time rather than using the file info, so that the files removed
+// after they are enumerated are detected.
+func varFileExistsFunc(sCtx ScanContext) (interface{}, error) {
+	p := cleanFilePath(sCtx)
+	if p == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(p); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return nil, err
+	}
+	return true, nil
+}
+
+// varFileIsSymlinkFunc checks the file path itself since the file info of the scan context may follow the symbolic
+// links. A path which does not exist, e.g. a synthetic path or a file deleted before the scan, is not a symbolic link.
+func varFileIsSymlinkFunc(sCtx ScanContext) (interface{}, error) {
+	p := cleanFilePath(sCtx)
+	if p == "" {
+		return nil, nil
+	}
+	info, err := os.Lstat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return info.Mode()&fs.ModeSymlink != 0, nil
+}
+
+func varFileSymlinkTargetFunc(sCtx ScanContext) (interface{}, error) {
+	isSymlink, err := varFileIsSymlinkFunc(sCtx)
+	if err != nil || isSymlink != true {
+		return nil, err
+	}
+	return os.Readlink(cleanFilePath(sCtx))
+}
+
+var (
+	scannerUserOnce sync.Once
+	scannerUser     interface{}
+)
+
+// varScannerUserFunc looks up the current user once, since it does not change during the process lifetime.
+func varScannerUserFunc(_ ScanContext) (interface{}, error) {
+	scannerUserOnce.Do(func() {
+		if usr, err := user.Current(); err == nil {
+			scannerUser = usr.Username
+		}
+	})
+	return scannerUser, nil
+}
+
+// scanIdValuer returns the Valuer of the scan_id variable returning the given id.
+func scanIdValuer(id string) Valuer {
+	return ValueFunc(func(ScanContext) (interface{}, error) {
+		return id, nil
+	})
+}
+
+// newScanId generates a scan session id from the current time followed by random bytes, so that the ids are unique and
+// sorted by the time the sessions start.
+func newScanId() string {
+	var b [12]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixNano()))
+	// The time alone makes the id unique unless two sessions start at the same time, so a read error is ignored.
+	_, _ = rand.Read(b[8:])
+	return hex.EncodeToString(b[:])
+}
+
+// hashValuer returns the Valuer of the file_hash variable using the given algorithm.
+func hashValuer(alg string) Valuer {
+	return ValueFunc(func(sCtx ScanContext) (interface{}, error) {
+		return fileHash(sCtx, alg)
+	})
+}
+
+func varFileHashFunc(sCtx ScanContext) (interface{}, error) {
+	return fileHash(sCtx, HashAlgorithm)
+}
+
+// fileHash returns the hex encoded hash of the scanned file's content using the given algorithm. As the other content
+// derived variables, it has no value if the content can not be read.
+func fileHash(sCtx ScanContext, alg string) (interface{}, error) {
+	newHash, ok := hashAlgorithms[alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm: %q", alg)
+	}
+	rc, err := openContent(sCtx)
+	if err != nil || rc == nil {
+		return nil, nil
+	}
+	defer rc.Close()
+
+	h := newHash()
+	_, err = io.Copy(h, rc)
+	if errors.Is(err, ErrMaxScanBytes) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, nil
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func varFileSizeBucketFunc(sCtx ScanContext) (interface{}, error) {
+	info := sCtx.FileInfo()
+	if info == nil {
+		return nil, nil
+	}
+	size := info.Size()
+	bucket := sort.Search(len(FileSizeBuckets), func(i int) bool { return size < FileSizeBuckets[i] })
+	return int64(bucket), nil
+}
+
+func varFileExtCategoryFunc(sCtx ScanContext) (interface{}, error) {
+	ext, err := varFileExtensionFunc(sCtx)
+	if err != nil || ext == nil || sCtx.FilePath() == "" {
+		return nil, err
+	}
+	if category, ok := ExtensionCategories["."+strings.ToLower(ext.(string))]; ok {
+		return category, nil
+	}
+	return "other", nil
+}
+
+// varFilePrintableRatioFunc counts the printable ASCII characters and the whitespaces common in text files.
+func varFilePrintableRatioFunc(sCtx ScanContext) (interface{}, error) {
+	rc, err := openContent(sCtx)
+	if err != nil || rc == nil {
+		return nil, nil
+	}
+	defer rc.Close()
+
+	var total, printable int64
+	buf := make([]byte, 32<<10)
+	r := io.LimitReader(rc, MaxContentBytes)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			if (b >= 0x20 && b < 0x7f) || b == '\t' || b == '\n' || b == '\r' {
+				printable++
+			}
+		}
+		total += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, ErrMaxScanBytes) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, nil
+		}
+	}
+	if total == 0 {
+		return nil, nil
+	}
+	return float64(printable) / float64(total), nil
+}
+
+func varFileInTempFunc(sCtx ScanContext) (interface{}, error) {
+	return fileInDirs(sCtx, TempDirs), nil
+}
+
+func varFileInHomeFunc(sCtx ScanContext) (interface{}, error) {
+	return fileInDirs(sCtx, HomeDirs), nil
+}
+
+func varFileInSystemFunc(sCtx ScanContext) (interface{}, error) {
+	return fileInDirs(sCtx, SystemDirs), nil
+}
+
+// fileInDirs checks if the file is under any of the given directories. It returns nil if there is no file path.
+func fileInDirs(sCtx ScanContext, dirs []string) interface{} {
+	p := cleanFilePath(sCtx)
+	if p == "" {
+		return nil
+	}
+	p = trimExtendedPathPrefix(p)
+	for _, dir := range dirs {
+		if dir != "" && hasPathPrefix(p, filepath.Clean(dir)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPathPrefix checks if the path is under the directory. Both must be cleaned.
+func hasPathPrefix(p, dir string) bool {
+	if len(p) <= len(dir) {
+		return false
+	}
+	prefix := p[:len(dir)]
+	if prefix != dir && (runtime.GOOS != "windows" || !strings.EqualFold(prefix, dir)) {
+		return false
+	}
+	return os.IsPathSeparator(p[len(dir)]) || os.IsPathSeparator(dir[len(dir)-1])
+}
+
+func varFileEntropyFunc(sCtx ScanContext) (interface{}, error) {
+	rc, err := openContent(sCtx)
+	if err != nil || rc == nil {
+		return nil, nil
+	}
+	defer rc.Close()
+
+	var (
+		counts [256]int64
+		total  int64
+		buf    = make([]byte, 32<<10)
+	)
+	count := func(r io.Reader) error {
+		for {
+			n, err := r.Read(buf)
+			for _, b := range buf[:n] {
+				counts[b]++
+			}
+			total += int64(n)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	offsets, chunk := entropySamples(rc)
+	if offsets == nil {
+		err = count(io.LimitReader(rc, MaxContentBytes))
+	}
+	for _, off := range offsets {
+		if _, err = rc.(io.Seeker).Seek(off, io.SeekStart); err != nil {
+			break
+		}
+		if err = count(io.LimitReader(rc, chunk)); err != nil {
+			break
+		}
+	}
+	if errors.Is(err, ErrMaxScanBytes) {
+		return nil, err
+	}
+	if err != nil || total == 0 {
+		return nil, nil
+	}
+
+	var entropy float64
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / float64(total)
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy, nil
+}
+
+// entropySamples returns the offsets and the size of the chunks to read according to EntropySampling. It returns nil
+// offsets if the leading MaxContentBytes bytes must be read, and the content is left at its beginning in that case.
+func entropySamples(rc io.Reader) ([]int64, int64) {
+	chunk := EntropyChunkSize
+	if chunk > MaxContentBytes {
+		chunk = MaxContentBytes
+	}
+	s, ok := rc.(io.Seeker)
+	if EntropySampling == SamplePrefix || chunk <= 0 || !ok {
+		return nil, 0
+	}
+	size, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0
+	}
+	if _, err = s.Seek(0, io.SeekStart); err != nil || size <= MaxContentBytes {
+		return nil, 0
+	}
+
+	n := MaxContentBytes / chunk
+	last := size - chunk
+	if n == 1 {
+		return []int64{0}, chunk
+	}
+	offsets := make([]int64, 0, n)
+	switch EntropySampling {
+	case SampleEven:
+		for i := int64(0); i < n; i++ {
+			offsets = append(offsets, i*last/(n-1))
+		}
+	case SampleExponential:
+		offsets = append(offsets, 0)
+		for off := chunk; off < last && int64(len(offsets)) < n-1; off *= 2 {
+			offsets = append(offsets, off)
+		}
+		offsets = append(offsets, last)
+	default:
+		return nil, 0
+	}
+	return offsets, chunk
+}
+
+func varFilePathAllowlistedFunc(sCtx ScanContext) (interface{}, error) {
+	return pathAllowlisted(sCtx, PathAllowlist)
+}
+
+// allowlistValuer returns the Valuer of the file_path_allowlisted variable using the given patterns.
+func allowlistValuer(patterns []string) Valuer {
+	return ValueFunc(func(sCtx ScanContext) (interface{}, error) {
+		return pathAllowlisted(sCtx, patterns)
+	})
+}
+
+// pathAllowlisted checks if the file path or any of its parent directories matches any of the patterns.
+func pathAllowlisted(sCtx ScanContext, patterns []string) (interface{}, error) {
+	p := cleanFilePath(sCtx)
+	if p == "" {
+		return nil, nil
+	}
+	p = trimExtendedPathPrefix(p)
+	if runtime.GOOS == "windows" {
+		p = strings.ToLower(p)
+	}
+	for {
+		for _, pattern := range patterns {
+			if runtime.GOOS == "windows" {
+				pattern = strings.ToLower(pattern)
+			}
+			matched, err := filepath.Match(filepath.Clean(pattern), p)
+			if err != nil {
+				return nil, fmt.Errorf("allowlist pattern %q: %w", pattern, err)
+			}
+			if matched {
+				return true, nil
+			}
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return false, nil
+		}
+		p = parent
+	}
+}
+
+// validatePatterns returns an error if any of the glob patterns is malformed.
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("allowlist pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package variables
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ufCompressed is the UF_COMPRESSED file flag of the transparently compressed files, see sys/stat.h.
+const ufCompressed = 0x00000020
+
+func varFileCompressedFunc(sCtx ScanContext) (interface{}, error) {
+	info := sCtx.FileInfo()
+	if info == nil {
+		return nil, nil
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st == nil {
+		return nil, nil
+	}
+	return st.Flags&ufCompressed != 0, nil
+}
+
+// varFileEncryptedFunc is not implemented since APFS encrypts the whole volume rather than the individual files.
+var varFileEncryptedFunc = noopVarFunc
+
+func varProcessElevatedFunc(sCtx ScanContext) (interface{}, error) {
+	pid := sCtx.Pid()
+	if pid <= 0 {
+		return nil, nil
+	}
+	kinfo, err := unix.SysctlKinfoProc("kern.proc.pid", pid)
+	if err != nil {
+		return nil, err
+	}
+	return kinfo.Eproc.Ucred.Uid == 0, nil
+}
+
+// The /tmp, /var and /etc directories are the symbolic links to the ones under /private.
+func defaultTempDirs() []string {
+	return []string{"/tmp", "/var/tmp", "/private/tmp", "/private/var/tmp", os.TempDir()}
+}
+
+func defaultHomeDirs() []string {
+	return []string{"/Users", "/var/root", "/private/var/root", os.Getenv("HOME")}
+}
+
+func defaultSystemDirs() []string {
+	return []string{"/System", "/Library", "/bin", "/sbin", "/usr", "/etc", "/private/etc"}
+}
+
+// fileSigned verifies the code signature of the file using the codesign tool, which exits with 1 if the signature is
+// missing or invalid.
+func fileSigned(ctx context.Context, path string) (interface{}, error) {
+	err := exec.CommandContext(ctx, "codesign", "--verify", "--strict", "--", path).Run()
+	if err == nil {
+		return true, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && ctx.Err() == nil {
+		return false, nil
+	}
+	return nil, err
+}
+
+// fileSigner returns the leaf authority of the file's code signature, which codesign prints to stderr first among the
+// certificate chain. It returns "" if the file is not signed or ad-hoc signed.
+func fileSigner(ctx context.Context, path string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "codesign", "--display", "--verbose=2", "--", path)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && ctx.Err() == nil {
+			return "", nil
+		}
+		return "", err
+	}
+	s := bufio.NewScanner(&stderr)
+	for s.Scan() {
+		if line := s.Text(); strings.HasPrefix(line, "Authority=") {
+			return strings.TrimPrefix(line, "Authority="), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,102 @@
+package variables
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Inode flags of FS_IOC_GETFLAGS, see linux/fs.h.
+const (
+	fsComprFl   = 0x00000004
+	fsEncryptFl = 0x00000800
+)
+
+// fileFlags returns the inode flags of the scanned file. It reports false if the file is not a regular file on the disk
+// or its file system does not support the flags, e.g. the compression flag is supported by Btrfs.
+func fileFlags(sCtx ScanContext) (uint32, bool, error) {
+	info := sCtx.FileInfo()
+	if info == nil || !info.Mode().IsRegular() {
+		return 0, false, nil
+	}
+	if _, ok := info.Sys().(*syscall.Stat_t); !ok {
+		return 0, false, nil
+	}
+	p := cleanFilePath(sCtx)
+	if p == "" {
+		return 0, false, nil
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	flags, err := unix.IoctlGetUint32(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		if errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EINVAL) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return flags, true, nil
+}
+
+func varFileCompressedFunc(sCtx ScanContext) (interface{}, error) {
+	flags, ok, err := fileFlags(sCtx)
+	if !ok {
+		return nil, err
+	}
+	return flags&fsComprFl != 0, nil
+}
+
+func varFileEncryptedFunc(sCtx ScanContext) (interface{}, error) {
+	flags, ok, err := fileFlags(sCtx)
+	if !ok {
+		return nil, err
+	}
+	return flags&fsEncryptFl != 0, nil
+}
+
+// varProcessElevatedFunc checks the effective user id of the process in /proc/<pid>/status, since it is not available
+// through ProcessInfo.
+func varProcessElevatedFunc(sCtx ScanContext) (interface{}, error) {
+	pid := sCtx.Pid()
+	if pid <= 0 {
+		return nil, nil
+	}
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/status")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		// Uid line holds the real, effective, saved set and file system user ids.
+		if fields := strings.Fields(line); len(fields) >= 3 && fields[0] == "Uid:" {
+			return fields[2] == "0", nil
+		}
+	}
+	return nil, errors.New("uid is not found in process status")
+}
+
+func defaultTempDirs() []string {
+	return []string{"/tmp", "/var/tmp", "/dev/shm", os.TempDir()}
+}
+
+func defaultHomeDirs() []string {
+	return []string{"/home", "/root", os.Getenv("HOME")}
+}
+
+func defaultSystemDirs() []string {
+	return []string{"/bin", "/boot", "/etc", "/lib", "/lib32", "/lib64", "/sbin", "/usr"}
+}
+
+// Linux has no common code signing format for the executables, so the file signature variables are not supported.
+func fileSigned(context.Context, string) (interface{}, error) { return nil, nil }
+
+func fileSigner(context.Context, string) (string, error) { return "", nil }
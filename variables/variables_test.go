@@ -2,12 +2,19 @@ package variables_test
 
 import (
 	"context"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -108,6 +115,17 @@ func (m *processInfoMock) CmdlineWithContext(ctx context.Context) (string, error
 	return args.String(0), args.Error(1)
 }
 
+type openFilesProcessInfoMock struct {
+	processInfoMock
+}
+
+var _ ProcessOpenFilesCounter = (*openFilesProcessInfoMock)(nil)
+
+func (m *openFilesProcessInfoMock) OpenFilesCountWithContext(ctx context.Context) (int, error) {
+	args := m.Called(ctx)
+	return args.Int(0), args.Error(1)
+}
+
 func checkMetaAll(vars []VariableType, mask MetaType) bool {
 	for _, v := range vars {
 		if v.Meta()&mask == 0 {
@@ -129,6 +147,44 @@ func TestVariables_InitProcessScanVariables(t *testing.T) {
 	require.True(t, checkMetaAll(vr.Variables(), MetaProcess))
 }
 
+func TestVariables_InitFileProcessVariables(t *testing.T) {
+	var vr Variables
+	vr.InitFileProcessVariables(append([]VariableType{0}, AllVars...))
+	require.ElementsMatch(t, AllVars, vr.Variables())
+	require.True(t, checkMetaAll(vr.Variables(), MetaFileProcess))
+
+	vr.InitFileProcessVariables(AllVarsOnlyProcs)
+	require.ElementsMatch(t, AllVarsOnlyProcs, vr.Variables())
+}
+
+func TestVariables_InitOptions(t *testing.T) {
+	var vr Variables
+	vr.InitFileVariables(All(), Without(VarFileHash), Without(VarFileExists, VarFileIsSymlink))
+	require.NotContains(t, vr.Variables(), VarFileHash)
+	require.NotContains(t, vr.Variables(), VarFileExists)
+	require.NotContains(t, vr.Variables(), VarFileIsSymlink)
+	require.NotContains(t, vr.Variables(), VarProcessId)
+	require.Contains(t, vr.Variables(), VarFilePath)
+
+	var expected Variables
+	expected.InitFileVariables(All())
+	added, removed := vr.Diff(&expected)
+	require.Empty(t, added)
+	require.Equal(t, []VariableType{VarFileExists, VarFileIsSymlink, VarFileHash}, removed)
+
+	vr.InitProcessVariables(All(), Without(VarProcessId, VarProcessName))
+	require.NotContains(t, vr.Variables(), VarProcessId)
+	require.NotContains(t, vr.Variables(), VarProcessName)
+	require.Contains(t, vr.Variables(), VarProcessPath)
+
+	vr.InitFileProcessVariables([]VariableType{VarOs, VarFileName}, Without(VarOs), Without(VarFileName))
+	require.Empty(t, vr.Variables())
+
+	vars := []VariableType{VarOs, VarFileName}
+	vr.InitFileVariables(vars, Without(VarOs))
+	require.Equal(t, []VariableType{VarOs, VarFileName}, vars)
+}
+
 func TestVariables_InitAll(t *testing.T) {
 	var vr Variables
 	vr.InitProcessVariables(AllVars)
@@ -144,7 +200,7 @@ func TestVariables_DefineCompilerVariables(t *testing.T) {
 	tests := []struct {
 		name    string
 		vars    []VariableType
-		initer  func(*Variables, []VariableType)
+		initer  func(*Variables, []VariableType, ...InitOption)
 		args    args
 		wantErr bool
 	}{
@@ -290,7 +346,7 @@ func TestVariables_DefineScannerVariables(t *testing.T) {
 	tests := []struct {
 		name    string
 		vars    []VariableType
-		initer  func(*Variables, []VariableType)
+		initer  func(*Variables, []VariableType, ...InitOption)
 		args    args
 		wantErr bool
 	}{
@@ -453,9 +509,327 @@ func TestVariables_DefineScannerVariables_valueError(t *testing.T) {
 	require.Same(t, errValTest, err)
 }
 
+func TestVariables_DefineScannerVariables_valueErrorPolicies(t *testing.T) {
+	orig := Valuers
+	t.Cleanup(func() {
+		Valuers = orig
+	})
+
+	errTest := errors.New("test error")
+	Valuers[VarFilePath] = ValueFunc(func(_ ScanContext) (interface{}, error) {
+		return nil, errTest
+	})
+
+	var vr Variables
+	vr.InitFileVariables([]VariableType{VarFilePath, VarFileName})
+
+	var sCtx ScanContextImpl
+	sCtx.SetFilePath("/tmp/test.txt")
+
+	sCtx.SetHandleValueError(IgnoreValueErrors)
+	scanner := new(variableDefinerMock)
+	scanner.On("DefineVariable", VarFilePath.String(), defaultVarValue(VarFilePath.Meta())).Return(nil).Once()
+	scanner.On("DefineVariable", VarFileName.String(), "test.txt").Return(nil).Once()
+	require.NoError(t, vr.DefineScannerVariables(&sCtx, scanner))
+	scanner.AssertExpectations(t)
+
+	sCtx.SetHandleValueError(AbortOnValueError)
+	scanner = new(variableDefinerMock)
+	scanner.On("DefineVariable", VarFilePath.String(), defaultVarValue(VarFilePath.Meta())).Return(nil).Once()
+	require.ErrorIs(t, vr.DefineScannerVariables(&sCtx, scanner), errTest)
+	scanner.AssertExpectations(t)
+}
+
+func TestVariables_DefineScannerVariables_processOpenFiles(t *testing.T) {
+	var vr Variables
+	vr.InitProcessVariables([]VariableType{VarProcessOpenFiles})
+
+	pi := new(openFilesProcessInfoMock)
+	pi.On("OpenFilesCountWithContext", context.Background()).Return(7, nil).Once()
+
+	sCtx := new(scanContextMock)
+	sCtx.On("Context").Return(context.Background())
+	sCtx.On("ProcessInfo").Return(pi)
+
+	scanner := new(variableDefinerMock)
+	scanner.On("DefineVariable", VarProcessOpenFiles.String(), int64(7)).Return(nil).Once()
+
+	require.NoError(t, vr.DefineScannerVariables(sCtx, scanner))
+	scanner.AssertExpectations(t)
+	pi.AssertExpectations(t)
+
+	// No ProcessInfo, or disabled variable results in the default value.
+	sCtx = new(scanContextMock)
+	sCtx.On("ProcessInfo").Return(nil)
+	scanner = new(variableDefinerMock)
+	scanner.On("DefineVariable", VarProcessOpenFiles.String(), int64(0)).Return(nil).Twice()
+	require.NoError(t, vr.DefineScannerVariables(sCtx, scanner))
+
+	ProcessOpenFiles = false
+	t.Cleanup(func() {
+		ProcessOpenFiles = true
+	})
+	require.NoError(t, vr.DefineScannerVariables(sCtx, scanner))
+	scanner.AssertExpectations(t)
+}
+
+func TestVariables_DefineScannerVariables_errorLogger(t *testing.T) {
+	orig := Valuers
+	t.Cleanup(func() {
+		Valuers = orig
+	})
+
+	errTest := errors.New("test error")
+	Valuers[VarFilePath] = ValueFunc(func(_ ScanContext) (interface{}, error) {
+		return nil, errTest
+	})
+
+	type logged struct {
+		vid VariableType
+		err error
+	}
+	var logs []logged
+
+	var vr Variables
+	vr.InitFileVariables([]VariableType{VarFilePath, VarOs})
+	vr.SetErrorLogger(func(vid VariableType, err error) {
+		logs = append(logs, logged{vid, err})
+	})
+
+	sCtx := new(scanContextMock)
+	sCtx.On("HandleValueError").Return(nil).Times(2)
+
+	scanner := new(variableDefinerMock)
+	scanner.On("DefineVariable", VarFilePath.String(), defaultVarValue(VarFilePath.Meta())).Return(nil).Times(2)
+	scanner.On("DefineVariable", VarOs.String(), runtime.GOOS).Return(nil).Times(2)
+
+	require.NoError(t, vr.DefineScannerVariables(sCtx, scanner))
+	require.Equal(t, []logged{{VarFilePath, errTest}}, logs)
+
+	// Copies keep the logger.
+	require.NoError(t, vr.Copy().DefineScannerVariables(sCtx, scanner))
+	require.Len(t, logs, 2)
+
+	scanner.AssertExpectations(t)
+	sCtx.AssertExpectations(t)
+}
+
+func TestVariables_DefineScannerVariables_valueObserver(t *testing.T) {
+	orig := Valuers
+	t.Cleanup(func() {
+		Valuers = orig
+	})
+
+	errTest := errors.New("test error")
+	Valuers[VarFilePath] = ValueFunc(func(_ ScanContext) (interface{}, error) {
+		time.Sleep(time.Millisecond)
+		return nil, errTest
+	})
+
+	observed := make(map[VariableType]int)
+	var vr Variables
+	vr.InitFileVariables([]VariableType{VarFilePath, VarOs})
+	vr.SetValueObserver(func(vid VariableType, dur time.Duration, err error) {
+		observed[vid]++
+		require.GreaterOrEqual(t, dur, time.Duration(0))
+		if vid == VarFilePath {
+			require.GreaterOrEqual(t, dur, time.Millisecond)
+			require.Same(t, errTest, err)
+		} else {
+			require.NoError(t, err)
+		}
+	})
+
+	sCtx := new(scanContextMock)
+	sCtx.On("HandleValueError").Return(nil).Once()
+
+	scanner := new(variableDefinerMock)
+	scanner.On("DefineVariable", mock.Anything, mock.Anything).Return(nil)
+
+	require.NoError(t, vr.DefineScannerVariables(sCtx, scanner))
+	require.Equal(t, map[VariableType]int{VarFilePath: 1, VarOs: 1}, observed)
+}
+
+func TestVariables_DefineScannerVariables_defaultsObserver(t *testing.T) {
+	orig := Valuers
+	t.Cleanup(func() {
+		Valuers = orig
+	})
+	Valuers[VarFilePath] = ValueFunc(func(_ ScanContext) (interface{}, error) {
+		return nil, errors.New("test error")
+	})
+
+	var calls int
+	var defaulted []VariableType
+	var vr Variables
+	vr.InitFileProcessVariables([]VariableType{VarFilePath, VarOs, VarProcessName, VarProcessUserName, VarFileHash})
+	vr.SetDefaultsObserver(func(vars []VariableType) {
+		calls++
+		defaulted = vars
+	})
+
+	// The process variables default during a file scan, as well as the erroring file_path.
+	var sCtx ScanContextImpl
+	sCtx.SetHandleValueError(func(VariableDefiner, VariableType, error) error { return nil })
+	require.NoError(t, vr.DefineScannerVariables(&sCtx, nopDefiner{}))
+	require.Equal(t, 1, calls)
+	require.Equal(t, []VariableType{VarFilePath, VarProcessUserName, VarProcessName, VarFileHash}, defaulted)
+
+	// The skipped heavy variables are not reported.
+	require.NoError(t, vr.DefineLightScannerVariables(&sCtx, nopDefiner{}))
+	require.Equal(t, 2, calls)
+	require.Equal(t, []VariableType{VarFilePath, VarProcessUserName, VarProcessName}, defaulted)
+
+	Valuers[VarFilePath] = orig[VarFilePath]
+	sCtx.SetFilePath("/dir/file.txt")
+	require.NoError(t, vr.DefineLightScannerVariables(&sCtx, nopDefiner{}))
+	require.Equal(t, []VariableType{VarProcessUserName, VarProcessName}, defaulted)
+}
+
+func TestVariables_ScanId(t *testing.T) {
+	var vr Variables
+	require.Empty(t, vr.ScanId())
+	vr.InitFileVariables([]VariableType{VarScanId, VarFileName})
+	id := vr.ScanId()
+	require.Len(t, id, 24)
+
+	// The id is stable across the scans, the copies and the Init calls of the session.
+	snapshot := func(vr *Variables) interface{} {
+		t.Helper()
+		var sCtx ScanContextImpl
+		values, err := vr.Snapshot(&sCtx)
+		require.NoError(t, err)
+		return values[VarScanId.String()]
+	}
+	require.Equal(t, id, snapshot(&vr))
+	require.Equal(t, id, snapshot(&vr))
+	require.Equal(t, id, snapshot(vr.Copy()))
+	vr.InitFileProcessVariables([]VariableType{VarScanId})
+	require.Equal(t, id, snapshot(&vr))
+
+	// Another session gets another id.
+	var other Variables
+	other.InitFileVariables([]VariableType{VarScanId})
+	require.NotEqual(t, id, other.ScanId())
+	require.Equal(t, other.ScanId(), snapshot(&other))
+
+	other.SetScanId("session-1")
+	require.Equal(t, "session-1", snapshot(&other))
+
+	got, err := Valuers[VarScanId].Value(new(scanContextMock))
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+func TestVariables_CacheConstants(t *testing.T) {
+	orig := Valuers
+	t.Cleanup(func() {
+		Valuers = orig
+	})
+
+	var vr Variables
+	vr.InitFileVariables([]VariableType{VarOs, VarOsLinux, VarTimeNow})
+	vr.CacheConstants()
+	cp := vr.Copy()
+
+	var calls []VariableType
+	for _, vid := range []VariableType{VarOs, VarOsLinux, VarTimeNow} {
+		vid := vid
+		Valuers[vid] = ValueFunc(func(_ ScanContext) (interface{}, error) {
+			calls = append(calls, vid)
+			return nil, nil
+		})
+	}
+
+	scanner := new(variableDefinerMock)
+	scanner.On("DefineVariable", VarOs.String(), runtime.GOOS).Return(nil).Times(2)
+	scanner.On("DefineVariable", VarOsLinux.String(), runtime.GOOS == "linux").Return(nil).Times(2)
+	scanner.On("DefineVariable", VarTimeNow.String(), int64(0)).Return(nil).Times(2)
+
+	require.NoError(t, vr.DefineScannerVariables(new(scanContextMock), scanner))
+	require.NoError(t, cp.DefineScannerVariables(new(scanContextMock), scanner))
+	require.Equal(t, []VariableType{VarTimeNow, VarTimeNow}, calls)
+	scanner.AssertExpectations(t)
+}
+
+type nopDefiner struct{}
+
+func (nopDefiner) DefineVariable(string, interface{}) error { return nil }
+
+func BenchmarkVariables_DefineScannerVariables(b *testing.B) {
+	dir := b.TempDir()
+	sCtxs := make([]*ScanContextImpl, 1000)
+	for i := range sCtxs {
+		path := filepath.Join(dir, fmt.Sprintf("file%d.txt", i))
+		require.NoError(b, os.WriteFile(path, nil, 0666))
+		info, err := os.Stat(path)
+		require.NoError(b, err)
+
+		sCtxs[i] = new(ScanContextImpl)
+		sCtxs[i].SetFilePath(path)
+		sCtxs[i].SetFileInfo(info)
+	}
+
+	vars := []VariableType{VarOs, VarOsLinux, VarOsWindows, VarOsDarwin, VarTimeNow, VarFilePath, VarFileName}
+	for _, cached := range []bool{false, true} {
+		b.Run(fmt.Sprintf("cached=%t", cached), func(b *testing.B) {
+			var vr Variables
+			vr.InitFileVariables(vars)
+			if cached {
+				vr.CacheConstants()
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, sCtx := range sCtxs {
+					if err := vr.DefineScannerVariables(sCtx, nopDefiner{}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestVariables_DefineScannerVariables_recoverPanics(t *testing.T) {
+	orig := Valuers
+	t.Cleanup(func() {
+		Valuers = orig
+	})
+
+	Valuers[VarFilePath] = ValueFunc(func(_ ScanContext) (interface{}, error) {
+		panic("test panic")
+	})
+
+	var vr Variables
+	vr.InitFileVariables([]VariableType{VarFilePath, VarOs})
+
+	sCtx := new(scanContextMock)
+	sCtx.On("HandleValueError").Return(nil).Once()
+
+	scanner := new(variableDefinerMock)
+	scanner.On("DefineVariable", VarFilePath.String(), defaultVarValue(VarFilePath.Meta())).Return(nil).Once()
+	// os is defined before file_path panics.
+	scanner.On("DefineVariable", VarOs.String(), runtime.GOOS).Return(nil).Twice()
+
+	require.Panics(t, func() {
+		_ = vr.DefineScannerVariables(sCtx, scanner)
+	})
+
+	vr.SetRecoverPanics(true)
+	var logged error
+	vr.SetErrorLogger(func(vid VariableType, err error) {
+		logged = err
+	})
+	require.NoError(t, vr.DefineScannerVariables(sCtx, scanner))
+	require.EqualError(t, logged, "variable file_path panicked: test panic")
+	scanner.AssertExpectations(t)
+	sCtx.AssertExpectations(t)
+}
+
 func defaultVarValue(meta MetaType) (defVal interface{}) {
 
-	if meta&MetaString != 0 {
+	if meta&(MetaString|MetaBytes) != 0 {
 		defVal = ""
 	} else if meta&MetaInt != 0 {
 		defVal = int64(0)
@@ -469,6 +843,153 @@ func defaultVarValue(meta MetaType) (defVal interface{}) {
 	return
 }
 
+type mapDefiner map[string]interface{}
+
+func (m mapDefiner) DefineVariable(name string, value interface{}) error {
+	m[name] = value
+	return nil
+}
+
+func TestVariables_SetMaxScanBytes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "content.txt")
+	require.NoError(t, os.WriteFile(path, []byte("0123456789abcdef0123456789"), 0o666))
+	var sCtx ScanContextImpl
+	sCtx.SetFilePath(path)
+
+	var vr Variables
+	vr.InitFileVariables([]VariableType{VarFileHeaderHex, VarFileHash, VarFilePrintableRatio, VarFileEntropy})
+	values := make(mapDefiner)
+	require.NoError(t, vr.DefineScannerVariables(&sCtx, values))
+	require.Equal(t, 1.0, values[VarFilePrintableRatio.String()])
+
+	// The limit applies to each variable, so the content at the limit is read by all of them.
+	vr.SetMaxScanBytes(26)
+	limited := make(mapDefiner)
+	require.NoError(t, vr.DefineScannerVariables(&sCtx, limited))
+	require.Equal(t, values, limited)
+	for _, vid := range []VariableType{VarFileHeaderHex, VarFileHash, VarFileEntropy} {
+		require.NotEmpty(t, limited[vid.String()], vid.String())
+	}
+
+	vr.SetMaxScanBytes(25)
+	require.ErrorIs(t, vr.DefineScannerVariables(&sCtx, make(mapDefiner)), ErrMaxScanBytes)
+
+	// The limit applies to each call.
+	vr.InitFileVariables([]VariableType{VarFileHeaderHex})
+	vr.SetMaxScanBytes(16)
+	for i := 0; i < 2; i++ {
+		values = make(mapDefiner)
+		require.NoError(t, vr.DefineScannerVariables(&sCtx, values))
+		require.Equal(t, hex.EncodeToString([]byte("0123456789abcdef")), values[VarFileHeaderHex.String()])
+	}
+
+	buf := NewBufferScanContext("buffer.bin", make([]byte, 20))
+	vr.SetMaxScanBytes(10)
+	require.ErrorIs(t, vr.Copy().DefineScannerVariables(buf, make(mapDefiner)), ErrMaxScanBytes)
+	vr.SetMaxScanBytes(0)
+	require.NoError(t, vr.DefineScannerVariables(buf, make(mapDefiner)))
+}
+
+func TestVariables_bytesValue(t *testing.T) {
+	orig := Valuers[VarFileHeaderHex]
+	t.Cleanup(func() {
+		Valuers[VarFileHeaderHex] = orig
+	})
+	errTest := errors.New("test error")
+	var value interface{}
+	Valuers[VarFileHeaderHex] = ValueFunc(func(ScanContext) (interface{}, error) {
+		if value == nil {
+			return nil, errTest
+		}
+		return value, nil
+	})
+
+	var vr Variables
+	vr.InitFileVariables([]VariableType{VarFileHeaderHex})
+	var sCtx ScanContextImpl
+	sCtx.SetHandleValueError(IgnoreValueErrors)
+	for _, v := range []interface{}{[]byte("MZ\x00\xff"), "4d5a00ff"} {
+		value = v
+		values := make(mapDefiner)
+		require.NoError(t, vr.DefineScannerVariables(&sCtx, values))
+		require.Equal(t, "4d5a00ff", values[VarFileHeaderHex.String()])
+	}
+
+	value = nil
+	values := make(mapDefiner)
+	require.NoError(t, vr.DefineScannerVariables(&sCtx, values))
+	require.Equal(t, "", values[VarFileHeaderHex.String()])
+
+	values = make(mapDefiner)
+	require.NoError(t, vr.DefineCompilerVariables(values))
+	require.Equal(t, "", values[VarFileHeaderHex.String()])
+}
+
+func TestVariables_DefineLightScannerVariables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "content.txt")
+	require.NoError(t, os.WriteFile(path, []byte("content"), 0o666))
+	var sCtx ScanContextImpl
+	sCtx.SetFilePath(path)
+
+	var vr Variables
+	vr.InitFileVariables([]VariableType{VarFileName, VarFileHash, VarFilePrintableRatio})
+	require.True(t, vr.HasHeavyVariables())
+	values := make(mapDefiner)
+	require.NoError(t, vr.DefineLightScannerVariables(&sCtx, values))
+	require.Equal(t, mapDefiner{
+		VarFileName.String():           "content.txt",
+		VarFileHash.String():           "",
+		VarFilePrintableRatio.String(): float64(0),
+	}, values)
+
+	values = make(mapDefiner)
+	require.NoError(t, vr.DefineScannerVariables(&sCtx, values))
+	require.Equal(t, 1.0, values[VarFilePrintableRatio.String()])
+	require.NotEmpty(t, values[VarFileHash.String()])
+
+	vr.InitFileVariables([]VariableType{VarFileName})
+	require.False(t, vr.HasHeavyVariables())
+}
+
+func TestVariables_Snapshot(t *testing.T) {
+	path, info := writeFile(t, "snapshot.txt", []byte("test"))
+	var sCtx ScanContextImpl
+	sCtx.SetFilePath(path)
+	sCtx.SetFileInfo(info)
+
+	var vr Variables
+	vr.InitFileVariables([]VariableType{VarOs, VarFileName, VarFileExtension, VarFileSizeBucket, VarProcessId})
+	values, err := vr.Snapshot(&sCtx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"os":               runtime.GOOS,
+		"file_name":        "snapshot.txt",
+		"file_extension":   "txt",
+		"file_size_bucket": int64(0),
+	}, values)
+
+	orig := Valuers
+	t.Cleanup(func() {
+		Valuers = orig
+	})
+	errTest := errors.New("test error")
+	Valuers[VarFileName] = ValueFunc(func(_ ScanContext) (interface{}, error) {
+		return nil, errTest
+	})
+
+	sCtx.SetHandleValueError(IgnoreValueErrors)
+	values, err = vr.Snapshot(&sCtx)
+	require.NoError(t, err)
+	require.Equal(t, "", values["file_name"])
+
+	sCtx.SetHandleValueError(AbortOnValueError)
+	values, err = vr.Snapshot(&sCtx)
+	require.ErrorIs(t, err, errTest)
+	require.Equal(t, "", values["file_name"])
+	require.Equal(t, runtime.GOOS, values["os"])
+	require.NotContains(t, values, "file_extension")
+}
+
 func TestVariables_Copy(t *testing.T) {
 	vr1 := new(Variables)
 	vr1.InitFileVariables(AllVars)
@@ -478,3 +999,287 @@ func TestVariables_Copy(t *testing.T) {
 		t.Errorf("Variables.Copy() = %v, want %v", got, vr1)
 	}
 }
+
+func TestVariables_order(t *testing.T) {
+	vars := []VariableType{VarFileName, VarProcessId, VarOs, VarFileName, VarTimeNow, VarFilePath}
+	expect := []VariableType{VarOs, VarTimeNow, VarFilePath, VarFileName}
+
+	for i := 0; i < 10; i++ {
+		rand.Shuffle(len(vars), func(i, j int) { vars[i], vars[j] = vars[j], vars[i] })
+
+		var vr Variables
+		vr.InitFileVariables(vars)
+		require.Equal(t, expect, vr.Variables())
+
+		compiler := new(variableDefinerMock)
+		var defined []string
+		compiler.On("DefineVariable", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			defined = append(defined, args.String(0))
+		})
+		require.NoError(t, vr.DefineCompilerVariables(compiler))
+		require.Equal(t, []string{"os", "time_now", "file_path", "file_name"}, defined)
+	}
+}
+
+func TestVariables_DefaultsSnapshot(t *testing.T) {
+	var vr Variables
+	require.Empty(t, vr.DefaultsSnapshot())
+
+	vr.InitFileProcessVariables([]VariableType{VarFileHeaderHex, VarFileEntropy, VarProcessId, VarFileReadonly, VarOs})
+	require.Equal(t, map[string]interface{}{
+		"os":              "",
+		"file_readonly":   false,
+		"process_id":      int64(0),
+		"file_header_hex": "",
+		"file_entropy":    float64(0),
+	}, vr.DefaultsSnapshot())
+
+	// The order is stable for the variables decoded from JSON as well.
+	var decoded Variables
+	require.NoError(t, json.Unmarshal([]byte(`["file_entropy","process_id","os","file_header_hex","file_readonly"]`),
+		&decoded))
+	require.Equal(t, vr.Variables(), decoded.Variables())
+
+	all := new(Variables)
+	all.InitFileProcessVariables(AllVars)
+	for name, value := range all.DefaultsSnapshot() {
+		vid, err := ParseVariableType(name)
+		require.NoError(t, err)
+		require.Equal(t, defaultVarValue(vid.Meta()), value, name)
+	}
+}
+
+func TestVariables_Diff(t *testing.T) {
+	var prev, cur Variables
+	prev.InitFileVariables([]VariableType{VarFilePath, VarFileName, VarOs})
+	cur.InitFileVariables([]VariableType{VarOs, VarFileName, VarFileExtension, VarTimeNow})
+
+	added, removed := cur.Diff(&prev)
+	require.Equal(t, []VariableType{VarTimeNow, VarFileExtension}, added)
+	require.Equal(t, []VariableType{VarFilePath}, removed)
+
+	added, removed = prev.Diff(&cur)
+	require.Equal(t, []VariableType{VarFilePath}, added)
+	require.Equal(t, []VariableType{VarTimeNow, VarFileExtension}, removed)
+
+	added, removed = cur.Diff(cur.Copy())
+	require.Empty(t, added)
+	require.Empty(t, removed)
+
+	added, removed = cur.Diff(new(Variables))
+	require.Equal(t, cur.Variables(), added)
+	require.Empty(t, removed)
+}
+
+func TestVariables_EqualHash(t *testing.T) {
+	var a, b, c, empty Variables
+	a.InitFileVariables([]VariableType{VarFileName, VarOs, VarFilePath})
+	b.InitFileVariables([]VariableType{VarFilePath, VarFileName, VarOs, VarOs})
+	c.InitFileVariables([]VariableType{VarFileName, VarOs})
+
+	require.True(t, a.Equal(&b))
+	require.True(t, b.Equal(&a))
+	require.Equal(t, a.Hash(), b.Hash())
+	require.True(t, a.Equal(a.Copy()))
+
+	require.False(t, a.Equal(&c))
+	require.NotEqual(t, a.Hash(), c.Hash())
+	require.False(t, c.Equal(&empty))
+	require.NotEqual(t, c.Hash(), empty.Hash())
+	require.True(t, empty.Equal(new(Variables)))
+
+	// The filtered variables are not a part of the set.
+	var d Variables
+	d.InitFileVariables([]VariableType{VarFileName, VarOs, VarProcessName})
+	require.True(t, c.Equal(&d))
+	require.Equal(t, c.Hash(), d.Hash())
+
+	// The decoded variables are equal regardless of the order they are encoded in.
+	var decoded Variables
+	require.NoError(t, json.Unmarshal([]byte(`["os","file_path","file_name"]`), &decoded))
+	require.True(t, a.Equal(&decoded))
+	require.Equal(t, a.Hash(), decoded.Hash())
+}
+
+func TestVariables_Validate(t *testing.T) {
+	var vr Variables
+	require.NoError(t, vr.Validate())
+
+	vr.InitFileVariables([]VariableType{VarProcessId, VarProcessName, VarProcessId})
+	require.Empty(t, vr.Variables())
+	err := vr.Validate()
+	require.EqualError(t, err, "none of the variables is applicable for the scan target: process_id, process_name")
+	require.EqualError(t, vr.Copy().Validate(), err.Error())
+
+	vr.InitProcessVariables([]VariableType{VarProcessId, VarProcessName})
+	require.NoError(t, vr.Validate())
+
+	// Partially filtered variables are valid.
+	vr.InitFileVariables([]VariableType{VarProcessId, VarFileName})
+	require.NoError(t, vr.Validate())
+
+	vr.InitFileVariables([]VariableType{})
+	require.Empty(t, vr.Variables())
+	require.NoError(t, vr.Validate())
+
+	vr.InitFileVariables(nil)
+	require.NoError(t, vr.Validate())
+}
+
+func TestVariableType_IsApplicable(t *testing.T) {
+	// File variables are applicable for process scan as well, for the process's executable file.
+	require.True(t, VarFileName.IsFileApplicable())
+	require.True(t, VarFileName.IsProcessApplicable())
+
+	require.False(t, VarProcessId.IsFileApplicable())
+	require.True(t, VarProcessId.IsProcessApplicable())
+
+	require.False(t, VariableType(0).IsFileApplicable())
+	require.False(t, VariableType(0).IsProcessApplicable())
+
+	for _, vid := range AllVars {
+		require.True(t, vid.IsFileApplicable() || vid.IsProcessApplicable(), vid.String())
+	}
+}
+
+func TestVariables_Clear(t *testing.T) {
+	var vr Variables
+	vr.Clear()
+	require.Empty(t, vr.Variables())
+
+	vr.InitFileVariables([]VariableType{VarProcessId})
+	require.Error(t, vr.Validate())
+	vr.Clear()
+	require.NoError(t, vr.Validate())
+
+	vr.InitFileVariables([]VariableType{VarFileName})
+	vr.Clear()
+	require.Empty(t, vr.Variables())
+	require.NoError(t, vr.Validate())
+	values := make(mapDefiner)
+	require.NoError(t, vr.DefineCompilerVariables(values))
+	require.Empty(t, values)
+
+	vr.InitProcessVariables([]VariableType{VarProcessId, VarOs})
+	require.Equal(t, []VariableType{VarOs, VarProcessId}, vr.Variables())
+}
+
+func TestVariables_Describe(t *testing.T) {
+	var vr Variables
+	vr.InitFileVariables([]VariableType{VarProcessId, VarFileName, VarFileReadonly})
+	require.Equal(t, []VariableDescription{
+		{Name: "file_name", Type: MetaString, File: true, Process: true},
+		{Name: "file_readonly", Type: MetaBool, File: true, Process: true},
+	}, vr.Describe())
+
+	vr.InitFileProcessVariables(AllVars)
+	descs := vr.Describe()
+	require.Len(t, descs, len(AllVars))
+	for i, vid := range vr.Variables() {
+		desc := descs[i]
+		require.Equal(t, vid.String(), desc.Name)
+		require.Equal(t, vid.Meta()&^MetaFileProcess, desc.Type, desc.Name)
+		require.Contains(t, []MetaType{MetaBool, MetaInt, MetaFloat, MetaString, MetaBytes}, desc.Type, desc.Name)
+		require.Equal(t, vid.IsFileApplicable(), desc.File, desc.Name)
+		require.Equal(t, vid.IsProcessApplicable(), desc.Process, desc.Name)
+	}
+
+	require.Empty(t, new(Variables).Describe())
+}
+
+func TestDerive(t *testing.T) {
+	errTransform := errors.New("transform error")
+	var calls int
+	depth := Derive(Valuers[VarFilePath], func(v interface{}) (interface{}, error) {
+		calls++
+		p := v.(string)
+		if p == "" {
+			return nil, errTransform
+		}
+		return int64(strings.Count(p, string(filepath.Separator))), nil
+	})
+
+	p := filepath.Join(string(filepath.Separator)+"dir", "sub", "file.txt")
+	sCtx := new(scanContextMock)
+	sCtx.On("FilePath").Return(p)
+	got, err := depth.Value(sCtx)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), got)
+	require.Equal(t, 1, calls)
+
+	// The errors of transform are returned.
+	sCtx = new(scanContextMock)
+	sCtx.On("FilePath").Return("")
+	_, err = depth.Value(sCtx)
+	require.ErrorIs(t, err, errTransform)
+	require.Equal(t, 2, calls)
+
+	// The errors and nil values of the base are returned without calling transform.
+	errBase := errors.New("base error")
+	never := func(interface{}) (interface{}, error) {
+		t.Fatal("transform is called")
+		return nil, nil
+	}
+	got, err = Derive(ValueFunc(func(ScanContext) (interface{}, error) { return nil, errBase }), never).Value(sCtx)
+	require.ErrorIs(t, err, errBase)
+	require.Nil(t, got)
+	got, err = Derive(ValueFunc(func(ScanContext) (interface{}, error) { return nil, nil }), never).Value(sCtx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+func TestParseVariableType(t *testing.T) {
+	for _, vid := range AllVars {
+		got, err := ParseVariableType(vid.String())
+		require.NoError(t, err)
+		require.Equal(t, vid, got)
+	}
+
+	_, err := ParseVariableType("")
+	require.Error(t, err)
+	_, err = ParseVariableType("unknown_variable")
+	require.Error(t, err)
+}
+
+func TestVariables_JSON(t *testing.T) {
+	var vr Variables
+	vr.InitFileVariables([]VariableType{VarFilePath, VarOs, VarFileName})
+
+	data, err := json.Marshal(&vr)
+	require.NoError(t, err)
+	require.JSONEq(t, `["os","file_path","file_name"]`, string(data))
+
+	var got Variables
+	require.NoError(t, json.Unmarshal(data, &got))
+	require.Equal(t, vr.Variables(), got.Variables())
+
+	var empty Variables
+	data, err = json.Marshal(&empty)
+	require.NoError(t, err)
+	require.JSONEq(t, `[]`, string(data))
+
+	err = json.Unmarshal([]byte(`["file_path","unknown_variable"]`), &got)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown_variable")
+}
+
+func TestVariables_JSON_scanId(t *testing.T) {
+	var vr Variables
+	vr.InitFileVariables([]VariableType{VarScanId})
+	data, err := json.Marshal(&vr)
+	require.NoError(t, err)
+
+	// The decoded variables start a session of their own as the Init functions do.
+	var got Variables
+	require.NoError(t, json.Unmarshal(data, &got))
+	id := got.ScanId()
+	require.Len(t, id, 24)
+	var sCtx ScanContextImpl
+	values, err := got.Snapshot(&sCtx)
+	require.NoError(t, err)
+	require.Equal(t, id, values[VarScanId.String()])
+
+	// The id of the session is kept if the variables are decoded again.
+	require.NoError(t, json.Unmarshal(data, &got))
+	require.Equal(t, id, got.ScanId())
+}
@@ -4,8 +4,12 @@
 package variables
 
 import (
+	"io/fs"
+	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 
 	"golang.org/x/sys/unix"
 )
@@ -14,12 +18,25 @@ func varFileHiddenFunc(sCtx ScanContext) (interface{}, error) {
 	return strings.HasPrefix(filepath.Base(sCtx.FilePath()), "."), nil
 }
 
+// varFileExecutableFunc checks if any of the execute bits is set.
+func varFileExecutableFunc(sCtx ScanContext) (interface{}, error) {
+	info := sCtx.FileInfo()
+	if info == nil {
+		return nil, nil
+	}
+	return info.Mode().Perm()&0111 != 0, nil
+}
+
 var (
 	varFileSystemFunc     = noopVarFunc
-	varFileCompressedFunc = noopVarFunc
-	varFileEncryptedFunc  = noopVarFunc
+	varFileAttributesFunc = noopVarFunc
 )
 
+// trimExtendedPathPrefix returns the path as is since the extended-length path prefix is specific to Windows.
+func trimExtendedPathPrefix(p string) string {
+	return p
+}
+
 func varProcessSessionIdFunc(sCtx ScanContext) (interface{}, error) {
 	pid := sCtx.Pid()
 	if pid <= 0 {
@@ -31,3 +48,34 @@ func varProcessSessionIdFunc(sCtx ScanContext) (interface{}, error) {
 	}
 	return int64(sid), nil
 }
+
+func fileGid(info fs.FileInfo) (uint32, bool) {
+	if info == nil {
+		return 0, false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st == nil {
+		return 0, false
+	}
+	return st.Gid, true
+}
+
+func varFileOwnerGidFunc(sCtx ScanContext) (interface{}, error) {
+	gid, ok := fileGid(sCtx.FileInfo())
+	if !ok {
+		return nil, nil
+	}
+	return int64(gid), nil
+}
+
+func varFileOwnerGroupNameFunc(sCtx ScanContext) (interface{}, error) {
+	gid, ok := fileGid(sCtx.FileInfo())
+	if !ok {
+		return nil, nil
+	}
+	grp, err := user.LookupGroupId(strconv.FormatUint(uint64(gid), 10))
+	if err != nil {
+		return nil, err
+	}
+	return grp.Name, nil
+}
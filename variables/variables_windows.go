@@ -4,8 +4,14 @@
 package variables
 
 import (
+	"context"
+	"errors"
 	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
 	"syscall"
+	"unsafe"
 
 	"golang.org/x/sys/windows"
 )
@@ -37,6 +43,64 @@ func varFileEncryptedFunc(sCtx ScanContext) (interface{}, error) {
 	return hasFileAttr(sCtx.FileInfo(), windows.FILE_ATTRIBUTE_ENCRYPTED), nil
 }
 
+// varFileAttributesFunc returns the raw attribute bitmask of the file, so that the rules can test the combinations of
+// the attributes, e.g. (file_attributes & 0x6) == 0x6 for the hidden system files. The common bits are:
+//
+//	0x1     FILE_ATTRIBUTE_READONLY
+//	0x2     FILE_ATTRIBUTE_HIDDEN
+//	0x4     FILE_ATTRIBUTE_SYSTEM
+//	0x20    FILE_ATTRIBUTE_ARCHIVE
+//	0x80    FILE_ATTRIBUTE_NORMAL, set only if no other attribute is set
+//	0x100   FILE_ATTRIBUTE_TEMPORARY
+//	0x200   FILE_ATTRIBUTE_SPARSE_FILE
+//	0x400   FILE_ATTRIBUTE_REPARSE_POINT
+//	0x800   FILE_ATTRIBUTE_COMPRESSED
+//	0x1000  FILE_ATTRIBUTE_OFFLINE
+//	0x2000  FILE_ATTRIBUTE_NOT_CONTENT_INDEXED
+//	0x4000  FILE_ATTRIBUTE_ENCRYPTED
+//	0x40000 FILE_ATTRIBUTE_RECALL_ON_OPEN, set for the cloud files which are not downloaded yet
+func varFileAttributesFunc(sCtx ScanContext) (interface{}, error) {
+	info := sCtx.FileInfo()
+	if info == nil {
+		return nil, nil
+	}
+	fileAttrs, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok || fileAttrs == nil {
+		return nil, nil
+	}
+	return int64(fileAttrs.FileAttributes), nil
+}
+
+var (
+	varFileOwnerGidFunc       = noopVarFunc
+	varFileOwnerGroupNameFunc = noopVarFunc
+)
+
+// trimExtendedPathPrefix removes the extended-length path prefix, \\?\ for the drive paths and \\?\UNC\ for the UNC
+// paths, e.g. \\?\UNC\server\share\file.txt becomes \\server\share\file.txt.
+func trimExtendedPathPrefix(p string) string {
+	const prefix, uncPrefix = `\\?\`, `\\?\UNC\`
+	if len(p) >= len(uncPrefix) && strings.EqualFold(p[:len(uncPrefix)], uncPrefix) {
+		return `\\` + p[len(uncPrefix):]
+	}
+	return strings.TrimPrefix(p, prefix)
+}
+
+// varFileExecutableFunc checks the file extension since Windows does not have an execute permission bit.
+func varFileExecutableFunc(sCtx ScanContext) (interface{}, error) {
+	path := sCtx.FilePath()
+	if path == "" {
+		return nil, nil
+	}
+	ext := filepath.Ext(path)
+	for _, e := range ExecutableExtensions {
+		if strings.EqualFold(ext, e) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func varProcessSessionIdFunc(sCtx ScanContext) (interface{}, error) {
 	pid := sCtx.Pid()
 	if pid <= 0 {
@@ -50,3 +114,156 @@ func varProcessSessionIdFunc(sCtx ScanContext) (interface{}, error) {
 	}
 	return int64(sessionId), nil
 }
+
+func varProcessElevatedFunc(sCtx ScanContext) (interface{}, error) {
+	pid := sCtx.Pid()
+	if pid <= 0 {
+		return nil, nil
+	}
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(h)
+
+	var token windows.Token
+	if err = windows.OpenProcessToken(h, windows.TOKEN_QUERY, &token); err != nil {
+		return nil, err
+	}
+	defer token.Close()
+	return token.IsElevated(), nil
+}
+
+// systemRoot returns the Windows directory, e.g. C:\Windows.
+func systemRoot() string {
+	if dir := os.Getenv("SystemRoot"); dir != "" {
+		return dir
+	}
+	return `C:\Windows`
+}
+
+func defaultTempDirs() []string {
+	return []string{os.Getenv("TEMP"), os.Getenv("TMP"), filepath.Join(systemRoot(), "Temp")}
+}
+
+func defaultHomeDirs() []string {
+	drive := os.Getenv("SystemDrive")
+	if drive == "" {
+		drive = "C:"
+	}
+	return []string{drive + `\Users`, os.Getenv("USERPROFILE")}
+}
+
+func defaultSystemDirs() []string {
+	return []string{systemRoot()}
+}
+
+// fileSigned verifies the Authenticode signature embedded in the file and its certificate chain without checking the
+// revocation, which may require network access. The files signed through a catalog are reported as not signed.
+func fileSigned(ctx context.Context, path string) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo := &windows.WinTrustFileInfo{FilePath: p}
+	fileInfo.Size = uint32(unsafe.Sizeof(*fileInfo))
+	data := &windows.WinTrustData{
+		UIChoice:                        windows.WTD_UI_NONE,
+		RevocationChecks:                windows.WTD_REVOKE_NONE,
+		UnionChoice:                     windows.WTD_CHOICE_FILE,
+		StateAction:                     windows.WTD_STATEACTION_VERIFY,
+		FileOrCatalogOrBlobOrSgnrOrCert: unsafe.Pointer(fileInfo),
+	}
+	data.Size = uint32(unsafe.Sizeof(*data))
+	verifyErr := windows.WinVerifyTrustEx(windows.InvalidHWND, &windows.WINTRUST_ACTION_GENERIC_VERIFY_V2, data)
+	data.StateAction = windows.WTD_STATEACTION_CLOSE
+	_ = windows.WinVerifyTrustEx(windows.InvalidHWND, &windows.WINTRUST_ACTION_GENERIC_VERIFY_V2, data)
+
+	var errno syscall.Errno
+	switch {
+	case verifyErr == nil:
+		return true, nil
+	case !errors.As(verifyErr, &errno):
+		return nil, verifyErr
+	}
+	// The errors other than these report that the signature is missing, invalid or not trusted.
+	switch windows.Handle(errno) {
+	case windows.TRUST_E_PROVIDER_UNKNOWN, windows.TRUST_E_ACTION_UNKNOWN, windows.TRUST_E_SUBJECT_FORM_UNKNOWN:
+		return nil, verifyErr
+	}
+	return false, nil
+}
+
+// CMSG_SIGNER_INFO_PARAM parameter of CryptMsgGetParam, see wincrypt.h.
+const cmsgSignerInfoParam = 6
+
+var (
+	modcrypt32           = windows.NewLazySystemDLL("crypt32.dll")
+	procCryptMsgGetParam = modcrypt32.NewProc("CryptMsgGetParam")
+	procCryptMsgClose    = modcrypt32.NewProc("CryptMsgClose")
+)
+
+// cmsgSignerInfo is the leading part of CMSG_SIGNER_INFO structure which identifies the signing certificate.
+type cmsgSignerInfo struct {
+	Version      uint32
+	Issuer       windows.CertNameBlob
+	SerialNumber windows.CryptIntegerBlob
+}
+
+// fileSigner returns the display name of the subject of the certificate which signed the Authenticode signature
+// embedded in the file. It does not verify the signature. It returns "" if the file does not have one.
+func fileSigner(ctx context.Context, path string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return "", err
+	}
+	var encoding, contentType, formatType uint32
+	var store, msg windows.Handle
+	err = windows.CryptQueryObject(windows.CERT_QUERY_OBJECT_FILE, unsafe.Pointer(p),
+		windows.CERT_QUERY_CONTENT_FLAG_PKCS7_SIGNED_EMBED, windows.CERT_QUERY_FORMAT_FLAG_BINARY, 0,
+		&encoding, &contentType, &formatType, &store, &msg, nil)
+	if err != nil {
+		if errors.Is(err, syscall.Errno(windows.CRYPT_E_NO_MATCH)) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer windows.CertCloseStore(store, 0)
+	defer procCryptMsgClose.Call(uintptr(msg)) // nolint:errcheck
+
+	var size uint32
+	r, _, err := procCryptMsgGetParam.Call(uintptr(msg), cmsgSignerInfoParam, 0, 0, uintptr(unsafe.Pointer(&size)))
+	if r == 0 {
+		return "", err
+	}
+	// The buffer is allocated as uint64s to align the structure.
+	buf := make([]uint64, (size+7)/8)
+	r, _, err = procCryptMsgGetParam.Call(uintptr(msg), cmsgSignerInfoParam, 0, uintptr(unsafe.Pointer(&buf[0])),
+		uintptr(unsafe.Pointer(&size)))
+	if r == 0 {
+		return "", err
+	}
+	signerInfo := (*cmsgSignerInfo)(unsafe.Pointer(&buf[0]))
+
+	certInfo := windows.CertInfo{Issuer: signerInfo.Issuer, SerialNumber: signerInfo.SerialNumber}
+	cert, err := windows.CertFindCertificateInStore(store, windows.X509_ASN_ENCODING|windows.PKCS_7_ASN_ENCODING, 0,
+		windows.CERT_FIND_SUBJECT_CERT, unsafe.Pointer(&certInfo), nil)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CertFreeCertificateContext(cert)
+
+	n := windows.CertGetNameString(cert, windows.CERT_NAME_SIMPLE_DISPLAY_TYPE, 0, nil, nil, 0)
+	if n <= 1 {
+		return "", nil
+	}
+	name := make([]uint16, n)
+	windows.CertGetNameString(cert, windows.CERT_NAME_SIMPLE_DISPLAY_TYPE, 0, nil, &name[0], n)
+	return windows.UTF16ToString(name), nil
+}